@@ -1,26 +1,43 @@
 package agent
 
 import (
+	"bytes"
 	"fmt"
 	"os/exec"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/tidyoux/router/agent/config"
 	"github.com/tidyoux/router/common/types"
 	"github.com/tidyoux/router/server/handler"
 	log "github.com/sirupsen/logrus"
-	"github.com/tidyoux/goutils"
+)
+
+var (
+	errTaskCanceled = fmt.Errorf("task canceled")
+	// errTaskGone tells the task is no longer the agent's, the server has finished it,
+	// e.g. for timeout, or another agent has taken over its lease.
+	errTaskGone    = fmt.Errorf("task taken away by server")
+	errStepTimeout = fmt.Errorf("step timeout")
 )
 
 type Agent struct {
 	cfg    *config.Config
 	client *Client
+
+	// replaced is closed once by replaceOnce when a newer agent of the same
+	// worker takes over, the task watch and the main loop may both see it.
+	replaced    chan struct{}
+	replaceOnce sync.Once
 }
 
 func New(cfg *config.Config) *Agent {
 	return &Agent{
-		cfg:    cfg,
-		client: NewClient(cfg.URL),
+		cfg:      cfg,
+		client:   NewClient(cfg.URL),
+		replaced: make(chan struct{}),
 	}
 }
 
@@ -30,48 +47,117 @@ func (a *Agent) Init() error {
 	return a.client.Login(a.cfg.WorkerID, a.cfg.WorkerKey)
 }
 
+// Replaced returns a channel which is closed when the agent is replaced
+// by a newer login of the same worker, the agent stops working then.
+func (a *Agent) Replaced() <-chan struct{} {
+	return a.replaced
+}
+
+func (a *Agent) isReplaced() bool {
+	select {
+	case <-a.replaced:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkReplaced closes replaced if err tells the agent has been replaced.
+func (a *Agent) checkReplaced(err error) bool {
+	if err == nil || err.Error() != handler.ErrAgentReplaced.Error() {
+		return false
+	}
+
+	a.replaceOnce.Do(func() {
+		log.Warnf("agent of worker %d replaced by a newer login, stop working", a.cfg.WorkerID)
+		close(a.replaced)
+	})
+
+	return true
+}
+
+// Work claims tasks one by one and runs them until there is none, so that several
+// agents of the same worker never run the same task.
 func (a *Agent) Work() {
-	resp, err := a.client.ListTask()
-	if err != nil {
-		if err.Error() == handler.ErrInvalidAgentToken.Error() {
-			err = a.Init()
-			if err == nil {
+	for !a.isReplaced() {
+		task, err := a.client.ClaimTask()
+		if err != nil {
+			if a.checkReplaced(err) {
 				return
 			}
+
+			if err.Error() == handler.ErrInvalidAgentToken.Error() {
+				err = a.Init()
+				if err == nil {
+					return
+				}
+			}
+
+			log.Errorf("claim task failed, %v", err)
+			return
 		}
 
-		log.Errorf("list task failed, %v", err)
-		return
-	}
+		if task == nil {
+			return
+		}
 
-	for _, task := range resp.Tasks {
-		success, detail, err := a.process(task)
-		if err != nil {
-			log.Errorf("process task (id: %d, params: %s) failed, %v",
-				task.ID, task.Params, err)
+		if !a.run(task) {
 			return
 		}
+	}
+}
+
+// run processes the claimed task and reports how it finished, it returns false if
+// the agent should stop working for now.
+func (a *Agent) run(task *types.Task) bool {
+	success, detail, err := a.process(task)
+	if err == errTaskGone {
+		log.Warnf("task (id: %d, params: %s) stop processing, %v",
+			task.ID, task.Params, err)
+		return true
+	}
+
+	canceled := err == errTaskCanceled
+	if err != nil && !canceled {
+		if a.checkReplaced(err) {
+			return false
+		}
+
+		log.Errorf("process task (id: %d, params: %s) failed, %v",
+			task.ID, task.Params, err)
+		return false
+	}
 
+	if canceled {
+		err = a.client.ReportCanceled(task.ID, detail)
+	} else {
 		err = a.client.FinishTask(task.ID, success, detail)
-		if err != nil {
-			log.Errorf("finish task (id: %d, params: %s) failed, %v",
-				task.ID, task.Params, err)
-		} else {
-			log.Infof("finish process task (id: %d, params: %s)",
-				task.ID, task.Params)
+	}
+	if err != nil {
+		if a.checkReplaced(err) {
+			return false
 		}
+
+		log.Errorf("finish task (id: %d, params: %s) failed, %v",
+			task.ID, task.Params, err)
+	} else {
+		log.Infof("finish process task (id: %d, params: %s, canceled: %v)",
+			task.ID, task.Params, canceled)
 	}
+
+	return true
 }
 
+// process runs the steps of task, it returns errTaskCanceled with the detail
+// if the task is stopped for a cancel request, errTaskGone if the server has
+// finished the task. A step which runs out of its or the task's timeout fails
+// the task.
 func (a *Agent) process(task *types.Task) (bool, string, error) {
 	log.Infof("start process task (id: %d, params: %s, process: %d, status: %d, detail: %s)",
 		task.ID, task.Params, task.Progress, task.Status, task.Detail)
 
-	if task.Status == types.TaskRecord {
-		err := a.client.AcceptTask(task.ID)
-		if err != nil {
-			return false, "", fmt.Errorf("accept task failed, %v", err)
-		}
+	if task.CancelRequested {
+		return false, fmt.Sprintf("canceled before step at index %d", task.Progress), errTaskCanceled
 	}
 
 	if len(task.Params) == 0 {
@@ -89,19 +175,113 @@ func (a *Agent) process(task *types.Task) (bool, string, error) {
 		return false, "invalid task progress", nil
 	}
 
+	watch := a.watchTask(task)
+	defer watch.stop()
+
+	var deadline time.Time
+	if taskCfg.Timeout > 0 {
+		deadline = time.Now().Add(taskCfg.Timeout)
+	}
+
+	// failures records the failed attempts of retried steps, they are kept at the head
+	// of the task detail, which each step replaces.
+	var failures []string
 	for i := int(task.Progress); i < len(taskCfg.Steps); i++ {
 		step := taskCfg.Steps[i]
-		detail, err := a.processStep(step, taskCfg.WorkDir, params[1:])
+
+		var (
+			name    string
+			detail  string
+			err     error
+			timeout time.Duration
+		)
+		for attempt := 1; ; attempt++ {
+			name = fmt.Sprintf("step at index %d(%s)", i, step.Name)
+			if step.Retry.Attempts > 1 {
+				name += fmt.Sprintf(" attempt %d/%d", attempt, step.Retry.Attempts)
+			}
+
+			timeout = step.Timeout
+			if !deadline.IsZero() {
+				left := time.Until(deadline)
+				if left <= 0 {
+					return false, fmt.Sprintf("timeout: task not finished in %s, before %s",
+						taskCfg.Timeout, name), nil
+				}
+
+				if timeout == 0 || left < timeout {
+					timeout = left
+				}
+			}
+
+			detail, err = a.processStep(step, taskCfg.WorkDir, params[1:], watch, timeout)
+			if err == nil || attempt >= step.Retry.Attempts || !retryable(step.Retry, err) {
+				break
+			}
+
+			backoff := step.Retry.Backoff << uint(attempt-1)
+			log.Warnf("task (id: %d) %s failed, %v, retry in %s", task.ID, name, err, backoff)
+
+			failures = append(failures, fmt.Sprintf("%s failed, %v", name, err))
+			retryDetail := fmt.Sprintf("%s, retry in %s:\n%s", strings.Join(failures, "\n"), backoff, detail)
+			if len(retryDetail) > types.MaxTaskDetailLen {
+				retryDetail = retryDetail[:types.MaxTaskDetailLen]
+			}
+			err = a.client.UpdateTask(task.ID, int8(i), retryDetail)
+			if err != nil {
+				if a.checkReplaced(err) {
+					return false, "", err
+				}
+
+				return false, "", fmt.Errorf("update task at step index %d failed, %v", i, err)
+			}
+
+			if !watch.wait(backoff) {
+				detail, err = "", watch.err
+				break
+			}
+		}
+
+		var earlier string
+		if len(failures) > 0 {
+			earlier = strings.Join(failures, "\n") + "\n"
+		}
+
+		if err == errTaskCanceled {
+			return false, fmt.Sprintf("%s%s canceled, %s", earlier, name, detail), err
+		}
+
+		if err == errTaskGone {
+			return false, "", err
+		}
+
+		if err == errStepTimeout {
+			limit := fmt.Sprintf("step not finished in %s", step.Timeout)
+			if step.Timeout == 0 || timeout < step.Timeout {
+				limit = fmt.Sprintf("task not finished in %s", taskCfg.Timeout)
+			}
+
+			detail = fmt.Sprintf("%stimeout: %s, %s, %s", earlier, limit, name, detail)
+			if len(detail) > types.MaxTaskDetailLen {
+				detail = detail[:types.MaxTaskDetailLen]
+			}
+			return false, detail, nil
+		}
+
 		if err != nil {
-			return false, fmt.Sprintf("step at index %d(%s) failed, %v, %s", i, step.Name, err, detail), nil
+			return false, fmt.Sprintf("%s%s failed, %v, %s", earlier, name, err, detail), nil
 		}
 
-		detail = fmt.Sprintf("step at index %d(%s) done:\n%s", i, step.Name, detail)
+		detail = fmt.Sprintf("%s%s done:\n%s", earlier, name, detail)
 		if len(detail) > types.MaxTaskDetailLen {
 			detail = detail[:types.MaxTaskDetailLen]
 		}
 		err = a.client.UpdateTask(task.ID, int8(i+1), detail)
 		if err != nil {
+			if a.checkReplaced(err) {
+				return false, "", err
+			}
+
 			return false, "", fmt.Errorf("update task at step index %d failed, %v", i, err)
 		}
 	}
@@ -109,29 +289,206 @@ func (a *Agent) process(task *types.Task) (bool, string, error) {
 	return true, "", nil
 }
 
-func (a *Agent) processStep(step *config.Step, workDir string, params []string) (string, error) {
-	var (
-		out []byte
-		err error
-	)
+func isTaskLeased(err error) bool {
+	return err != nil && err.Error() == handler.ErrTaskLeased.Error()
+}
+
+// taskWatch polls the status of a running task, done is closed when the task
+// should stop, err tells why.
+type taskWatch struct {
+	done   chan struct{}
+	err    error
+	stopCh chan struct{}
+}
+
+// watchTask renews the lease of the task until stop is called, it stops the task if
+// the task is asked to cancel, is no longer accepted on the server or its lease is lost.
+// The lease is lost too if it can't be renewed before it expires, the server may give
+// the task to another agent then.
+func (a *Agent) watchTask(task *types.Task) *taskWatch {
+	w := &taskWatch{
+		done:   make(chan struct{}),
+		stopCh: make(chan struct{}),
+	}
+
+	taskID, leaseExpiredAt := task.ID, task.LeaseExpiredAt
+	go func() {
+		ticker := time.NewTicker(a.cfg.CancelCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stopCh:
+				return
+			case <-ticker.C:
+			}
+
+			resp, err := a.client.RenewLease(taskID)
+			if isTaskLeased(err) {
+				log.Warnf("task (id: %d) lease is lost, %v", taskID, err)
+				w.err = errTaskGone
+				close(w.done)
+				return
+			}
+
+			// The lease can't be renewed by a replaced agent or with an invalid token.
+			if a.checkReplaced(err) || (err != nil && err.Error() == handler.ErrInvalidAgentToken.Error()) {
+				log.Warnf("task (id: %d) lease can't be renewed, %v", taskID, err)
+				w.err = errTaskGone
+				close(w.done)
+				return
+			}
+
+			if err != nil {
+				if leaseExpiredAt > 0 && time.Now().Unix() >= leaseExpiredAt {
+					log.Warnf("task (id: %d) lease expired at %s without renewal, %v",
+						taskID, time.Unix(leaseExpiredAt, 0), err)
+					w.err = errTaskGone
+					close(w.done)
+					return
+				}
+
+				log.Warnf("renew task (id: %d) lease failed, %v", taskID, err)
+				continue
+			}
+			leaseExpiredAt = resp.LeaseExpiredAt
+
+			if resp.CancelRequested {
+				log.Infof("task (id: %d) is asked to cancel", taskID)
+				w.err = errTaskCanceled
+				close(w.done)
+				return
+			}
+
+			if resp.Status != types.TaskAccepted {
+				log.Infof("task (id: %d) is no longer accepted, status: %d", taskID, resp.Status)
+				w.err = errTaskGone
+				close(w.done)
+				return
+			}
+		}
+	}()
+
+	return w
+}
+
+func (w *taskWatch) stop() {
+	close(w.stopCh)
+}
+
+// wait waits for d, it returns false if the task should stop before that.
+func (w *taskWatch) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-w.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retryable tells if a step which failed with err should run again by retry.
+func retryable(retry config.Retry, err error) bool {
+	if err == errTaskCanceled || err == errTaskGone {
+		return false
+	}
+
+	if len(retry.ExitCodes) == 0 {
+		return true
+	}
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return false
+	}
+
+	for _, code := range retry.ExitCodes {
+		if status.ExitStatus() == code {
+			return true
+		}
+	}
 
+	return false
+}
+
+func (a *Agent) processStep(step *config.Step, workDir string, params []string, watch *taskWatch, timeout time.Duration) (string, error) {
+	var c *exec.Cmd
 	if step.Params {
 		// For security, we don't use `bash -c`.
-		out, err = goutils.ExeCmd(step.Cmd, params,
-			func(c *exec.Cmd) {
-				c.Dir = workDir
-			})
+		c = exec.Command(step.Cmd, params...)
 	} else {
 		// It is safe to use `bash -c`.
-		out, err = goutils.ExeCmd("bash", []string{"-c", step.Cmd},
-			func(c *exec.Cmd) {
-				c.Dir = workDir
-			})
+		c = exec.Command("bash", "-c", step.Cmd)
 	}
+	c.Dir = workDir
 
+	out, err := runCmd(c, watch, timeout)
 	return string(out), err
 }
 
+// runCmd runs c in a new process group, the whole group is killed if the watch stops
+// the task or timeout (0 means no limit) expires before c exits, so that the children
+// of c stop too.
+func runCmd(c *exec.Cmd, watch *taskWatch, timeout time.Duration) ([]byte, error) {
+	select {
+	case <-watch.done:
+		return nil, watch.err
+	default:
+	}
+
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = &out
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err := c.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+		return out.Bytes(), err
+	case <-watch.done:
+		err = watch.err
+	case <-expired:
+		err = errStepTimeout
+	}
+
+	if err := syscall.Kill(-c.Process.Pid, syscall.SIGKILL); err != nil {
+		log.Errorf("kill process group %d failed, %v", c.Process.Pid, err)
+	}
+
+	<-done
+	return out.Bytes(), err
+}
+
 func (a *Agent) Destroy() {
-	//
+	if a.isReplaced() {
+		return
+	}
+
+	err := a.client.Logout()
+	if err != nil {
+		log.Errorf("logout failed, %v", err)
+	}
 }
@@ -16,6 +16,15 @@ func NewClient(url string) *Client {
 	return &Client{client.New(url)}
 }
 
+// Enroll exchanges an enrollment token for the worker's credentials.
+func (c *Client) Enroll(enrollToken string) (*types.AgentEnrollResponse, error) {
+	var resp types.AgentEnrollResponse
+	err := c.Request("/enroll", &types.AgentEnrollRequest{
+		EnrollToken: enrollToken,
+	}, &resp)
+	return &resp, err
+}
+
 func (c *Client) Login(workerID uint64, workerKey string) error {
 	var resp types.AgentLoginResponse
 	err := c.Request("/login", &types.AgentLoginRequest{
@@ -30,33 +39,65 @@ func (c *Client) Login(workerID uint64, workerKey string) error {
 	return nil
 }
 
+func (c *Client) Logout() error {
+	return c.Request("/logout", &types.AgentLogoutRequest{}, nil)
+}
+
 func (c *Client) ListTask() (*types.ListTaskResponse, error) {
 	var resp types.ListTaskResponse
-	err := c.Request("/list-task", &types.AgentListTaskRequest{
-		Token: c.Token(),
-	}, &resp)
+	err := c.Request("/list-task", &types.AgentListTaskRequest{}, &resp)
 	return &resp, err
 }
 
+// ClaimTask accepts the next task of the worker, the task is nil if there is none.
+func (c *Client) ClaimTask() (*types.Task, error) {
+	var resp types.ClaimTaskResponse
+	err := c.Request("/claim-task", &types.ClaimTaskRequest{}, &resp)
+	return resp.Task, err
+}
+
 func (c *Client) AcceptTask(taskID uint64) error {
 	return c.Request("/accept-task", &types.AcceptTaskRequest{
-		Token:  c.Token(),
 		TaskID: taskID,
 	}, nil)
 }
 
 func (c *Client) UpdateTask(taskID uint64, progress int8, detail string) error {
 	return c.Request("/update-task", &types.UpdateTaskRequest{
-		Token:    c.Token(),
 		TaskID:   taskID,
 		Progress: progress,
 		Detail:   detail,
 	}, nil)
 }
 
+func (c *Client) TaskStatus(taskID uint64) (*types.TaskStatusResponse, error) {
+	var resp types.TaskStatusResponse
+	err := c.Request("/task-status", &types.TaskStatusRequest{
+		TaskID: taskID,
+	}, &resp)
+	return &resp, err
+}
+
+// RenewLease extends the lease of the running task, it returns the status of the task too.
+func (c *Client) RenewLease(taskID uint64) (*types.TaskStatusResponse, error) {
+	var resp types.TaskStatusResponse
+	err := c.Request("/renew-lease", &types.TaskStatusRequest{
+		TaskID: taskID,
+	}, &resp)
+	return &resp, err
+}
+
+// ReportCanceled finishes the task which stopped for a cancel request.
+func (c *Client) ReportCanceled(taskID uint64, detail string) error {
+	return c.Request("/finish-task", &types.FinishTaskRequest{
+		TaskID:   taskID,
+		Detail:   detail,
+		Canceled: true,
+	}, nil)
+}
+
 func (c *Client) FinishTask(taskID uint64, success bool, detail string) error {
 	return c.Request("/finish-task", &types.FinishTaskRequest{
-		Token:   c.Token(),
 		TaskID:  taskID,
 		Success: success,
 		Detail:  detail,
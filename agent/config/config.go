@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/tidyoux/goutils/viper"
 )
@@ -10,12 +11,27 @@ type Step struct {
 	Name   string
 	Cmd    string
 	Params bool
+	// Timeout kills the step if it runs longer, 0 means no limit.
+	Timeout time.Duration
+	Retry   Retry
+}
+
+// Retry tells how a failed step is run again.
+type Retry struct {
+	// Attempts is how many times the step runs at most, 1 means no retry.
+	Attempts int
+	// Backoff is the wait before the second attempt, it doubles after each attempt.
+	Backoff time.Duration
+	// ExitCodes are the exit codes to retry on, any failure is retried if it is empty.
+	ExitCodes []int
 }
 
 type Task struct {
 	Name    string
 	WorkDir string
 	Steps   []*Step
+	// Timeout kills the running step if the task runs longer, 0 means no limit.
+	Timeout time.Duration
 }
 
 type Config struct {
@@ -24,6 +40,9 @@ type Config struct {
 	WorkerKey string
 	Tasks     map[string]*Task
 	TaskNames []string
+
+	// CancelCheckInterval is how often a running task is checked for cancel requests.
+	CancelCheckInterval time.Duration
 }
 
 func NewConfig() (*Config, error) {
@@ -32,6 +51,12 @@ func NewConfig() (*Config, error) {
 		WorkerID:  uint64(viper.GetInt64("workerID", 0)),
 		WorkerKey: viper.GetString("workerKey", ""),
 		Tasks:     make(map[string]*Task),
+
+		CancelCheckInterval: viper.GetDuration("cancelCheckInterval", time.Second*5),
+	}
+
+	if c.CancelCheckInterval <= 0 {
+		return nil, fmt.Errorf("invalid cancelCheckInterval %s, should > 0", c.CancelCheckInterval)
 	}
 
 	tasks, _ := viper.Get("tasks").([]interface{})
@@ -56,6 +81,11 @@ func NewConfig() (*Config, error) {
 
 		workDir, _ := task["workDir"].(string)
 
+		timeout, err := parseTimeout(task["timeout"])
+		if err != nil {
+			return nil, fmt.Errorf("parse task config at index %d failed, %v", i, err)
+		}
+
 		steps, _ := task["steps"].([]interface{})
 		if len(steps) == 0 {
 			return nil, fmt.Errorf("parse task config at index %d failed, steps can't be empty", i)
@@ -65,6 +95,7 @@ func NewConfig() (*Config, error) {
 			Name:    taskName,
 			WorkDir: workDir,
 			Steps:   make([]*Step, 0, len(steps)),
+			Timeout: timeout,
 		}
 
 		for j, step := range steps {
@@ -88,10 +119,22 @@ func NewConfig() (*Config, error) {
 				params = p
 			}
 
+			timeout, err := parseTimeout(step["timeout"])
+			if err != nil {
+				return nil, fmt.Errorf("parse task %s steps config at index %d failed, %v", taskName, j, err)
+			}
+
+			retry, err := parseRetry(step["retry"])
+			if err != nil {
+				return nil, fmt.Errorf("parse task %s steps config at index %d failed, %v", taskName, j, err)
+			}
+
 			t.Steps = append(t.Steps, &Step{
-				Name:   stepName,
-				Cmd:    cmd,
-				Params: params,
+				Name:    stepName,
+				Cmd:     cmd,
+				Params:  params,
+				Timeout: timeout,
+				Retry:   retry,
 			})
 		}
 
@@ -101,3 +144,67 @@ func NewConfig() (*Config, error) {
 
 	return c, nil
 }
+
+// parseRetry parses a retry like {attempts: 3, backoff: "10s", exitCodes: [1]},
+// the step isn't retried if v is nil.
+func parseRetry(v interface{}) (Retry, error) {
+	retry := Retry{Attempts: 1}
+	if v == nil {
+		return retry, nil
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return retry, fmt.Errorf("invalid retry, invalid format")
+	}
+
+	if attempts, ok := m["attempts"]; ok {
+		n, ok := attempts.(int)
+		if !ok || n < 1 {
+			return retry, fmt.Errorf("invalid retry attempts %v", attempts)
+		}
+		retry.Attempts = n
+	}
+
+	backoff, err := parseTimeout(m["backoff"])
+	if err != nil {
+		return retry, fmt.Errorf("invalid retry backoff %v", m["backoff"])
+	}
+	retry.Backoff = backoff
+
+	if codes, ok := m["exitCodes"]; ok {
+		codes, ok := codes.([]interface{})
+		if !ok {
+			return retry, fmt.Errorf("invalid retry exit codes, invalid format")
+		}
+
+		for _, code := range codes {
+			code, ok := code.(int)
+			if !ok {
+				return retry, fmt.Errorf("invalid retry exit code %v", code)
+			}
+			retry.ExitCodes = append(retry.ExitCodes, code)
+		}
+	}
+
+	return retry, nil
+}
+
+// parseTimeout parses a timeout like "10m", it is 0 if v is nil.
+func parseTimeout(v interface{}) (time.Duration, error) {
+	if v == nil {
+		return 0, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid timeout %v", v)
+	}
+
+	timeout, err := time.ParseDuration(s)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid timeout %s", s)
+	}
+
+	return timeout, nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WriteCredentials sets url, workerID and workerKey in the config file,
+// other lines of the file are kept as they are. The file is created if
+// it doesn't exist, tasks should be added to it before the agent runs.
+func WriteCredentials(file, url string, workerID uint64, workerKey string) error {
+	var lines []string
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("read config %s failed, %v", file, err)
+	}
+
+	values := []struct {
+		key   string
+		value string
+	}{
+		{"url", strconv.Quote(url)},
+		{"workerID", strconv.FormatUint(workerID, 10)},
+		{"workerKey", strconv.Quote(workerKey)},
+	}
+
+	for _, v := range values {
+		line := v.key + ": " + v.value
+
+		found := false
+		for i, l := range lines {
+			if strings.HasPrefix(l, v.key+":") {
+				lines[i] = line
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			lines = append(lines, line)
+		}
+	}
+
+	err = ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		return fmt.Errorf("write config %s failed, %v", file, err)
+	}
+
+	return nil
+}
@@ -1,20 +1,28 @@
 package client
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/tidyoux/router/common/client"
 	"github.com/tidyoux/router/common/crypto"
 	"github.com/tidyoux/router/common/types"
 )
 
+// ErrTwoFactorRequired is returned by Login if the user has enabled 2FA,
+// the login completes by Login2FA with the code.
+var ErrTwoFactorRequired = fmt.Errorf("2fa code required")
+
 type Client struct {
 	*client.Client
+
+	twoFactorToken string
 }
 
 func New(url string) *Client {
 	url = strings.TrimRight(url, "/") + "/v1/user"
-	return &Client{client.New(url)}
+	return &Client{Client: client.New(url)}
 }
 
 func (c *Client) Login(username, password string) (uint64, error) {
@@ -29,14 +37,96 @@ func (c *Client) Login(username, password string) (uint64, error) {
 		return 0, err
 	}
 
+	if len(resp.TwoFactorToken) > 0 {
+		c.twoFactorToken = resp.TwoFactorToken
+		return resp.UserID, ErrTwoFactorRequired
+	}
+
 	c.SetToken(resp.Token)
 	return resp.UserID, nil
 }
 
-func (c *Client) Logout() error {
-	err := c.Request("/logout", &types.UserLogoutRequest{
-		Token: c.Token(),
+// Login2FA completes the login which returned ErrTwoFactorRequired,
+// code is a TOTP code or a recovery code.
+func (c *Client) Login2FA(code string) (uint64, error) {
+	var resp types.UserLoginResponse
+	err := c.Request("/login-2fa", &types.UserLogin2FARequest{
+		TwoFactorToken: c.twoFactorToken,
+		Code:           code,
+	}, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	c.twoFactorToken = ""
+	c.SetToken(resp.Token)
+	return resp.UserID, nil
+}
+
+func (c *Client) ListAuthProvider() ([]string, error) {
+	var resp types.ListAuthProviderResponse
+	err := c.Request("/list-auth-provider", &types.ListAuthProviderRequest{}, &resp)
+	return resp, err
+}
+
+// ExternalLogin returns the url of provider to login at in a browser,
+// which is sent back to the server's redirect url with state and code.
+func (c *Client) ExternalLogin(provider string) (string, error) {
+	var resp types.ExternalLoginResponse
+	err := c.Request("/external-login", &types.ExternalLoginRequest{
+		Provider: provider,
+	}, &resp)
+	return resp.URL, err
+}
+
+// ExternalLoginCallback completes the login of ExternalLogin.
+func (c *Client) ExternalLoginCallback(state, code string) (uint64, error) {
+	var resp types.UserLoginResponse
+	err := c.Request("/external-login-callback", &types.ExternalLoginCallbackRequest{
+		State: state,
+		Code:  code,
+	}, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	c.SetToken(resp.Token)
+	return resp.UserID, nil
+}
+
+func (c *Client) SetupTOTP() (*types.SetupTOTPResponse, error) {
+	var resp types.SetupTOTPResponse
+	err := c.Request("/setup-totp", &types.SetupTOTPRequest{}, &resp)
+	return &resp, err
+}
+
+// EnableTOTP enables the 2FA set up by SetupTOTP, it returns the recovery codes.
+func (c *Client) EnableTOTP(code string) ([]string, error) {
+	var resp types.RecoveryCodesResponse
+	err := c.Request("/enable-totp", &types.EnableTOTPRequest{
+		Code: code,
+	}, &resp)
+	return resp.RecoveryCodes, err
+}
+
+func (c *Client) DisableTOTP(password string) error {
+	password = crypto.Sum([]byte(password)).String()
+
+	return c.Request("/disable-totp", &types.DisableTOTPRequest{
+		Password: password,
 	}, nil)
+}
+
+func (c *Client) RegenerateRecoveryCodes(code string) ([]string, error) {
+	var resp types.RecoveryCodesResponse
+	err := c.Request("/regenerate-recovery-codes", &types.RegenerateRecoveryCodesRequest{
+		Code: code,
+	}, &resp)
+	return resp.RecoveryCodes, err
+}
+
+func (c *Client) Logout() error {
+	err := c.Request("/logout", &types.UserLogoutRequest{}, nil)
 	if err != nil {
 		return err
 	}
@@ -46,16 +136,19 @@ func (c *Client) Logout() error {
 }
 
 func (c *Client) Ping() error {
-	return c.Request("/ping", &types.UserPingRequest{
-		Token: c.Token(),
-	}, nil)
+	return c.Request("/ping", &types.UserPingRequest{}, nil)
 }
 
-func (c *Client) UpdatePassword(password string) error {
-	password = crypto.Sum([]byte(password)).String()
+func (c *Client) Info() (*types.User, error) {
+	var resp types.User
+	err := c.Request("/info", &types.UserPingRequest{}, &resp)
+	return &resp, err
+}
 
+// UpdatePassword changes the password, which is sent in plain text to be checked
+// against the password rules. The client is logged out then.
+func (c *Client) UpdatePassword(password string) error {
 	err := c.Request("/update-password", &types.UserUpdatePasswordRequest{
-		Token:    c.Token(),
 		Password: password,
 	}, nil)
 	if err != nil {
@@ -66,78 +159,199 @@ func (c *Client) UpdatePassword(password string) error {
 	return nil
 }
 
+func (c *Client) ListSession() ([]*types.Session, error) {
+	var resp []*types.Session
+	err := c.Request("/list-session", &types.ListSessionRequest{}, &resp)
+	return resp, err
+}
+
+func (c *Client) RevokeSession(sessionID uint64) error {
+	return c.Request("/revoke-session", &types.RevokeSessionRequest{
+		SessionID: sessionID,
+	}, nil)
+}
+
+func (c *Client) CreateAccessToken(name string, workerIDs []uint64, expiredAt int64) (*types.CreateAccessTokenResponse, error) {
+	var resp types.CreateAccessTokenResponse
+	err := c.Request("/create-access-token", &types.CreateAccessTokenRequest{
+		Name:      name,
+		WorkerIDs: workerIDs,
+		ExpiredAt: expiredAt,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+func (c *Client) ListAccessToken() ([]*types.AccessToken, error) {
+	var resp []*types.AccessToken
+	err := c.Request("/list-access-token", &types.ListAccessTokenRequest{}, &resp)
+	return resp, err
+}
+
+func (c *Client) RevokeAccessToken(accessTokenID uint64) error {
+	return c.Request("/revoke-access-token", &types.RevokeAccessTokenRequest{
+		AccessTokenID: accessTokenID,
+	}, nil)
+}
+
+func (c *Client) ListLoginLock() ([]*types.LoginLock, error) {
+	var resp []*types.LoginLock
+	err := c.Request("/list-login-lock", &types.ListLoginLockRequest{}, &resp)
+	return resp, err
+}
+
+func (c *Client) ClearLoginLock(kind int8, target string) error {
+	return c.Request("/clear-login-lock", &types.ClearLoginLockRequest{
+		Kind:   kind,
+		Target: target,
+	}, nil)
+}
+
 func (c *Client) ListUser() ([]*types.User, error) {
 	var resp []*types.User
-	err := c.Request("/list-user", &types.ListUserRequest{
-		Token: c.Token(),
-	}, &resp)
+	err := c.Request("/list-user", &types.ListUserRequest{}, &resp)
 	return resp, err
 }
 
+// AddUser adds a user, password is sent in plain text to be checked against the password rules.
+func (c *Client) AddUser(name, password, role, detail string) (uint64, error) {
+	var resp types.AddUserResponse
+	err := c.Request("/add-user", &types.AddUserRequest{
+		Name:     name,
+		Password: password,
+		Role:     role,
+		Detail:   detail,
+	}, &resp)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.ID, nil
+}
+
+func (c *Client) RenameUser(userID uint64, name string) error {
+	return c.Request("/rename-user", &types.UserUpdateUserRequest{
+		UserID: userID,
+		Name:   name,
+	}, nil)
+}
+
+func (c *Client) DeleteUser(userID uint64) error {
+	return c.Request("/delete-user", &types.UserUpdateUserRequest{
+		UserID: userID,
+	}, nil)
+}
+
 func (c *Client) UpdateUserDetail(userID uint64, detail string) error {
 	return c.Request("/update-user-detail", &types.UserUpdateUserRequest{
-		Token:  c.Token(),
 		UserID: userID,
 		Detail: detail,
 	}, nil)
 }
 
-func (c *Client) ResetUserPassword(userID uint64) error {
-	return c.Request("/reset-user-password", &types.UserUpdateUserRequest{
-		Token:  c.Token(),
+// ResetUserPassword sets a random password for the user and returns it,
+// the user must change it after login.
+func (c *Client) ResetUserPassword(userID uint64) (string, error) {
+	var resp types.ResetUserPasswordResponse
+	err := c.Request("/reset-user-password", &types.UserUpdateUserRequest{
+		UserID: userID,
+	}, &resp)
+	return resp.Password, err
+}
+
+// ResetUserTOTP turns off 2FA of the user and logs the user out.
+func (c *Client) ResetUserTOTP(userID uint64) error {
+	return c.Request("/reset-user-totp", &types.UserUpdateUserRequest{
+		UserID: userID,
+	}, nil)
+}
+
+func (c *Client) ListUserSession(userID uint64) ([]*types.Session, error) {
+	var resp []*types.Session
+	err := c.Request("/list-user-session", &types.ListSessionRequest{
+		UserID: userID,
+	}, &resp)
+	return resp, err
+}
+
+func (c *Client) RevokeUserSession(userID, sessionID uint64) error {
+	return c.Request("/revoke-user-session", &types.RevokeSessionRequest{
+		UserID:    userID,
+		SessionID: sessionID,
+	}, nil)
+}
+
+func (c *Client) UpdateUserRole(userID uint64, role string) error {
+	return c.Request("/update-user-role", &types.UserUpdateUserRequest{
 		UserID: userID,
+		Role:   role,
 	}, nil)
 }
 
 func (c *Client) EnableUser(userID uint64) error {
 	return c.Request("/enable-user", &types.UserUpdateUserRequest{
-		Token:  c.Token(),
 		UserID: userID,
 	}, nil)
 }
 
 func (c *Client) DisableUser(userID uint64) error {
 	return c.Request("/disable-user", &types.UserUpdateUserRequest{
-		Token:  c.Token(),
 		UserID: userID,
 	}, nil)
 }
 
 func (c *Client) ListWorker() ([]*types.Worker, error) {
 	var resp []*types.Worker
-	err := c.Request("/list-worker", &types.ListWorkerRequest{
-		Token: c.Token(),
-	}, &resp)
+	err := c.Request("/list-worker", &types.ListWorkerRequest{}, &resp)
 	return resp, err
 }
 
 func (c *Client) AddWorker(name, desc string) (*types.AddWorkerResponse, error) {
 	var resp types.AddWorkerResponse
 	err := c.Request("/add-worker", &types.AddWorkerRequest{
-		Token: c.Token(),
-		Name:  name,
-		Desc:  desc,
+		Name: name,
+		Desc: desc,
+	}, &resp)
+	return &resp, err
+}
+
+// CreateEnrollToken creates a single use enrollment token for the worker, which expires after ttl.
+func (c *Client) CreateEnrollToken(workerID uint64, ttl time.Duration) (*types.CreateEnrollTokenResponse, error) {
+	var resp types.CreateEnrollTokenResponse
+	err := c.Request("/create-enroll-token", &types.CreateEnrollTokenRequest{
+		WorkerID: workerID,
+		TTL:      int64(ttl / time.Second),
+	}, &resp)
+	return &resp, err
+}
+
+// RotateWorkerKey issues a new key for the worker, the old one keeps working for grace.
+func (c *Client) RotateWorkerKey(workerID uint64, grace time.Duration) (*types.RotateWorkerKeyResponse, error) {
+	var resp types.RotateWorkerKeyResponse
+	err := c.Request("/rotate-worker-key", &types.RotateWorkerKeyRequest{
+		WorkerID: workerID,
+		Grace:    int64(grace / time.Second),
 	}, &resp)
 	return &resp, err
 }
 
 func (c *Client) EnableWorker(workerID uint64) error {
 	return c.Request("/enable-worker", &types.UpdateWorkerRequest{
-		Token:    c.Token(),
 		WorkerID: workerID,
 	}, nil)
 }
 
 func (c *Client) DisableWorker(workerID uint64) error {
 	return c.Request("/disable-worker", &types.UpdateWorkerRequest{
-		Token:    c.Token(),
 		WorkerID: workerID,
 	}, nil)
 }
 
 func (c *Client) UpdateWorkerName(workerID uint64, name string) error {
 	return c.Request("/update-worker-name", &types.UpdateWorkerRequest{
-		Token:    c.Token(),
 		WorkerID: workerID,
 		Name:     name,
 	}, nil)
@@ -145,23 +359,35 @@ func (c *Client) UpdateWorkerName(workerID uint64, name string) error {
 
 func (c *Client) UpdateWorkerDesc(workerID uint64, desc string) error {
 	return c.Request("/update-worker-desc", &types.UpdateWorkerRequest{
-		Token:    c.Token(),
 		WorkerID: workerID,
 		Desc:     desc,
 	}, nil)
 }
 
-func (c *Client) AddWorkerUser(workerID, userID uint64) error {
+func (c *Client) UpdateWorkerMaxRunTime(workerID uint64, seconds int64) error {
+	return c.Request("/update-worker-max-run-time", &types.UpdateWorkerRequest{
+		WorkerID:   workerID,
+		MaxRunTime: seconds,
+	}, nil)
+}
+
+func (c *Client) UpdateWorkerLeaseExpiredPolicy(workerID uint64, policy int8) error {
+	return c.Request("/update-worker-lease-expired-policy", &types.UpdateWorkerRequest{
+		WorkerID:           workerID,
+		LeaseExpiredPolicy: policy,
+	}, nil)
+}
+
+func (c *Client) AddWorkerUser(workerID, userID uint64, level int8) error {
 	return c.Request("/add-worker-user", &types.UpdateWorkerRequest{
-		Token:    c.Token(),
 		WorkerID: workerID,
 		UserID:   userID,
+		Level:    level,
 	}, nil)
 }
 
 func (c *Client) RemoveWorkerUser(workerID, userID uint64) error {
 	return c.Request("/remove-worker-user", &types.UpdateWorkerRequest{
-		Token:    c.Token(),
 		WorkerID: workerID,
 		UserID:   userID,
 	}, nil)
@@ -169,7 +395,6 @@ func (c *Client) RemoveWorkerUser(workerID, userID uint64) error {
 
 func (c *Client) RemoveWorker(workerID uint64) error {
 	return c.Request("/remove-worker", &types.UpdateWorkerRequest{
-		Token:    c.Token(),
 		WorkerID: workerID,
 	}, nil)
 }
@@ -177,7 +402,6 @@ func (c *Client) RemoveWorker(workerID uint64) error {
 func (c *Client) ListTask(workerID uint64, offset, limit int64) (*types.ListTaskResponse, error) {
 	var resp types.ListTaskResponse
 	err := c.Request("/list-task", &types.UserListTaskRequest{
-		Token:    c.Token(),
 		WorkerID: workerID,
 		Offset:   offset,
 		Limit:    limit,
@@ -186,19 +410,111 @@ func (c *Client) ListTask(workerID uint64, offset, limit int64) (*types.ListTask
 }
 
 func (c *Client) SendTask(workerID uint64, params string) (*types.SendTaskResponse, error) {
-	var resp types.SendTaskResponse
-	err := c.Request("/send-task", &types.SendTaskRequest{
-		Token:    c.Token(),
+	return c.SendTaskWithOptions(&types.SendTaskRequest{
 		WorkerID: workerID,
 		Params:   params,
+	})
+}
+
+// SendTaskWithOptions sends a task with the options of req, such as retries.
+func (c *Client) SendTaskWithOptions(req *types.SendTaskRequest) (*types.SendTaskResponse, error) {
+	var resp types.SendTaskResponse
+	err := c.Request("/send-task", req, &resp)
+	return &resp, err
+}
+
+func (c *Client) ListSchedule(workerID uint64) ([]*types.Schedule, error) {
+	var resp []*types.Schedule
+	err := c.Request("/list-schedule", &types.ListScheduleRequest{
+		WorkerID: workerID,
+	}, &resp)
+	return resp, err
+}
+
+// AddSchedule adds a schedule which sends tasks to req.WorkerID by req.Cron.
+func (c *Client) AddSchedule(req *types.ScheduleRequest) (uint64, error) {
+	var resp types.AddScheduleResponse
+	err := c.Request("/add-schedule", req, &resp)
+	return resp.ScheduleID, err
+}
+
+// UpdateSchedule replaces the schedule req.ScheduleID with req, the caller becomes its owner.
+func (c *Client) UpdateSchedule(req *types.ScheduleRequest) error {
+	return c.Request("/update-schedule", req, nil)
+}
+
+func (c *Client) RemoveSchedule(scheduleID uint64) error {
+	return c.Request("/remove-schedule", &types.ScheduleRequest{
+		ScheduleID: scheduleID,
+	}, nil)
+}
+
+func (c *Client) ListPipeline() ([]*types.Pipeline, error) {
+	var resp []*types.Pipeline
+	err := c.Request("/list-pipeline", &types.ListPipelineRequest{}, &resp)
+	return resp, err
+}
+
+// AddPipeline adds a pipeline which sends the tasks of req.Nodes after the nodes they need succeed.
+func (c *Client) AddPipeline(req *types.PipelineRequest) (uint64, error) {
+	var resp types.AddPipelineResponse
+	err := c.Request("/add-pipeline", req, &resp)
+	return resp.PipelineID, err
+}
+
+// UpdatePipeline replaces the pipeline req.PipelineID with req, the running runs are not changed.
+func (c *Client) UpdatePipeline(req *types.PipelineRequest) error {
+	return c.Request("/update-pipeline", req, nil)
+}
+
+func (c *Client) RemovePipeline(pipelineID uint64) error {
+	return c.Request("/remove-pipeline", &types.PipelineRequest{
+		PipelineID: pipelineID,
+	}, nil)
+}
+
+// RunPipeline starts a run of the pipeline, its tasks are sent as the caller.
+func (c *Client) RunPipeline(pipelineID uint64) (uint64, error) {
+	var resp types.RunPipelineResponse
+	err := c.Request("/run-pipeline", &types.RunPipelineRequest{
+		PipelineID: pipelineID,
+	}, &resp)
+	return resp.PipelineRunID, err
+}
+
+func (c *Client) ListPipelineRun(pipelineID uint64, offset, limit int64) (*types.ListPipelineRunResponse, error) {
+	var resp types.ListPipelineRunResponse
+	err := c.Request("/list-pipeline-run", &types.ListPipelineRunRequest{
+		PipelineID: pipelineID,
+		Offset:     offset,
+		Limit:      limit,
 	}, &resp)
 	return &resp, err
 }
 
+// CancelPipelineRun skips the pending nodes of the run and cancels its running tasks.
+func (c *Client) CancelPipelineRun(runID uint64) error {
+	return c.Request("/cancel-pipeline-run", &types.CancelPipelineRunRequest{
+		PipelineRunID: runID,
+	}, nil)
+}
+
+// CancelTask cancels a task which has not been accepted, or asks the agent to stop it.
+func (c *Client) CancelTask(taskID uint64) error {
+	return c.Request("/cancel-task", &types.CancelTaskRequest{
+		TaskID: taskID,
+	}, nil)
+}
+
+func (c *Client) ListAudit(filter *types.ListAuditRequest) (*types.ListAuditResponse, error) {
+	var resp types.ListAuditResponse
+	err := c.Request("/list-audit", filter, &resp)
+	return &resp, err
+}
+
 func (c *Client) TaskStatus(taskID uint64) (*types.TaskStatusResponse, error) {
 	var resp types.TaskStatusResponse
 	err := c.Request("/task-status", &types.TaskStatusRequest{
-		Token:  c.Token(),
 		TaskID: taskID,
 	}, &resp)
 	return &resp, err
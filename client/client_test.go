@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/tidyoux/router/client"
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/common/types"
 )
 
 var (
@@ -41,10 +44,138 @@ func TestPing(t *testing.T) {
 	assert(t, err)
 }
 
+func TestInfo(t *testing.T) {
+	login(t)
+
+	user, err := c.Info()
+	assert(t, err)
+
+	pJSON(user)
+}
+
+func TestListAuthProvider(t *testing.T) {
+	providers, err := c.ListAuthProvider()
+	assert(t, err)
+
+	pJSON(providers)
+}
+
+func TestExternalLogin(t *testing.T) {
+	providers, err := c.ListAuthProvider()
+	assert(t, err)
+
+	if len(providers) == 0 {
+		t.Skip("no auth provider")
+	}
+
+	url, err := c.ExternalLogin(providers[0])
+	assert(t, err)
+
+	p(url)
+}
+
+func TestTOTP(t *testing.T) {
+	login(t)
+
+	setup, err := c.SetupTOTP()
+	assert(t, err)
+
+	pJSON(setup)
+
+	code, err := crypto.TOTPCode(setup.Secret, crypto.TOTPStep(time.Now()))
+	assert(t, err)
+
+	recoveryCodes, err := c.EnableTOTP(code)
+	assert(t, err)
+
+	pJSON(recoveryCodes)
+
+	_, err = c.Login(username, password)
+	if err != client.ErrTwoFactorRequired {
+		t.Fatalf("login should require 2fa, got %v", err)
+	}
+
+	_, err = c.Login2FA(recoveryCodes[0])
+	assert(t, err)
+
+	err = c.DisableTOTP(password)
+	assert(t, err)
+}
+
 func TestUpdatePassword(t *testing.T) {
 	login(t)
 
-	err := c.UpdatePassword("123456")
+	newPassword := "Router@2019"
+	err := c.UpdatePassword(newPassword)
+	assert(t, err)
+
+	// The later tests login with the new password.
+	password = newPassword
+}
+
+func TestListSession(t *testing.T) {
+	login(t)
+
+	sessions, err := c.ListSession()
+	assert(t, err)
+
+	p("total:", len(sessions))
+	for _, s := range sessions {
+		pJSON(s)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	login(t)
+
+	err := c.RevokeSession(1)
+	assert(t, err)
+}
+
+func TestCreateAccessToken(t *testing.T) {
+	login(t)
+
+	resp, err := c.CreateAccessToken("test", nil, 0)
+	assert(t, err)
+
+	pJSON(resp)
+}
+
+func TestListAccessToken(t *testing.T) {
+	login(t)
+
+	tokens, err := c.ListAccessToken()
+	assert(t, err)
+
+	p("total:", len(tokens))
+	for _, t := range tokens {
+		pJSON(t)
+	}
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	login(t)
+
+	err := c.RevokeAccessToken(1)
+	assert(t, err)
+}
+
+func TestListLoginLock(t *testing.T) {
+	login(t)
+
+	locks, err := c.ListLoginLock()
+	assert(t, err)
+
+	p("total:", len(locks))
+	for _, l := range locks {
+		pJSON(l)
+	}
+}
+
+func TestClearLoginLock(t *testing.T) {
+	login(t)
+
+	err := c.ClearLoginLock(types.LoginLockUser, "tester")
 	assert(t, err)
 }
 
@@ -60,6 +191,37 @@ func TestListUser(t *testing.T) {
 	}
 }
 
+func TestAddUser(t *testing.T) {
+	login(t)
+
+	userID, err := c.AddUser("tester", "Tester@2019", "viewer", "a test user.")
+	assert(t, err)
+
+	p("user id:", userID)
+}
+
+// addTestUser adds a viewer for the tests which change a user other than the caller.
+func addTestUser(t *testing.T) uint64 {
+	userID, err := c.AddUser(fmt.Sprintf("tester%d", time.Now().UnixNano()), "Tester@2019", "viewer", "a test user.")
+	assert(t, err)
+
+	return userID
+}
+
+func TestRenameUser(t *testing.T) {
+	login(t)
+
+	err := c.RenameUser(2, "tester2")
+	assert(t, err)
+}
+
+func TestDeleteUser(t *testing.T) {
+	login(t)
+
+	err := c.DeleteUser(2)
+	assert(t, err)
+}
+
 func TestUpdateUserDetail(t *testing.T) {
 	login(t)
 
@@ -70,8 +232,61 @@ func TestUpdateUserDetail(t *testing.T) {
 func TestResetUserPassword(t *testing.T) {
 	login(t)
 
-	err := c.ResetUserPassword(1)
+	// Resetting the caller's own password would log it out.
+	password, err := c.ResetUserPassword(addTestUser(t))
+	assert(t, err)
+
+	p("one-time password:", password)
+}
+
+func TestResetUserTOTP(t *testing.T) {
+	login(t)
+
+	err := c.ResetUserTOTP(addTestUser(t))
+	assert(t, err)
+}
+
+func TestListUserSession(t *testing.T) {
+	login(t)
+
+	sessions, err := c.ListUserSession(1)
+	assert(t, err)
+
+	p("total:", len(sessions))
+	for _, s := range sessions {
+		pJSON(s)
+	}
+}
+
+func TestRevokeUserSession(t *testing.T) {
+	login(t)
+
+	err := c.RevokeUserSession(1, 1)
+	assert(t, err)
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	login(t)
+
+	userID := addTestUser(t)
+
+	err := c.UpdateUserRole(userID, "operator")
+	assert(t, err)
+
+	users, err := c.ListUser()
 	assert(t, err)
+
+	for _, u := range users {
+		if u.ID == userID {
+			if u.Role != "operator" {
+				t.Fatalf("user role %s, want operator", u.Role)
+			}
+
+			return
+		}
+	}
+
+	t.Fatalf("user %d not found", userID)
 }
 
 func TestEnableUser(t *testing.T) {
@@ -109,6 +324,24 @@ func TestAddWorker(t *testing.T) {
 	pJSON(resp)
 }
 
+func TestCreateEnrollToken(t *testing.T) {
+	login(t)
+
+	resp, err := c.CreateEnrollToken(1, time.Hour)
+	assert(t, err)
+
+	pJSON(resp)
+}
+
+func TestRotateWorkerKey(t *testing.T) {
+	login(t)
+
+	resp, err := c.RotateWorkerKey(1, time.Hour)
+	assert(t, err)
+
+	pJSON(resp)
+}
+
 func TestEnableWorker(t *testing.T) {
 	login(t)
 
@@ -133,10 +366,24 @@ func TestUpdateWorker(t *testing.T) {
 	assert(t, err)
 }
 
+func TestUpdateWorkerMaxRunTime(t *testing.T) {
+	login(t)
+
+	err := c.UpdateWorkerMaxRunTime(2, 3600)
+	assert(t, err)
+}
+
+func TestUpdateWorkerLeaseExpiredPolicy(t *testing.T) {
+	login(t)
+
+	err := c.UpdateWorkerLeaseExpiredPolicy(2, types.LeaseExpiredFail)
+	assert(t, err)
+}
+
 func TestAddWorkerUser(t *testing.T) {
 	login(t)
 
-	err := c.AddWorkerUser(1, 1)
+	err := c.AddWorkerUser(1, 1, 2)
 	assert(t, err)
 }
 
@@ -175,6 +422,178 @@ func TestSendTask(t *testing.T) {
 	pJSON(resp)
 }
 
+func TestSendTaskWithRetries(t *testing.T) {
+	login(t)
+
+	resp, err := c.SendTaskWithOptions(&types.SendTaskRequest{
+		WorkerID: 3,
+		Params:   "test",
+		Retries:  2,
+	})
+	assert(t, err)
+
+	status, err := c.TaskStatus(resp.TaskID)
+	assert(t, err)
+
+	if status.Attempt != 1 || status.MaxAttempts != 3 {
+		t.Fatalf("attempt %d/%d, want 1/3", status.Attempt, status.MaxAttempts)
+	}
+}
+
+func TestSendTaskWithPriority(t *testing.T) {
+	login(t)
+
+	resp, err := c.SendTaskWithOptions(&types.SendTaskRequest{
+		WorkerID: 3,
+		Params:   "test",
+		Priority: types.MaxTaskPriority,
+	})
+	assert(t, err)
+
+	status, err := c.TaskStatus(resp.TaskID)
+	assert(t, err)
+
+	pJSON(status)
+}
+
+func TestSendTaskWithRunAt(t *testing.T) {
+	login(t)
+
+	runAt := time.Now().Add(time.Hour).Unix()
+	resp, err := c.SendTaskWithOptions(&types.SendTaskRequest{
+		WorkerID: 3,
+		Params:   "test",
+		RunAt:    runAt,
+	})
+	assert(t, err)
+
+	status, err := c.TaskStatus(resp.TaskID)
+	assert(t, err)
+
+	if status.RunAt != runAt || status.QueuePosition != 0 {
+		t.Fatalf("run at %d, queued #%d, want %d and not queued", status.RunAt, status.QueuePosition, runAt)
+	}
+
+	err = c.CancelTask(resp.TaskID)
+	assert(t, err)
+}
+
+func TestListSchedule(t *testing.T) {
+	login(t)
+
+	schedules, err := c.ListSchedule(3)
+	assert(t, err)
+
+	for _, s := range schedules {
+		pJSON(s)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	login(t)
+
+	req := &types.ScheduleRequest{
+		WorkerID: 3,
+		Name:     "nightly backup",
+		Params:   "backup",
+		Cron:     "30 2 * * *",
+		TimeZone: "Asia/Shanghai",
+		Enabled:  true,
+	}
+	scheduleID, err := c.AddSchedule(req)
+	assert(t, err)
+
+	req.ScheduleID = scheduleID
+	req.Cron = "@hourly"
+	err = c.UpdateSchedule(req)
+	assert(t, err)
+
+	schedules, err := c.ListSchedule(3)
+	assert(t, err)
+
+	for _, s := range schedules {
+		if s.ID == scheduleID && (s.Cron != "@hourly" || s.NextRunAt == 0) {
+			t.Fatalf("schedule %d not updated: %s, next run at %d", s.ID, s.Cron, s.NextRunAt)
+		}
+	}
+
+	err = c.RemoveSchedule(scheduleID)
+	assert(t, err)
+}
+
+func TestPipeline(t *testing.T) {
+	login(t)
+
+	req := &types.PipelineRequest{
+		Name: "release",
+		Nodes: []*types.PipelineNode{
+			{Name: "build", WorkerID: 3, Params: "build"},
+			{Name: "deploy", WorkerID: 3, Params: "deploy", Needs: []string{"build"}},
+		},
+		FailurePolicy: types.PipelineFailCancel,
+	}
+	pipelineID, err := c.AddPipeline(req)
+	assert(t, err)
+
+	req.PipelineID = pipelineID
+	req.Nodes = append(req.Nodes, &types.PipelineNode{Name: "smoke", WorkerID: 3, Params: "smoke", Needs: []string{"deploy"}})
+	err = c.UpdatePipeline(req)
+	assert(t, err)
+
+	runID, err := c.RunPipeline(pipelineID)
+	assert(t, err)
+
+	resp, err := c.ListPipelineRun(pipelineID, 0, 10)
+	assert(t, err)
+
+	for _, run := range resp.Runs {
+		if run.ID == runID && (len(run.Nodes) != 3 || run.Nodes[0].TaskID == 0 || run.Nodes[1].TaskID > 0) {
+			t.Fatalf("pipeline run %d not started with its first node", run.ID)
+		}
+	}
+
+	err = c.CancelPipelineRun(runID)
+	assert(t, err)
+
+	resp, err = c.ListPipelineRun(pipelineID, 0, 10)
+	assert(t, err)
+
+	pJSON(resp)
+
+	err = c.RemovePipeline(pipelineID)
+	assert(t, err)
+}
+
+func TestCancelTask(t *testing.T) {
+	login(t)
+
+	resp, err := c.SendTask(3, "test")
+	assert(t, err)
+
+	err = c.CancelTask(resp.TaskID)
+	assert(t, err)
+
+	status, err := c.TaskStatus(resp.TaskID)
+	assert(t, err)
+
+	pJSON(status)
+}
+
+func TestListAudit(t *testing.T) {
+	login(t)
+
+	resp, err := c.ListAudit(&types.ListAuditRequest{
+		Action: "send-task",
+		Limit:  10,
+	})
+	assert(t, err)
+
+	p("total:", resp.Total)
+	for _, e := range resp.Events {
+		pJSON(e)
+	}
+}
+
 func TestTaskStatus(t *testing.T) {
 	login(t)
 
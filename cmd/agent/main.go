@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"math/rand"
 	"os"
 	"time"
@@ -16,6 +17,9 @@ import (
 
 var (
 	cfgFile string
+
+	serverURL   string
+	enrollToken string
 )
 
 func init() {
@@ -35,11 +39,39 @@ func main() {
 		run)
 	c.Flags().StringVarP(&cfgFile, "config", "c", "app.yml", "config file (default is app.yml)")
 
+	e := cmd.New(
+		"enroll",
+		"exchange an enrollment token for the worker credentials, and write them into the config file",
+		"routeragt enroll --server http://localhost:8080 --token enr_xxx",
+		enroll)
+	e.Flags().StringVar(&serverURL, "server", "", "router server url")
+	e.Flags().StringVar(&enrollToken, "token", "", "enrollment token")
+	c.CobraCmd().AddCommand(e.CobraCmd())
+
 	if err := c.Execute(); err != nil {
 		os.Exit(1)
 	}
 }
 
+func enroll(*cmd.Command) error {
+	if len(serverURL) == 0 || len(enrollToken) == 0 {
+		return fmt.Errorf("server and token can't be empty")
+	}
+
+	resp, err := agent.NewClient(serverURL).Enroll(enrollToken)
+	if err != nil {
+		return fmt.Errorf("enroll failed, %v", err)
+	}
+
+	err = config.WriteCredentials(cfgFile, serverURL, resp.WorkerID, resp.WorkerKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("enrolled as worker %d, credentials written to %s\n", resp.WorkerID, cfgFile)
+	return nil
+}
+
 func run(*cmd.Command) error {
 	serviceName := "routeragt"
 
@@ -62,7 +94,13 @@ func run(*cmd.Command) error {
 		panic(err)
 	}
 
-	svr := service.NewWithInterval(agent.New(cfg), time.Second)
+	agt := agent.New(cfg)
+	svr := service.NewWithInterval(agt, time.Second)
+	go func() {
+		<-agt.Replaced()
+		svr.Stop()
+	}()
+
 	if err := svr.Start(); err != nil {
 		panic(err)
 	}
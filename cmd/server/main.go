@@ -10,6 +10,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/tidyoux/goutils"
 	"github.com/tidyoux/goutils/cmd"
+	"github.com/tidyoux/goutils/service"
 	"github.com/tidyoux/router/common"
 	"github.com/tidyoux/router/common/db"
 	"github.com/tidyoux/router/server"
@@ -62,7 +63,10 @@ func run(*cmd.Command) error {
 		panic(err)
 	}
 
-	cfg := config.NewConfig()
+	cfg, err := config.NewConfig()
+	if err != nil {
+		panic(err)
+	}
 
 	// Initial db.
 	dbInst, err := db.New(cfg.DSN)
@@ -81,6 +85,18 @@ func run(*cmd.Command) error {
 		panic(fmt.Errorf("init server failed, %v", err))
 	}
 
+	sweeper := service.NewWithInterval(server.NewSweeper(server.S), cfg.SessionSweepInterval)
+	go sweeper.Start()
+	defer sweeper.Stop()
+
+	scheduler := service.NewWithInterval(server.NewScheduler(server.S, handler.AuthorizeSendTask), cfg.ScheduleInterval)
+	go scheduler.Start()
+	defer scheduler.Stop()
+
+	pipeliner := service.NewWithInterval(server.NewPipeliner(server.S, handler.AuthorizeSendTask), cfg.PipelineInterval)
+	go pipeliner.Start()
+	defer pipeliner.Stop()
+
 	gin.SetMode(gin.ReleaseMode)
 	gin.DefaultWriter = goutils.NewLogWriter(log.Info)
 	gin.DefaultErrorWriter = goutils.NewLogWriter(log.Error)
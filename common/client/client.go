@@ -27,7 +27,17 @@ func (c *Client) Request(path string, data interface{}, out interface{}) error {
 		return fmt.Errorf("json marshal data failed, %v", err)
 	}
 
-	resp, err := http.Post(c.url+path, "application/json", bytes.NewReader(buf))
+	req, err := http.NewRequest(http.MethodPost, c.url+path, bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("new http request failed, %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("http post failed, %v", err)
 	}
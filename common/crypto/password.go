@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes passwords into a self-describing format,
+// so that hashes of different hashers can live side by side.
+type PasswordHasher interface {
+	// Match reports whether hash is in the format of this hasher.
+	Match(hash string) bool
+
+	Hash(password string) (string, error)
+	Verify(hash, password string) bool
+
+	// NeedRehash reports whether a matched hash is weaker than what Hash produces now.
+	NeedRehash(hash string) bool
+}
+
+var (
+	defaultPasswordHasher PasswordHasher = NewBcryptHasher(bcrypt.DefaultCost)
+	passwordHashers                      = []PasswordHasher{defaultPasswordHasher, &SHA256Hasher{}}
+)
+
+// SetDefaultPasswordHasher sets the hasher used by HashPassword, it is also registered for verifying.
+func SetDefaultPasswordHasher(h PasswordHasher) {
+	defaultPasswordHasher = h
+	RegisterPasswordHasher(h)
+}
+
+// RegisterPasswordHasher registers h for verifying, hashers registered later take precedence.
+func RegisterPasswordHasher(h PasswordHasher) {
+	passwordHashers = append([]PasswordHasher{h}, passwordHashers...)
+}
+
+// HashPassword hashes password with the default hasher.
+func HashPassword(password string) (string, error) {
+	return defaultPasswordHasher.Hash(password)
+}
+
+// VerifyPassword verifies password against hash. needRehash is true if the password
+// is correct but hash should be replaced by HashPassword(password).
+func VerifyPassword(hash, password string) (ok bool, needRehash bool) {
+	for _, h := range passwordHashers {
+		if !h.Match(hash) {
+			continue
+		}
+
+		if !h.Verify(hash, password) {
+			return false, false
+		}
+
+		return true, h != defaultPasswordHasher || h.NeedRehash(hash)
+	}
+
+	return false, false
+}
+
+// BcryptHasher hashes passwords with bcrypt, the salt and cost are kept in the hash.
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{
+		cost: cost,
+	}
+}
+
+func (h *BcryptHasher) Match(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (h *BcryptHasher) NeedRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.cost
+}
+
+// SHA256Hasher is the legacy unsalted hasher, which keeps the hex of a single SHA256.
+// It is kept for verifying old hashes only.
+type SHA256Hasher struct{}
+
+func (*SHA256Hasher) Match(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func (*SHA256Hasher) Hash(password string) (string, error) {
+	return Sum([]byte(password)).String(), nil
+}
+
+func (*SHA256Hasher) Verify(hash, password string) bool {
+	sum := Sum([]byte(password)).String()
+	return subtle.ConstantTimeCompare([]byte(sum), []byte(hash)) == 1
+}
+
+func (*SHA256Hasher) NeedRehash(hash string) bool {
+	return true
+}
@@ -9,25 +9,49 @@ type AgentLoginResponse struct {
 	Token string `json:"token"`
 }
 
+type AgentEnrollRequest struct {
+	EnrollToken string `json:"enroll_token"`
+}
+
+type AgentEnrollResponse struct {
+	WorkerID  uint64 `json:"worker_id"`
+	WorkerKey string `json:"worker_key"`
+}
+
+type AgentLogoutRequest struct {
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+}
+
 type AgentListTaskRequest struct {
-	Token string `json:"token"`
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+}
+
+type ClaimTaskRequest struct {
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+}
+
+type ClaimTaskResponse struct {
+	// Task is nil if there is no task to claim.
+	Task *Task `json:"task"`
 }
 
 type AcceptTaskRequest struct {
-	Token  string `json:"token"`
+	Token  string `json:"token"` // Deprecated: use the Authorization header.
 	TaskID uint64 `json:"task_id"`
 }
 
 type UpdateTaskRequest struct {
-	Token    string `json:"token"`
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
 	TaskID   uint64 `json:"task_id"`
 	Progress int8   `json:"progress"`
 	Detail   string `json:"detail"`
 }
 
 type FinishTaskRequest struct {
-	Token   string `json:"token"`
+	Token   string `json:"token"` // Deprecated: use the Authorization header.
 	TaskID  uint64 `json:"task_id"`
 	Success bool   `json:"success"`
 	Detail  string `json:"detail"`
+	// Canceled is true if the task stopped for a cancel request.
+	Canceled bool `json:"canceled"`
 }
@@ -8,41 +8,162 @@ const (
 	UserEnabled  = 1
 )
 
+// User role.
+const (
+	RoleAdmin    = "admin"
+	RoleAuditor  = "auditor"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+var (
+	Roles = []string{RoleAdmin, RoleAuditor, RoleOperator, RoleViewer}
+)
+
 // Worker status.
 const (
 	WorkerDisabled = 0
 	WorkerEnabled  = 1
 )
 
+// Worker grant level, a higher level includes the lower ones.
+const (
+	GrantView   = 1
+	GrantRun    = 2
+	GrantManage = 3
+)
+
+var (
+	GrantLevelNames = map[int8]string{
+		GrantView:   "view",
+		GrantRun:    "run",
+		GrantManage: "manage",
+	}
+)
+
 // Task status.
 const (
 	TaskRecord   = 0
 	TaskAccepted = 1
 	TaskFailed   = 20
+	TaskCanceled = 30
 	TaskSuccess  = 50
 )
 
+// Lease expired policy of worker, decides what happens to an accepted task
+// whose agent stops renewing its lease.
+const (
+	// LeaseExpiredRequeue sends the task back to the queue, it resumes at the step
+	// which was running.
+	LeaseExpiredRequeue = 0
+	// LeaseExpiredFail fails the task, it is retried if it has attempts left.
+	LeaseExpiredFail = 1
+)
+
+var (
+	LeaseExpiredPolicyNames = map[int8]string{
+		LeaseExpiredRequeue: "requeue",
+		LeaseExpiredFail:    "fail",
+	}
+)
+
+// Pipeline failure policy, decides what happens to a pipeline run when one of its tasks fails.
+const (
+	// PipelineFailStop sends no more tasks, the running ones finish.
+	PipelineFailStop = 0
+	// PipelineFailCancel sends no more tasks and cancels the running ones.
+	PipelineFailCancel = 1
+	// PipelineFailContinue skips only the tasks which need the failed one,
+	// the other branches go on.
+	PipelineFailContinue = 2
+)
+
+var (
+	PipelineFailurePolicyNames = map[int8]string{
+		PipelineFailStop:     "stop",
+		PipelineFailCancel:   "cancel",
+		PipelineFailContinue: "continue",
+	}
+)
+
+// Pipeline run status.
+const (
+	PipelineRunning  = 0
+	PipelineFailed   = 20
+	PipelineCanceled = 30
+	PipelineSuccess  = 50
+)
+
+// Pipeline node status, the status of a node's task once it has been sent.
+const (
+	PipelineNodePending  = 0
+	PipelineNodeRunning  = 1
+	PipelineNodeFailed   = 20
+	PipelineNodeCanceled = 30
+	// PipelineNodeSkipped is a node never sent, for a failed upstream or a stopped run.
+	PipelineNodeSkipped = 40
+	PipelineNodeSuccess = 50
+)
+
+// Session kind.
+const (
+	SessionUser  = 1
+	SessionAgent = 2
+	// SessionTwoFactor is a login which has passed the password and waits for the 2FA code.
+	SessionTwoFactor = 3
+)
+
+// Agent login policy, decides what happens when an agent logs in as a worker
+// which already has a live agent.
+const (
+	// AgentLoginReject rejects the new login.
+	AgentLoginReject = "reject"
+	// AgentLoginReplace logs the new agent in and the old one out.
+	AgentLoginReplace = "replace"
+	// AgentLoginMultiple lets several agents work for the same worker.
+	AgentLoginMultiple = "multiple"
+)
+
+// Login lock kind.
+const (
+	LoginLockUser   = 1
+	LoginLockWorker = 2
+	LoginLockIP     = 3
+)
+
+var (
+	LoginLockKindNames = map[int8]string{
+		LoginLockUser:   "username",
+		LoginLockWorker: "worker",
+		LoginLockIP:     "ip",
+	}
+)
+
 // Content length.
 const (
 	MaxTaskDetailLen = 32 * 1024
 )
 
+// MaxTaskRetries is the upper bound of the retries of a task.
+const MaxTaskRetries = 5
+
+// MaxTaskPriority is the highest priority of a task, tasks of higher priority
+// run first, 0 is the default.
+const MaxTaskPriority = 10
+
+// MaxTaskDelay is how far in the future a task can be sent to run at, in seconds.
+const MaxTaskDelay = 90 * 24 * 3600
+
+// MaxPipelineNodes is the upper bound of the nodes of a pipeline.
+const MaxPipelineNodes = 32
+
+// MaxWorkerMaxRunTime is the upper bound of a worker's task max run time in seconds.
+const MaxWorkerMaxRunTime = 30 * 24 * 3600
+
 const (
 	AdminUsername = "admin"
 )
 
 var (
 	DefaultPassword = crypto.Sum([]byte("123456")).String()
-	InitialUserList = map[string]string{
-		AdminUsername: DefaultPassword,
-		"chris":       crypto.Sum(crypto.RandBytes(32)).String(),
-		"kendall":     crypto.Sum(crypto.RandBytes(32)).String(),
-		"arrow":       crypto.Sum(crypto.RandBytes(32)).String(),
-		"claire":      crypto.Sum(crypto.RandBytes(32)).String(),
-		"kerry":       crypto.Sum(crypto.RandBytes(32)).String(),
-		"gene":        crypto.Sum(crypto.RandBytes(32)).String(),
-		"joey":        crypto.Sum(crypto.RandBytes(32)).String(),
-		"devin":       crypto.Sum(crypto.RandBytes(32)).String(),
-		"ricki":       crypto.Sum(crypto.RandBytes(32)).String(),
-	}
 )
@@ -8,23 +8,157 @@ type UserLoginRequest struct {
 type UserLoginResponse struct {
 	UserID uint64 `json:"user_id"`
 	Token  string `json:"token"`
+
+	// TwoFactorToken is set instead of Token if the user has enabled 2FA,
+	// the login completes by /user/login-2fa with it and the code.
+	TwoFactorToken string `json:"two_factor_token"`
+}
+
+type UserLogin2FARequest struct {
+	TwoFactorToken string `json:"two_factor_token"`
+	Code           string `json:"code"` // TOTP code or recovery code.
+}
+
+type ListAuthProviderRequest struct{}
+
+// ListAuthProviderResponse is the names of the external auth providers.
+type ListAuthProviderResponse []string
+
+type ExternalLoginRequest struct {
+	Provider string `json:"provider"`
+}
+
+type ExternalLoginResponse struct {
+	URL string `json:"url"` // where to send the browser to login.
+}
+
+// ExternalLoginCallbackRequest carries the parameters the provider sends the browser back with.
+type ExternalLoginCallbackRequest struct {
+	State string `json:"state"`
+	Code  string `json:"code"`
+}
+
+type SetupTOTPRequest struct {
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+}
+
+type SetupTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth URI for authenticator apps.
+}
+
+type EnableTOTPRequest struct {
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+	Code  string `json:"code"`
+}
+
+type DisableTOTPRequest struct {
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
+	Password string `json:"password"`
+}
+
+type RegenerateRecoveryCodesRequest struct {
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+	Code  string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"` // shown only once.
 }
 
 type UserLogoutRequest struct {
-	Token string `json:"token"`
+	Token string `json:"token"` // Deprecated: use the Authorization header.
 }
 
 type UserPingRequest struct {
-	Token string `json:"token"`
+	Token string `json:"token"` // Deprecated: use the Authorization header.
 }
 
 type UserUpdatePasswordRequest struct {
-	Token    string `json:"token"`
-	Password string `json:"password"`
+	Token    string `json:"token"`    // Deprecated: use the Authorization header.
+	Password string `json:"password"` // plain text, to be checked against the password rules.
+}
+
+type ResetUserPasswordResponse struct {
+	Password string `json:"password"` // the one-time password, which is shown only once.
+}
+
+type ListSessionRequest struct {
+	Token  string `json:"token"` // Deprecated: use the Authorization header.
+	UserID uint64 `json:"user_id"`
+}
+
+type Session struct {
+	ID         uint64 `json:"id"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	Current    bool   `json:"current"`
+	CreatedAt  int64  `json:"created_at"`
+	LastUsedAt int64  `json:"last_used_at"`
+}
+
+type ListSessionResponse []*Session
+
+type RevokeSessionRequest struct {
+	Token     string `json:"token"` // Deprecated: use the Authorization header.
+	UserID    uint64 `json:"user_id"`
+	SessionID uint64 `json:"session_id"`
+}
+
+type CreateAccessTokenRequest struct {
+	Token     string   `json:"token"` // Deprecated: use the Authorization header.
+	Name      string   `json:"name"`
+	WorkerIDs []uint64 `json:"worker_ids"` // empty means all workers of the user.
+	ExpiredAt int64    `json:"expired_at"` // 0 means never expire.
+}
+
+type CreateAccessTokenResponse struct {
+	ID          uint64 `json:"id"`
+	AccessToken string `json:"access_token"`
+}
+
+type ListAccessTokenRequest struct {
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+}
+
+type AccessToken struct {
+	ID         uint64   `json:"id"`
+	Name       string   `json:"name"`
+	WorkerIDs  []uint64 `json:"worker_ids"`
+	ExpiredAt  int64    `json:"expired_at"`
+	LastUsedAt int64    `json:"last_used_at"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+type ListAccessTokenResponse []*AccessToken
+
+type RevokeAccessTokenRequest struct {
+	Token         string `json:"token"` // Deprecated: use the Authorization header.
+	AccessTokenID uint64 `json:"access_token_id"`
+}
+
+type ListLoginLockRequest struct {
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+}
+
+type LoginLock struct {
+	Kind         int8   `json:"kind"`
+	Target       string `json:"target"` // user name, worker id or ip.
+	Failures     int    `json:"failures"`
+	LastFailedAt int64  `json:"last_failed_at"`
+	LockedUntil  int64  `json:"locked_until"`
+}
+
+type ListLoginLockResponse []*LoginLock
+
+type ClearLoginLockRequest struct {
+	Token  string `json:"token"` // Deprecated: use the Authorization header.
+	Kind   int8   `json:"kind"`
+	Target string `json:"target"`
 }
 
 type ListUserRequest struct {
-	Token string `json:"token"`
+	Token string `json:"token"` // Deprecated: use the Authorization header.
 }
 
 type User struct {
@@ -32,53 +166,120 @@ type User struct {
 	Name   string `json:"name"`
 	Detail string `json:"detail"`
 	Status int8   `json:"status"`
+	Role   string `json:"role"`
+	// Provider is the external auth provider of the user, empty for local users.
+	Provider string `json:"provider"`
+	// MustChangePassword is true if the password is reset, nothing else works before it is changed.
+	MustChangePassword bool `json:"must_change_password"`
+
+	TOTPEnabled  bool `json:"totp_enabled"`
+	TOTPRequired bool `json:"totp_required"`
+	// RecoveryCodes is the count of unused recovery codes.
+	RecoveryCodes int `json:"recovery_codes"`
+	// MaxTaskPriority is the highest task priority the user can send.
+	MaxTaskPriority int8 `json:"max_task_priority"`
 }
 
 type ListUserResponse []*User
 
+type AddUserRequest struct {
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
+	Name     string `json:"name"`
+	Password string `json:"password"` // plain text, to be checked against the password rules.
+	Role     string `json:"role"`
+	Detail   string `json:"detail"`
+}
+
+type AddUserResponse struct {
+	ID uint64 `json:"id"`
+}
+
 type UserUpdateUserRequest struct {
-	Token  string `json:"token"`
+	Token  string `json:"token"` // Deprecated: use the Authorization header.
 	UserID uint64 `json:"user_id"`
+	Name   string `json:"name"`
 	Detail string `json:"detail"`
+	Role   string `json:"role"`
 }
 
 type ListWorkerRequest struct {
-	Token string `json:"token"`
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+}
+
+type WorkerGrant struct {
+	UserID uint64 `json:"user_id"`
+	Level  int8   `json:"level"`
 }
 
 type Worker struct {
 	ID        uint64 `json:"id"`
-	Key       string `json:"key"`
 	Name      string `json:"name"`
 	Desc      string `json:"desc"`
 	Status    int8   `json:"status"`
 	CreatedAt int64  `json:"created_at"`
 
-	Users []uint64 `json:"users"`
+	// MaxRunTime is the seconds an accepted task can run before it fails for timeout,
+	// 0 means the server's default.
+	MaxRunTime int64 `json:"max_run_time"`
+	// LeaseExpiredPolicy is one of LeaseExpiredRequeue and LeaseExpiredFail.
+	LeaseExpiredPolicy int8 `json:"lease_expired_policy"`
+
+	// Level is the caller's grant level on this worker.
+	Level  int8           `json:"level"`
+	Users  []uint64       `json:"users"`
+	Grants []*WorkerGrant `json:"grants"`
 }
 type ListWorkerResponse []*Worker
 
 type AddWorkerRequest struct {
-	Token string `json:"token"`
+	Token string `json:"token"` // Deprecated: use the Authorization header.
 	Name  string `json:"name"`
 	Desc  string `json:"desc"`
 }
 
 type AddWorkerResponse struct {
 	WorkerID  uint64 `json:"worker_id"`
-	WorkerKey string `json:"worker_key"`
+	WorkerKey string `json:"worker_key"` // shown only once.
+}
+
+type CreateEnrollTokenRequest struct {
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
+	WorkerID uint64 `json:"worker_id"`
+	TTL      int64  `json:"ttl"` // seconds, 0 for the default.
+}
+
+type CreateEnrollTokenResponse struct {
+	ID          uint64 `json:"id"`
+	WorkerID    uint64 `json:"worker_id"`
+	EnrollToken string `json:"enroll_token"` // shown only once.
+	ExpiredAt   int64  `json:"expired_at"`
+}
+
+type RotateWorkerKeyRequest struct {
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
+	WorkerID uint64 `json:"worker_id"`
+	Grace    int64  `json:"grace"` // seconds the old key still works.
+}
+
+type RotateWorkerKeyResponse struct {
+	WorkerID  uint64 `json:"worker_id"`
+	WorkerKey string `json:"worker_key"` // shown only once.
 }
 
 type UpdateWorkerRequest struct {
-	Token    string `json:"token"`
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
 	WorkerID uint64 `json:"worker_id"`
 	Name     string `json:"name"`
 	Desc     string `json:"desc"`
 	UserID   uint64 `json:"user_id"`
+	Level    int8   `json:"level"` // grant level of user, GrantRun if it is 0.
+
+	MaxRunTime         int64 `json:"max_run_time"` // seconds, 0 means the server's default.
+	LeaseExpiredPolicy int8  `json:"lease_expired_policy"`
 }
 
 type UserListTaskRequest struct {
-	Token    string `json:"token"`
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
 	WorkerID uint64 `json:"worker_id"`
 	Offset   int64  `json:"offset"`
 	Limit    int64  `json:"limit"`
@@ -93,6 +294,44 @@ type Task struct {
 	Detail    string `json:"detail"`
 	CreatedAt int64  `json:"created_at"`
 	UpdatedAt int64  `json:"updated_at"`
+
+	// CancelRequested is true if the task is accepted and asked to stop.
+	CancelRequested bool `json:"cancel_requested"`
+	// AcceptedAt is 0 if the task has not been accepted.
+	AcceptedAt int64 `json:"accepted_at"`
+	// Deadline is when the accepted task fails for timeout, 0 if its worker has no max run time.
+	Deadline int64 `json:"deadline"`
+
+	Attempt     int8 `json:"attempt"`
+	MaxAttempts int8 `json:"max_attempts"`
+
+	// LeaseOwner is the agent session which holds the lease of the accepted task,
+	// the lease is lost if the agent doesn't renew it before LeaseExpiredAt.
+	LeaseOwner     uint64 `json:"lease_owner"`
+	LeaseExpiredAt int64  `json:"lease_expired_at"`
+
+	Priority int8 `json:"priority"`
+	// QueuePosition counts from 1 in the queue of the worker, 0 if the task is not queued.
+	QueuePosition int64 `json:"queue_position"`
+
+	// ScheduleID is the schedule which sent the task, 0 if it was sent by a user.
+	ScheduleID uint64 `json:"schedule_id"`
+	// RunAt is when a delayed task joins the queue, 0 if it is not delayed.
+	RunAt int64 `json:"run_at"`
+	// PipelineRunID is the pipeline run which sent the task, 0 if it is not in a pipeline.
+	PipelineRunID uint64 `json:"pipeline_run_id"`
+
+	// Attempts are the finished attempts before the current one.
+	Attempts []*TaskAttempt `json:"attempts,omitempty"`
+}
+
+type TaskAttempt struct {
+	Attempt    int8   `json:"attempt"`
+	Status     int8   `json:"status"`
+	Progress   int8   `json:"progress"`
+	Detail     string `json:"detail"`
+	AcceptedAt int64  `json:"accepted_at"`
+	FinishedAt int64  `json:"finished_at"`
 }
 
 type ListTaskResponse struct {
@@ -101,17 +340,220 @@ type ListTaskResponse struct {
 }
 
 type SendTaskRequest struct {
-	Token    string `json:"token"`
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
 	WorkerID uint64 `json:"worker_id"`
 	Params   string `json:"params"`
+
+	// Retries is how many more times the task runs if it fails.
+	Retries int8 `json:"retries"`
+	// Priority is from 0 to the max priority of the sender's role, higher runs first.
+	Priority int8 `json:"priority"`
+	// RunAt is the unix time to run the task at, 0 or a past time runs it at once.
+	RunAt int64 `json:"run_at"`
 }
 
 type SendTaskResponse struct {
 	TaskID uint64 `json:"task_id"`
 }
 
+type ListScheduleRequest struct {
+	Token    string `json:"token"` // Deprecated: use the Authorization header.
+	WorkerID uint64 `json:"worker_id"`
+}
+
+type Schedule struct {
+	ID       uint64 `json:"id"`
+	WorkerID uint64 `json:"worker_id"`
+	Name     string `json:"name"`
+	Params   string `json:"params"`
+	Cron     string `json:"cron"`
+	TimeZone string `json:"time_zone"`
+	Enabled  bool   `json:"enabled"`
+	Retries  int8   `json:"retries"`
+	Priority int8   `json:"priority"`
+
+	// The tasks are sent as the owner.
+	OwnerID uint64 `json:"owner_id"`
+	Owner   string `json:"owner"`
+
+	// NextRunAt is 0 if the schedule is disabled.
+	NextRunAt int64 `json:"next_run_at"`
+	LastRunAt int64 `json:"last_run_at"`
+	// LastTaskID is 0 if the last run was skipped for LastError.
+	LastTaskID uint64 `json:"last_task_id"`
+	LastError  string `json:"last_error"`
+
+	CreatedAt int64 `json:"created_at"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+type ListScheduleResponse []*Schedule
+
+// ScheduleRequest adds a schedule to WorkerID, or updates or removes ScheduleID.
+type ScheduleRequest struct {
+	Token      string `json:"token"` // Deprecated: use the Authorization header.
+	ScheduleID uint64 `json:"schedule_id"`
+	WorkerID   uint64 `json:"worker_id"`
+
+	Name   string `json:"name"`
+	Params string `json:"params"`
+	// Cron is a cron expression of five fields: minute, hour, day of month, month
+	// and day of week, like "30 2 * * *", or one of @hourly, @daily, @weekly, @monthly.
+	Cron string `json:"cron"`
+	// TimeZone is the IANA name of the time zone of Cron, like "Asia/Shanghai", UTC if empty.
+	TimeZone string `json:"time_zone"`
+	Enabled  bool   `json:"enabled"`
+
+	Retries  int8 `json:"retries"`
+	Priority int8 `json:"priority"`
+}
+
+type AddScheduleResponse struct {
+	ScheduleID uint64 `json:"schedule_id"`
+}
+
+// PipelineNode sends a task to WorkerID once all the nodes it Needs have succeeded,
+// a node without needs starts with the pipeline run.
+type PipelineNode struct {
+	Name     string   `json:"name"`
+	WorkerID uint64   `json:"worker_id"`
+	Params   string   `json:"params"`
+	Needs    []string `json:"needs,omitempty"`
+
+	Retries  int8 `json:"retries"`
+	Priority int8 `json:"priority"`
+}
+
+type ListPipelineRequest struct {
+	Token string `json:"token"` // Deprecated: use the Authorization header.
+}
+
+type Pipeline struct {
+	ID            uint64          `json:"id"`
+	Name          string          `json:"name"`
+	Desc          string          `json:"desc"`
+	Nodes         []*PipelineNode `json:"nodes"`
+	FailurePolicy int8            `json:"failure_policy"`
+
+	// The tasks are sent as the runner, Owner is who last saved the pipeline.
+	OwnerID uint64 `json:"owner_id"`
+	Owner   string `json:"owner"`
+	// CanRun is true if the caller can send tasks to all workers of the pipeline.
+	CanRun bool `json:"can_run"`
+
+	CreatedAt int64 `json:"created_at"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+type ListPipelineResponse []*Pipeline
+
+// PipelineRequest adds a pipeline, or updates or removes PipelineID.
+type PipelineRequest struct {
+	Token      string `json:"token"` // Deprecated: use the Authorization header.
+	PipelineID uint64 `json:"pipeline_id"`
+
+	Name          string          `json:"name"`
+	Desc          string          `json:"desc"`
+	Nodes         []*PipelineNode `json:"nodes"`
+	FailurePolicy int8            `json:"failure_policy"`
+}
+
+type AddPipelineResponse struct {
+	PipelineID uint64 `json:"pipeline_id"`
+}
+
+type RunPipelineRequest struct {
+	Token      string `json:"token"` // Deprecated: use the Authorization header.
+	PipelineID uint64 `json:"pipeline_id"`
+}
+
+type RunPipelineResponse struct {
+	PipelineRunID uint64 `json:"pipeline_run_id"`
+}
+
+type ListPipelineRunRequest struct {
+	Token      string `json:"token"` // Deprecated: use the Authorization header.
+	PipelineID uint64 `json:"pipeline_id"`
+	Offset     int64  `json:"offset"`
+	Limit      int64  `json:"limit"`
+}
+
+type PipelineRun struct {
+	ID            uint64 `json:"id"`
+	PipelineID    uint64 `json:"pipeline_id"`
+	Name          string `json:"name"`
+	Runner        string `json:"runner"`
+	Status        int8   `json:"status"`
+	FailurePolicy int8   `json:"failure_policy"`
+
+	CancelRequested bool `json:"cancel_requested"`
+
+	// Nodes are in the order they can be sent.
+	Nodes []*PipelineRunNode `json:"nodes"`
+
+	CreatedAt int64 `json:"created_at"`
+	// FinishedAt is 0 if the run is running.
+	FinishedAt int64 `json:"finished_at"`
+}
+
+type PipelineRunNode struct {
+	Name     string   `json:"name"`
+	WorkerID uint64   `json:"worker_id"`
+	Params   string   `json:"params"`
+	Needs    []string `json:"needs,omitempty"`
+	Status   int8     `json:"status"`
+	// TaskID is 0 if the node has not been sent.
+	TaskID    uint64 `json:"task_id"`
+	Detail    string `json:"detail"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+type ListPipelineRunResponse struct {
+	Total int64          `json:"total"`
+	Runs  []*PipelineRun `json:"runs"`
+}
+
+type CancelPipelineRunRequest struct {
+	Token         string `json:"token"` // Deprecated: use the Authorization header.
+	PipelineRunID uint64 `json:"pipeline_run_id"`
+}
+
+type ListAuditRequest struct {
+	Token   string `json:"token"` // Deprecated: use the Authorization header.
+	ActorID uint64 `json:"actor_id"`
+	Action  string `json:"action"`
+	Target  string `json:"target"` // like "worker:1", "user:2".
+	Since   int64  `json:"since"`
+	Until   int64  `json:"until"`
+	Offset  int64  `json:"offset"`
+	Limit   int64  `json:"limit"`
+}
+
+type AuditEvent struct {
+	ID            uint64 `json:"id"`
+	ActorID       uint64 `json:"actor_id"`
+	Actor         string `json:"actor"`
+	AccessTokenID uint64 `json:"access_token_id"`
+	Action        string `json:"action"`
+	Target        string `json:"target"`
+	Summary       string `json:"summary"`
+	IP            string `json:"ip"`
+	Error         string `json:"error"`
+	CreatedAt     int64  `json:"created_at"`
+}
+
+type ListAuditResponse struct {
+	Total  int64         `json:"total"`
+	Events []*AuditEvent `json:"events"`
+}
+
+type CancelTaskRequest struct {
+	Token  string `json:"token"` // Deprecated: use the Authorization header.
+	TaskID uint64 `json:"task_id"`
+}
+
 type TaskStatusRequest struct {
-	Token  string `json:"token"`
+	Token  string `json:"token"` // Deprecated: use the Authorization header.
 	TaskID uint64 `json:"task_id"`
 }
 
@@ -119,4 +561,16 @@ type TaskStatusResponse struct {
 	Status   int8   `json:"status"`
 	Progress int8   `json:"progress"`
 	Detail   string `json:"detail"`
+
+	CancelRequested bool `json:"cancel_requested"`
+
+	Attempt     int8 `json:"attempt"`
+	MaxAttempts int8 `json:"max_attempts"`
+
+	LeaseOwner     uint64 `json:"lease_owner"`
+	LeaseExpiredAt int64  `json:"lease_expired_at"`
+
+	Priority      int8  `json:"priority"`
+	QueuePosition int64 `json:"queue_position"`
+	RunAt         int64 `json:"run_at"`
 }
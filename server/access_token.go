@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// AccessTokenPrefix tells access tokens from session tokens.
+const AccessTokenPrefix = "pat_"
+
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+// CreateAccessToken creates an access token for user, only the hash of it is stored,
+// so the returned plain token can't be got again.
+func (s *Server) CreateAccessToken(userID uint64, name string, workerIDs []uint64, expiredAt *time.Time) (*model.AccessToken, string, error) {
+	token := AccessTokenPrefix + hex.EncodeToString(crypto.RandBytes(32))
+	accessToken := model.NewAccessToken(userID, name, crypto.Sum([]byte(token)).String(), workerIDs, expiredAt)
+	err := accessToken.Insert()
+	if err != nil {
+		return nil, "", fmt.Errorf("db insert access token failed, %v", err)
+	}
+
+	return accessToken, token, nil
+}
+
+func (s *Server) ValidAccessToken(token string) (*model.AccessToken, bool) {
+	accessToken, err := model.FindAccessTokenByHash(crypto.Sum([]byte(token)).String())
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Errorf("db find access token failed, %v", err)
+		}
+		return nil, false
+	}
+
+	now := time.Now()
+	if accessToken.Expired(now) {
+		return nil, false
+	}
+
+	if accessToken.LastUsedAt == nil || now.Sub(*accessToken.LastUsedAt) >= sessionTouchInterval {
+		if err := accessToken.Touch(); err != nil {
+			log.Errorf("db touch access token failed, %v", err)
+		}
+	}
+
+	return accessToken, true
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// authStateTTL limits how long a redirect login can stay at the auth provider.
+	authStateTTL = time.Minute * 10
+	// maxUsernameLen is the size of model.User.Name.
+	maxUsernameLen = 32
+	// externalPassword is the password of external users, it matches no password
+	// hasher so that no password is accepted for them.
+	externalPassword = "!"
+)
+
+var (
+	errAuthProviderNotExist = fmt.Errorf("auth provider not exist")
+	errInvalidAuthState     = fmt.Errorf("invalid or expired login state, please login again")
+	errNoRoleMapped         = fmt.Errorf("none of your groups is allowed to login")
+)
+
+// AuthProvider is a source of user identities.
+type AuthProvider interface {
+	// Name identifies the provider, it is kept in the users it creates.
+	Name() string
+}
+
+// PasswordAuthProvider checks a user name and password.
+type PasswordAuthProvider interface {
+	AuthProvider
+
+	// Authenticate returns the user, or errIncorrectPassword if the password is wrong.
+	Authenticate(username, password string) (*model.User, error)
+}
+
+// RedirectAuthProvider authenticates by sending the browser to an external issuer,
+// which sends it back with a code.
+type RedirectAuthProvider interface {
+	AuthProvider
+
+	// AuthURL returns the url to send the browser to, state, nonce and verifier
+	// bind the login to the one completed by Exchange.
+	AuthURL(state, nonce, verifier string) (string, error)
+	// Exchange redeems code for the identity of the user.
+	Exchange(code, nonce, verifier string) (*Identity, error)
+}
+
+// Identity is a user authenticated by an external provider.
+type Identity struct {
+	Provider string
+	// Subject is the unique and stable id of the user in the provider.
+	Subject  string
+	Username string
+	Role     string
+}
+
+type localPasswordProvider struct{}
+
+// NewLocalPasswordProvider returns the provider of the users registered in this server.
+func NewLocalPasswordProvider() PasswordAuthProvider {
+	return &localPasswordProvider{}
+}
+
+func (*localPasswordProvider) Name() string { return "password" }
+
+func (*localPasswordProvider) Authenticate(username, password string) (*model.User, error) {
+	user, err := model.FindUserByName(username)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errIncorrectPassword
+		}
+
+		return nil, fmt.Errorf("db find user failed, %v", err)
+	}
+
+	if user.External() {
+		return nil, errIncorrectPassword
+	}
+
+	ok, needRehash := crypto.VerifyPassword(user.Password, password)
+	if !ok {
+		return nil, errIncorrectPassword
+	}
+
+	if needRehash {
+		if hash, err := crypto.HashPassword(password); err != nil {
+			log.Errorf("rehash user %d password failed, %v", user.ID, err)
+		} else if err := user.UpdatePassword(hash); err != nil {
+			log.Errorf("db update user %d password failed, %v", user.ID, err)
+		}
+	}
+
+	return user, nil
+}
+
+// RegisterAuthProvider adds a redirect provider users can login with.
+func (s *Server) RegisterAuthProvider(p RedirectAuthProvider) {
+	s.providers[p.Name()] = p
+}
+
+// AuthProviders returns the names of the redirect providers.
+func (s *Server) AuthProviders() []string {
+	names := make([]string, 0, len(s.providers))
+	for _, p := range s.cfg.OIDCProviders {
+		if _, ok := s.providers[p.Name]; ok {
+			names = append(names, p.Name)
+		}
+	}
+
+	for name := range s.providers {
+		found := false
+		for _, n := range names {
+			if n == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// StartExternalLogin returns the url of provider to send the browser to.
+func (s *Server) StartExternalLogin(provider string) (string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", errAuthProviderNotExist
+	}
+
+	var (
+		state    = GenToken()
+		nonce    = GenToken()
+		verifier = GenToken()
+	)
+
+	url, err := p.AuthURL(state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	authState := model.NewAuthState(state, provider, nonce, verifier, time.Now().Add(authStateTTL))
+	err = authState.Insert()
+	if err != nil {
+		return "", fmt.Errorf("db insert auth state failed, %v", err)
+	}
+
+	return url, nil
+}
+
+// FinishExternalLogin completes the login of StartExternalLogin when the browser comes back
+// with state and code. The user is created at the first login, and its role follows the provider.
+func (s *Server) FinishExternalLogin(state, code, ip, userAgent string) (uint64, string, error) {
+	authState, err := model.FindAuthState(state)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, "", errInvalidAuthState
+		}
+
+		return 0, "", fmt.Errorf("db find auth state failed, %v", err)
+	}
+
+	ok, err := authState.Take()
+	if err != nil {
+		return 0, "", fmt.Errorf("db delete auth state failed, %v", err)
+	}
+
+	if !ok || time.Now().After(authState.ExpiredAt) {
+		return 0, "", errInvalidAuthState
+	}
+
+	p, ok := s.providers[authState.Provider]
+	if !ok {
+		return 0, "", errAuthProviderNotExist
+	}
+
+	identity, err := p.Exchange(code, authState.Nonce, authState.Verifier)
+	if err != nil {
+		return 0, "", err
+	}
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	user, err := s.provisionUser(identity, ip)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if user.Status == types.UserDisabled {
+		return 0, "", errUserDisabled
+	}
+
+	session, err := s.sessions.Create(types.SessionUser, uint64(user.ID), ip, userAgent)
+	if err != nil {
+		return 0, "", fmt.Errorf("db create session failed, %v", err)
+	}
+
+	return uint64(user.ID), session.Token, nil
+}
+
+// provisionUser returns the user of identity, which is created if it doesn't exist.
+// The user's role is synced with the identity.
+func (s *Server) provisionUser(identity *Identity, ip string) (*model.User, error) {
+	if len(identity.Role) == 0 {
+		return nil, errNoRoleMapped
+	}
+
+	user, err := model.FindUserBySubject(identity.Provider, identity.Subject)
+	if err == nil {
+		if user.Role != identity.Role {
+			err = user.UpdateRole(identity.Role)
+			if err != nil {
+				return nil, fmt.Errorf("db update user role failed, %v", err)
+			}
+
+			user.Role = identity.Role
+		}
+
+		return user, nil
+	}
+
+	if err != gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("db find user failed, %v", err)
+	}
+
+	name, err := s.availableUsername(identity.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	user = model.NewExternalUser(name, externalPassword, identity.Role, identity.Provider, identity.Subject)
+	err = user.Insert()
+	if err != nil {
+		return nil, fmt.Errorf("db insert user failed, %v", err)
+	}
+
+	target := fmt.Sprintf("user:%d", user.ID)
+	summary := fmt.Sprintf(`{"provider":%q,"subject":%q,"role":%q}`, identity.Provider, identity.Subject, identity.Role)
+	event := model.NewAuditEvent(0, "", 0, "provision-user", target, summary, ip, "")
+	if err := event.Insert(); err != nil {
+		log.Errorf("db insert audit event provision-user failed, %v", err)
+	}
+
+	return user, nil
+}
+
+// availableUsername returns name, or name with a number suffix if it is taken,
+// an external user never takes over a local one of the same name.
+func (s *Server) availableUsername(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) > maxUsernameLen {
+		name = name[:maxUsernameLen]
+	}
+
+	if len(name) == 0 {
+		return "", fmt.Errorf("user name of the identity can't be empty")
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := name
+		if i > 1 {
+			suffix := fmt.Sprintf("-%d", i)
+			if len(candidate)+len(suffix) > maxUsernameLen {
+				candidate = candidate[:maxUsernameLen-len(suffix)]
+			}
+			candidate += suffix
+		}
+
+		_, err := model.FindUserByName(candidate)
+		if err == gorm.ErrRecordNotFound {
+			return candidate, nil
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("db find user failed, %v", err)
+		}
+	}
+
+	return "", fmt.Errorf("no available user name for %s", name)
+}
+
+func validRole(role string) bool {
+	for _, r := range types.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SweepAuthStates deletes the expired redirect logins.
+func (s *Server) SweepAuthStates() error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	err := model.DeleteExpiredAuthStates(time.Now())
+	if err != nil {
+		return fmt.Errorf("db delete expired auth states failed, %v", err)
+	}
+
+	return nil
+}
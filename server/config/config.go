@@ -1,17 +1,204 @@
 package config
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
 	"github.com/tidyoux/goutils/viper"
 )
 
 type Config struct {
 	DSN  string
 	Port string
+
+	// InitialUsers are registered at startup with random passwords,
+	// admin should reset their passwords before they can login.
+	InitialUsers []string
+
+	UserSessionIdleTTL   time.Duration
+	UserSessionTTL       time.Duration
+	AgentSessionIdleTTL  time.Duration
+	AgentSessionTTL      time.Duration
+	SessionSweepInterval time.Duration
+
+	// TOTPRequiredRoles are the roles whose users must enroll 2FA before doing anything else.
+	TOTPRequiredRoles []string
+	// TOTPIssuer names this server in authenticator apps.
+	TOTPIssuer string
+
+	// A password must have PasswordMinLength characters of PasswordCharClasses classes
+	// at least, the classes are lower case, upper case, digit and symbol. It can't be one
+	// of the last PasswordHistory passwords of the user.
+	PasswordMinLength   int64
+	PasswordCharClasses int64
+	PasswordHistory     int64
+
+	// OIDCProviders are the OpenID Connect providers users can login with.
+	OIDCProviders []*OIDCProvider
+
+	// TaskMaxRunTime is how long an accepted task can run before it fails for timeout,
+	// unless its worker has its own. 0 means no limit.
+	TaskMaxRunTime time.Duration
+	// TaskMaxPriorities is the highest task priority each role can send,
+	// roles not in it can only send tasks of priority 0.
+	TaskMaxPriorities map[string]int8
+
+	// TaskLeaseTTL is how long the lease of an accepted task lasts without being renewed
+	// by its agent. 0 means leases never expire.
+	TaskLeaseTTL time.Duration
+
+	// ScheduleInterval is how often the scheduler looks for due schedules.
+	ScheduleInterval time.Duration
+
+	// PipelineInterval is how often the pipeliner sends the tasks of running pipeline
+	// runs whose upstream tasks have succeeded.
+	PipelineInterval time.Duration
+
+	// AgentLoginPolicy is one of reject, replace and multiple, see types.AgentLoginReject.
+	AgentLoginPolicy string
+
+	// A user name or worker is locked out after LoginMaxFailures failed logins
+	// within LoginFailureWindow, an IP after LoginIPMaxFailures. The lockout starts
+	// from LoginLockout and doubles with each more failure, up to LoginMaxLockout.
+	LoginMaxFailures   int64
+	LoginIPMaxFailures int64
+	LoginFailureWindow time.Duration
+	LoginLockout       time.Duration
+	LoginMaxLockout    time.Duration
+}
+
+// OIDCProvider is an OpenID Connect provider, its users are created at their first login.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends the browser back, the web app of this server.
+	RedirectURL string
+	Scopes      []string
+
+	UsernameClaim string
+	GroupsClaim   string
+	// GroupRoles maps the provider's groups to roles, a user gets the highest role of its groups,
+	// or DefaultRole if none of its groups is mapped. Login is refused if DefaultRole is empty then.
+	GroupRoles  map[string]string
+	DefaultRole string
 }
 
-func NewConfig() *Config {
-	return &Config{
+func NewConfig() (*Config, error) {
+	c := &Config{
 		DSN:  viper.GetString("dsn", ""),
 		Port: viper.GetString("port", ":8080"),
+
+		InitialUsers: viper.GetStringSlice("initialUsers", nil),
+
+		UserSessionIdleTTL:   viper.GetDuration("userSessionIdleTTL", time.Hour*24),
+		UserSessionTTL:       viper.GetDuration("userSessionTTL", time.Hour*24*7),
+		AgentSessionIdleTTL:  viper.GetDuration("agentSessionIdleTTL", time.Minute*10),
+		AgentSessionTTL:      viper.GetDuration("agentSessionTTL", time.Hour*24),
+		SessionSweepInterval: viper.GetDuration("sessionSweepInterval", time.Minute),
+
+		TOTPRequiredRoles: viper.GetStringSlice("totpRequiredRoles", nil),
+		TOTPIssuer:        viper.GetString("totpIssuer", "router"),
+
+		PasswordMinLength:   viper.GetInt64("passwordMinLength", 8),
+		PasswordCharClasses: viper.GetInt64("passwordCharClasses", 2),
+		PasswordHistory:     viper.GetInt64("passwordHistory", 3),
+
+		TaskMaxRunTime: viper.GetDuration("taskMaxRunTime", 0),
+		TaskLeaseTTL:   viper.GetDuration("taskLeaseTTL", time.Minute),
+		TaskMaxPriorities: map[string]int8{
+			types.RoleAdmin:    types.MaxTaskPriority,
+			types.RoleOperator: types.MaxTaskPriority / 2,
+		},
+
+		ScheduleInterval: viper.GetDuration("scheduleInterval", time.Second*10),
+		PipelineInterval: viper.GetDuration("pipelineInterval", time.Second*5),
+
+		AgentLoginPolicy: viper.GetString("agentLoginPolicy", types.AgentLoginReplace),
+
+		LoginMaxFailures:   viper.GetInt64("loginMaxFailures", 5),
+		LoginIPMaxFailures: viper.GetInt64("loginIPMaxFailures", 20),
+		LoginFailureWindow: viper.GetDuration("loginFailureWindow", time.Minute*15),
+		LoginLockout:       viper.GetDuration("loginLockout", time.Minute),
+		LoginMaxLockout:    viper.GetDuration("loginMaxLockout", time.Hour),
+	}
+
+	if priorities, ok := viper.Get("taskMaxPriorities").(map[interface{}]interface{}); ok {
+		c.TaskMaxPriorities = make(map[string]int8)
+		for role, priority := range priorities {
+			role, _ := role.(string)
+			priority, ok := priority.(int)
+			if len(role) == 0 || !ok || priority < 0 || priority > types.MaxTaskPriority {
+				return nil, fmt.Errorf("parse task max priorities failed, invalid format")
+			}
+
+			c.TaskMaxPriorities[role] = int8(priority)
+		}
+	}
+
+	providers, _ := viper.Get("oidcProviders").([]interface{})
+	for i, provider := range providers {
+		provider, ok := provider.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("parse oidc provider config at index %d failed, invalid format", i)
+		}
+
+		p := &OIDCProvider{
+			Name:          stringValue(provider, "name", ""),
+			Issuer:        stringValue(provider, "issuer", ""),
+			ClientID:      stringValue(provider, "clientID", ""),
+			ClientSecret:  stringValue(provider, "clientSecret", ""),
+			RedirectURL:   stringValue(provider, "redirectURL", ""),
+			Scopes:        stringsValue(provider, "scopes", []string{"openid", "profile", "email"}),
+			UsernameClaim: stringValue(provider, "usernameClaim", "preferred_username"),
+			GroupsClaim:   stringValue(provider, "groupsClaim", "groups"),
+			GroupRoles:    make(map[string]string),
+			DefaultRole:   stringValue(provider, "defaultRole", ""),
+		}
+
+		if len(p.Name) == 0 || len(p.Issuer) == 0 || len(p.ClientID) == 0 || len(p.RedirectURL) == 0 {
+			return nil, fmt.Errorf("parse oidc provider config at index %d failed, name, issuer, clientID and redirectURL can't be empty", i)
+		}
+
+		groupRoles, _ := provider["groupRoles"].(map[interface{}]interface{})
+		for group, role := range groupRoles {
+			group, _ := group.(string)
+			role, _ := role.(string)
+			if len(group) == 0 || len(role) == 0 {
+				return nil, fmt.Errorf("parse oidc provider %s group roles failed, invalid format", p.Name)
+			}
+
+			p.GroupRoles[group] = role
+		}
+
+		c.OIDCProviders = append(c.OIDCProviders, p)
 	}
+
+	return c, nil
+}
+
+func stringValue(m map[interface{}]interface{}, key, defaultValue string) string {
+	if v, ok := m[key].(string); ok {
+		return v
+	}
+
+	return defaultValue
+}
+
+func stringsValue(m map[interface{}]interface{}, key string, defaultValue []string) []string {
+	values, ok := m[key].([]interface{})
+	if !ok {
+		return defaultValue
+	}
+
+	ss := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			ss = append(ss, s)
+		}
+	}
+
+	return ss
 }
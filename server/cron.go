@@ -0,0 +1,211 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchYears bounds the search of the next run time, an expression which
+// doesn't match in it, like "0 0 30 2 *", never runs.
+const cronSearchYears = 5
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names of the values from min, if any.
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{"day of week", 0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// Cron is a parsed cron expression of five fields: minute, hour, day of month,
+// month and day of week. A field is *, or a list of values and ranges with an
+// optional step, like "1,15", "1-5" and "*/10". Months and days of week can be
+// named, 0 and 7 are both Sunday. As in cron, a day matches either day field
+// if both are restricted. The descriptors @yearly, @monthly, @weekly, @daily
+// and @hourly are supported too.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are true if the day fields start with *.
+	domAny, dowAny bool
+}
+
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if s, ok := cronDescriptors[strings.ToLower(expr)]; ok {
+		expr = s
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q, should have %d fields", expr, len(cronFields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, f := range fields {
+		b, err := parseCronField(f, &cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q, %v", expr, err)
+		}
+		bits[i] = b
+	}
+
+	c := &Cron{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}
+
+	// Sunday is 0 in time.Weekday.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+func parseCronField(s string, f *cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		rangeStr, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, item)
+			}
+			rangeStr, step = item[:i], n
+		}
+
+		var lo, hi int
+		switch {
+		case rangeStr == "*":
+			lo, hi = f.min, f.max
+		case strings.Contains(rangeStr, "-"):
+			i := strings.Index(rangeStr, "-")
+			var err error
+			lo, err = parseCronValue(rangeStr[:i], f)
+			if err != nil {
+				return 0, err
+			}
+
+			hi, err = parseCronValue(rangeStr[i+1:], f)
+			if err != nil {
+				return 0, err
+			}
+
+			if lo > hi {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, rangeStr)
+			}
+		default:
+			var err error
+			lo, err = parseCronValue(rangeStr, f)
+			if err != nil {
+				return 0, err
+			}
+
+			// "5/15" means from 5 to the max by 15.
+			hi = lo
+			if step > 1 {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(s string, f *cronField) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q, should be in [%d, %d]", f.name, s, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+func (c *Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// Next returns the first time after t the expression matches, in the location of t.
+// It returns the zero time if there is none in the next years.
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + cronSearchYears
+
+WRAP:
+	for t.Year() <= limit {
+		for c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			if t.Month() == time.January {
+				continue WRAP
+			}
+		}
+
+		for !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			if t.Day() == 1 {
+				continue WRAP
+			}
+		}
+
+		// Hours and minutes are added instead of set, so that they move on
+		// across the changes of daylight saving time.
+		for c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+			if t.Hour() == 0 {
+				continue WRAP
+			}
+		}
+
+		for c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			if t.Minute() == 0 {
+				continue WRAP
+			}
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
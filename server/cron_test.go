@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tidyoux/router/server"
+)
+
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		expr  string
+		after time.Time
+		next  time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC), time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 1, 1, 2, 30, 0, 0, shanghai), time.Date(2024, 1, 2, 2, 30, 0, 0, shanghai)},
+		{"*/15 9-17 * * mon-fri", time.Date(2024, 3, 8, 17, 50, 0, 0, time.UTC), time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches if both are restricted.
+		{"0 0 13 * fri", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, 1, 1, 0, 45, 0, 0, time.UTC), time.Date(2024, 1, 1, 1, 5, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// 2:30 doesn't exist when daylight saving time starts.
+		{"30 2 * * *", time.Date(2024, 3, 9, 3, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+		{"0 * * * *", time.Date(2024, 11, 3, 0, 30, 0, 0, newYork), time.Date(2024, 11, 3, 1, 0, 0, 0, newYork)},
+	}
+
+	for _, c := range cases {
+		cron, err := server.ParseCron(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+
+		next := cron.Next(c.after)
+		if !next.Equal(c.next) {
+			t.Fatalf("%s after %s got %s, want %s", c.expr, c.after, next, c.next)
+		}
+	}
+}
+
+func TestCronNextAcrossFallBack(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cron, err := server.ParseCron("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 1:00 comes twice when daylight saving time ends, both run.
+	t1 := cron.Next(time.Date(2024, 11, 3, 0, 30, 0, 0, newYork))
+	t2 := cron.Next(t1)
+	t3 := cron.Next(t2)
+	if t2.Sub(t1) != time.Hour || t3.Sub(t2) != time.Hour {
+		t.Fatalf("got %s, %s, %s", t1, t2, t3)
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@often",
+	} {
+		if _, err := server.ParseCron(expr); err == nil {
+			t.Fatalf("%q parsed", expr)
+		}
+	}
+
+	if _, err := server.NextScheduleRun("0 0 30 2 *", "", time.Now()); err == nil {
+		t.Fatal("a cron expression never matches got a next run")
+	}
+
+	if _, err := server.NextScheduleRun("@daily", "Mars/Olympus", time.Now()); err == nil {
+		t.Fatal("an unknown time zone got a next run")
+	}
+}
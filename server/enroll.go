@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// EnrollTokenPrefix tells enrollment tokens from the other tokens.
+const EnrollTokenPrefix = "enr_"
+
+var (
+	errInvalidEnrollToken = fmt.Errorf("invalid or used enrollment token")
+)
+
+// CreateEnrollToken creates a single use enrollment token for the worker, which expires after ttl.
+// Only the hash of it is stored, so the returned plain token can't be got again.
+func (s *Server) CreateEnrollToken(workerID, creatorID uint64, ttl time.Duration) (*model.EnrollToken, string, error) {
+	token := EnrollTokenPrefix + hex.EncodeToString(crypto.RandBytes(32))
+	enrollToken := model.NewEnrollToken(workerID, creatorID, crypto.Sum([]byte(token)).String(), time.Now().Add(ttl))
+	err := enrollToken.Insert()
+	if err != nil {
+		return nil, "", fmt.Errorf("db insert enroll token failed, %v", err)
+	}
+
+	return enrollToken, token, nil
+}
+
+// Enroll exchanges an enrollment token for the credentials of its worker.
+// The worker's key is rotated, so that the plain key can be returned.
+func (s *Server) Enroll(token, ip string) (uint64, string, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	var targets []loginTarget
+	if len(ip) > 0 {
+		targets = append(targets, loginTarget{types.LoginLockIP, ip})
+	}
+	if err := s.checkLoginLocks(targets); err != nil {
+		return 0, "", err
+	}
+
+	enrollToken, err := model.FindEnrollTokenByHash(crypto.Sum([]byte(token)).String())
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			s.loginFailed(targets, ip)
+			return 0, "", errInvalidEnrollToken
+		}
+
+		return 0, "", fmt.Errorf("db find enroll token failed, %v", err)
+	}
+
+	if !enrollToken.Valid(time.Now()) {
+		s.loginFailed(targets, ip)
+		return 0, "", errInvalidEnrollToken
+	}
+
+	worker, err := model.FindWorkerByID(enrollToken.WorkerID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, "", errWorkerNotExist
+		}
+
+		return 0, "", fmt.Errorf("db find worker failed, %v", err)
+	}
+
+	if worker.Status == types.WorkerDisabled {
+		return 0, "", errWorkerDisabled
+	}
+
+	ok, err := enrollToken.Use()
+	if err != nil {
+		return 0, "", fmt.Errorf("db use enroll token failed, %v", err)
+	}
+
+	if !ok {
+		return 0, "", errInvalidEnrollToken
+	}
+
+	key, err := s.rotateWorkerKey(worker, 0)
+	if err != nil {
+		return 0, "", err
+	}
+
+	summary := fmt.Sprintf(`{"enroll_token_id":%d}`, enrollToken.ID)
+	target := fmt.Sprintf("worker:%d", worker.ID)
+	event := model.NewAuditEvent(0, "", 0, "enroll", target, summary, ip, "")
+	if err := event.Insert(); err != nil {
+		log.Errorf("db insert audit event enroll failed, %v", err)
+	}
+
+	return uint64(worker.ID), key, nil
+}
+
+// SweepEnrollTokens deletes the enrollment tokens which are used or expired.
+func (s *Server) SweepEnrollTokens() error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	err := model.DeleteStaleEnrollTokens(time.Now())
+	if err != nil {
+		return fmt.Errorf("db delete stale enroll tokens failed, %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	MinAccessTokenNameLen = 1
+	MaxAccessTokenNameLen = 64
+	MaxAccessTokenCount   = 32
+)
+
+// validLoginUser returns the caller if it comes with a login session. Access tokens
+// can't manage access tokens or 2FA, or a leaked one could renew or lock in itself.
+func validLoginUser(ctx *Context) (*Caller, error) {
+	user := ctx.User
+	if user.AccessTokenID > 0 {
+		return nil, errAccessTokenNotAllowed
+	}
+
+	return user, nil
+}
+
+func init() {
+	Audited("/user/create-access-token", func(ctx *Context, req *types.CreateAccessTokenRequest) (*types.CreateAccessTokenResponse, error) {
+		user, err := validLoginUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if len(req.Name) < MinAccessTokenNameLen || MaxAccessTokenNameLen < len(req.Name) {
+			return nil, fmt.Errorf("invalid access token name length %d, should in [%d, %d]",
+				len(req.Name), MinAccessTokenNameLen, MaxAccessTokenNameLen)
+		}
+
+		var expiredAt *time.Time
+		if req.ExpiredAt > 0 {
+			t := time.Unix(req.ExpiredAt, 0)
+			if t.Before(time.Now()) {
+				return nil, fmt.Errorf("invalid access token expire time, it has passed")
+			}
+			expiredAt = &t
+		}
+
+		for _, workerID := range req.WorkerIDs {
+			level, err := workerLevel(user, workerID)
+			if err != nil {
+				return nil, err
+			}
+
+			if level == 0 {
+				return nil, fmt.Errorf("invalid worker %d, %v", workerID, errWorkerOwner)
+			}
+		}
+
+		tokens, err := model.FindAccessTokensByUserID(uint64(user.ID))
+		if err != nil {
+			return nil, fmt.Errorf("db find access tokens failed, %v", err)
+		}
+
+		if len(tokens) >= MaxAccessTokenCount {
+			return nil, fmt.Errorf("too many access tokens, should <= %d", MaxAccessTokenCount)
+		}
+
+		accessToken, token, err := server.S.CreateAccessToken(uint64(user.ID), req.Name, req.WorkerIDs, expiredAt)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.CreateAccessTokenResponse{
+			ID:          uint64(accessToken.ID),
+			AccessToken: token,
+		}, nil
+	})
+
+	H("/user/list-access-token", func(ctx *Context, req *types.ListAccessTokenRequest) (types.ListAccessTokenResponse, error) {
+		user, err := validLoginUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens, err := model.FindAccessTokensByUserID(uint64(user.ID))
+		if err != nil {
+			return nil, fmt.Errorf("db find access tokens failed, %v", err)
+		}
+
+		resp := make([]*types.AccessToken, 0, len(tokens))
+		for _, t := range tokens {
+			token := &types.AccessToken{
+				ID:        uint64(t.ID),
+				Name:      t.Name,
+				WorkerIDs: t.WorkerIDs(),
+				CreatedAt: t.CreatedAt.Unix(),
+			}
+			if t.ExpiredAt != nil {
+				token.ExpiredAt = t.ExpiredAt.Unix()
+			}
+			if t.LastUsedAt != nil {
+				token.LastUsedAt = t.LastUsedAt.Unix()
+			}
+			resp = append(resp, token)
+		}
+		return resp, nil
+	})
+
+	Audited("/user/revoke-access-token", func(ctx *Context, req *types.RevokeAccessTokenRequest) (bool, error) {
+		user, err := validLoginUser(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		token, err := model.FindAccessTokenByID(req.AccessTokenID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return true, nil
+			}
+
+			return false, fmt.Errorf("db find access token failed, %v", err)
+		}
+
+		if token.UserID != uint64(user.ID) {
+			return false, errPermissionDenied
+		}
+
+		err = token.Delete()
+		if err != nil {
+			return false, fmt.Errorf("db delete access token failed, %v", err)
+		}
+
+		return true, nil
+	})
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/tidyoux/router/common/crypto"
 	"github.com/tidyoux/router/common/types"
@@ -17,15 +18,22 @@ const (
 	MinWorkerNameLen = 1
 	MaxWorkerNameLen = 32
 	MaxWorkerDescLen = 1024
+
+	MaxWorkerKeyGrace = time.Hour * 24 * 7
+
+	DefaultEnrollTokenTTL = time.Hour
+	MaxEnrollTokenTTL     = time.Hour * 24
 )
 
 var (
-	errPermissionDenied = fmt.Errorf("permission denied")
+	errUsernameAlreadyExist = fmt.Errorf("username already exist")
+	errBuiltinAdmin         = fmt.Errorf("can't rename or delete the builtin admin user")
 )
 
 func init() {
-	H("/user/list-user", func(req *types.ListUserRequest) (types.ListUserResponse, error) {
-		if err := validAdmin(req.Token); err != nil {
+	H("/user/list-user", func(ctx *Context, req *types.ListUserRequest) (types.ListUserResponse, error) {
+		user, err := authorize(ctx, PermViewUser, 0)
+		if err != nil {
 			return nil, err
 		}
 
@@ -36,20 +44,213 @@ func init() {
 
 		resp := make([]*types.User, 0, len(users))
 		for _, u := range users {
-			if u.Name != types.AdminUsername {
+			if u.ID != user.ID {
 				resp = append(resp, &types.User{
-					ID:     uint64(u.ID),
-					Name:   u.Name,
-					Detail: u.Detail,
-					Status: u.Status,
+					ID:                 uint64(u.ID),
+					Name:               u.Name,
+					Detail:             u.Detail,
+					Status:             u.Status,
+					Role:               u.Role,
+					Provider:           u.Provider,
+					MustChangePassword: u.MustChangePassword,
+					TOTPEnabled:        u.TOTPEnabled,
+					TOTPRequired:       server.S.TOTPRequired(u),
 				})
 			}
 		}
 		return resp, nil
 	})
 
-	H("user/update-user-detail", func(req *types.UserUpdateUserRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	Audited("/user/add-user", func(ctx *Context, req *types.AddUserRequest) (*types.AddUserResponse, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
+			return nil, err
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		req.Password = strings.TrimSpace(req.Password)
+		req.Detail = strings.TrimSpace(req.Detail)
+
+		err := validUsernamePassword(req.Name, req.Password)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(req.Role) == 0 {
+			req.Role = types.RoleOperator
+		}
+
+		if !validRole(req.Role) {
+			return nil, fmt.Errorf("invalid role %s, should be one of %v", req.Role, types.Roles)
+		}
+
+		if len(req.Detail) > MaxUserDetailLen {
+			return nil, fmt.Errorf("invalid user detail length %d, should <= %d",
+				len(req.Detail), MaxUserDetailLen)
+		}
+
+		_, err = model.FindUserByName(req.Name)
+		if err == nil {
+			return nil, errUsernameAlreadyExist
+		}
+
+		if err != gorm.ErrRecordNotFound {
+			return nil, fmt.Errorf("db find user failed, %v", err)
+		}
+
+		// Clients send the digest of passwords to login.
+		hash, err := crypto.HashPassword(crypto.Sum([]byte(req.Password)).String())
+		if err != nil {
+			return nil, fmt.Errorf("hash password failed, %v", err)
+		}
+
+		user := model.NewUser(req.Name, hash)
+		user.Detail = req.Detail
+		user.Status = types.UserEnabled
+		user.Role = req.Role
+		err = user.Insert()
+		if err != nil {
+			return nil, fmt.Errorf("db insert user failed, %v", err)
+		}
+
+		return &types.AddUserResponse{
+			ID: uint64(user.ID),
+		}, nil
+	})
+
+	Audited("/user/rename-user", func(ctx *Context, req *types.UserUpdateUserRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
+			return false, err
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if err := validUsername(req.Name); err != nil {
+			return false, err
+		}
+
+		user, err := model.FindUserByID(req.UserID)
+		if err != nil {
+			return false, fmt.Errorf("db find user failed, %v", err)
+		}
+
+		if user.Name == req.Name {
+			return true, nil
+		}
+
+		if user.Name == types.AdminUsername {
+			return false, errBuiltinAdmin
+		}
+
+		_, err = model.FindUserByName(req.Name)
+		if err == nil {
+			return false, errUsernameAlreadyExist
+		}
+
+		if err != gorm.ErrRecordNotFound {
+			return false, fmt.Errorf("db find user failed, %v", err)
+		}
+
+		err = user.UpdateName(req.Name)
+		if err != nil {
+			return false, fmt.Errorf("db update user name failed, %v", err)
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/delete-user", func(ctx *Context, req *types.UserUpdateUserRequest) (bool, error) {
+		caller, err := authorize(ctx, PermManageUser, 0)
+		if err != nil {
+			return false, err
+		}
+
+		if req.UserID == uint64(caller.ID) {
+			return false, fmt.Errorf("can't delete yourself")
+		}
+
+		user, err := model.FindUserByID(req.UserID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return true, nil
+			}
+
+			return false, fmt.Errorf("db find user failed, %v", err)
+		}
+
+		if user.Name == types.AdminUsername {
+			return false, errBuiltinAdmin
+		}
+
+		err = model.UpdateTasksCreator(req.UserID, user.Name)
+		if err != nil {
+			return false, fmt.Errorf("db update tasks creator failed, %v", err)
+		}
+
+		err = model.DeleteUserWorkers(req.UserID)
+		if err != nil {
+			return false, fmt.Errorf("db delete user workers failed, %v", err)
+		}
+
+		err = model.DeleteAccessTokensByUserID(req.UserID)
+		if err != nil {
+			return false, fmt.Errorf("db delete access tokens failed, %v", err)
+		}
+
+		err = model.DeleteSchedulesByUserID(req.UserID)
+		if err != nil {
+			return false, fmt.Errorf("db delete schedules failed, %v", err)
+		}
+
+		err = user.Delete()
+		if err != nil {
+			return false, fmt.Errorf("db delete user failed, %v", err)
+		}
+
+		err = server.S.UserLogoutAll(req.UserID)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/update-user-role", func(ctx *Context, req *types.UserUpdateUserRequest) (bool, error) {
+		user, err := authorize(ctx, PermManageUser, 0)
+		if err != nil {
+			return false, err
+		}
+
+		if !validRole(req.Role) {
+			return false, fmt.Errorf("invalid role %s, should be one of %v", req.Role, types.Roles)
+		}
+
+		if req.UserID == uint64(user.ID) {
+			return false, fmt.Errorf("can't update your own role")
+		}
+
+		u, err := model.FindUserByID(req.UserID)
+		if err != nil {
+			return false, fmt.Errorf("db find user failed, %v", err)
+		}
+
+		// The role of an external user follows its provider.
+		if u.External() {
+			return false, errExternalUser
+		}
+
+		if req.Role == u.Role {
+			return true, nil
+		}
+
+		err = u.UpdateRole(req.Role)
+		if err != nil {
+			return false, fmt.Errorf("db update user role failed, %v", err)
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/update-user-detail", func(ctx *Context, req *types.UserUpdateUserRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
 			return false, err
 		}
 
@@ -74,8 +275,37 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/reset-user-password", func(req *types.UserUpdateUserRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	Audited("/user/reset-user-password", func(ctx *Context, req *types.UserUpdateUserRequest) (*types.ResetUserPasswordResponse, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
+			return nil, err
+		}
+
+		user, err := model.FindUserByID(req.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("db find user failed, %v", err)
+		}
+
+		if user.External() {
+			return nil, errExternalUser
+		}
+
+		password, err := server.S.ResetPassword(user)
+		if err != nil {
+			return nil, err
+		}
+
+		err = server.S.UserLogoutAll(req.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.ResetUserPasswordResponse{
+			Password: password,
+		}, nil
+	})
+
+	Audited("/user/reset-user-totp", func(ctx *Context, req *types.UserUpdateUserRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
 			return false, err
 		}
 
@@ -84,21 +314,41 @@ func init() {
 			return false, fmt.Errorf("db find user failed, %v", err)
 		}
 
-		password := types.DefaultPassword
-		password = crypto.Sum([]byte(password)).String()
-		if password != user.Password {
-			err = user.UpdatePassword(password)
-			if err != nil {
-				return false, fmt.Errorf("db update user password failed, %v", err)
-			}
+		err = server.S.ResetTOTP(user)
+		if err != nil {
+			return false, err
 		}
 
-		server.S.UserLogout(req.UserID)
 		return true, nil
 	})
 
-	H("/user/enable-user", func(req *types.UserUpdateUserRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	H("/user/list-user-session", func(ctx *Context, req *types.ListSessionRequest) (types.ListSessionResponse, error) {
+		if _, err := authorize(ctx, PermViewUser, 0); err != nil {
+			return nil, err
+		}
+
+		if _, err := model.FindUserByID(req.UserID); err != nil {
+			return nil, fmt.Errorf("db find user failed, %v", err)
+		}
+
+		return listSession(req.UserID, ctx.Token)
+	})
+
+	Audited("/user/revoke-user-session", func(ctx *Context, req *types.RevokeSessionRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
+			return false, err
+		}
+
+		err := server.S.RevokeUserSession(req.UserID, req.SessionID)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/enable-user", func(ctx *Context, req *types.UserUpdateUserRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
 			return false, err
 		}
 
@@ -119,8 +369,8 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/disable-user", func(req *types.UserUpdateUserRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	Audited("/user/disable-user", func(ctx *Context, req *types.UserUpdateUserRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
 			return false, err
 		}
 
@@ -141,8 +391,51 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/add-worker", func(req *types.AddWorkerRequest) (*types.AddWorkerResponse, error) {
-		if err := validAdmin(req.Token); err != nil {
+	H("/user/list-login-lock", func(ctx *Context, req *types.ListLoginLockRequest) (types.ListLoginLockResponse, error) {
+		if _, err := authorize(ctx, PermViewUser, 0); err != nil {
+			return nil, err
+		}
+
+		locks, err := server.S.LoginLocks()
+		if err != nil {
+			return nil, err
+		}
+
+		resp := make([]*types.LoginLock, 0, len(locks))
+		for _, l := range locks {
+			lock := &types.LoginLock{
+				Kind:         l.Kind,
+				Target:       l.Target,
+				Failures:     l.Failures,
+				LastFailedAt: l.LastFailedAt.Unix(),
+			}
+			if l.LockedUntil != nil {
+				lock.LockedUntil = l.LockedUntil.Unix()
+			}
+			resp = append(resp, lock)
+		}
+		return resp, nil
+	})
+
+	Audited("/user/clear-login-lock", func(ctx *Context, req *types.ClearLoginLockRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageUser, 0); err != nil {
+			return false, err
+		}
+
+		if _, ok := types.LoginLockKindNames[req.Kind]; !ok {
+			return false, fmt.Errorf("invalid login lock kind %d", req.Kind)
+		}
+
+		err := server.S.ClearLoginLock(req.Kind, strings.TrimSpace(req.Target))
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/add-worker", func(ctx *Context, req *types.AddWorkerRequest) (*types.AddWorkerResponse, error) {
+		if _, err := authorize(ctx, PermManageWorker, 0); err != nil {
 			return nil, err
 		}
 
@@ -154,19 +447,12 @@ func init() {
 			return nil, err
 		}
 
-		if worker, err := model.FindWorkerByName(req.Name); err == nil {
-			if req.Desc != worker.Desc {
-				return nil, fmt.Errorf("worker %s already existed", req.Name)
-			}
-
-			return &types.AddWorkerResponse{
-				WorkerID:  uint64(worker.ID),
-				WorkerKey: worker.Key,
-			}, nil
+		if _, err := model.FindWorkerByName(req.Name); err == nil {
+			return nil, fmt.Errorf("worker %s already existed", req.Name)
 		}
 
-		key := server.GenToken()
-		worker := model.NewWorker(key, req.Name, req.Desc)
+		key := server.GenWorkerKey()
+		worker := model.NewWorker(server.HashWorkerKey(key), req.Name, req.Desc)
 		err = worker.Insert()
 		if err != nil {
 			return nil, fmt.Errorf("db insert worker failed, %v", err)
@@ -178,8 +464,68 @@ func init() {
 		}, nil
 	})
 
-	H("/user/enable-worker", func(req *types.UpdateWorkerRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	Audited("/user/rotate-worker-key", func(ctx *Context, req *types.RotateWorkerKeyRequest) (*types.RotateWorkerKeyResponse, error) {
+		if _, err := authorize(ctx, PermManageWorker, 0); err != nil {
+			return nil, err
+		}
+
+		grace := time.Duration(req.Grace) * time.Second
+		if grace < 0 || MaxWorkerKeyGrace < grace {
+			return nil, fmt.Errorf("invalid worker key grace period %s, should in [0, %s]",
+				grace, MaxWorkerKeyGrace)
+		}
+
+		key, err := server.S.RotateWorkerKey(req.WorkerID, grace)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.RotateWorkerKeyResponse{
+			WorkerID:  req.WorkerID,
+			WorkerKey: key,
+		}, nil
+	})
+
+	Audited("/user/create-enroll-token", func(ctx *Context, req *types.CreateEnrollTokenRequest) (*types.CreateEnrollTokenResponse, error) {
+		user, err := authorize(ctx, PermManageWorker, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		ttl := time.Duration(req.TTL) * time.Second
+		if ttl == 0 {
+			ttl = DefaultEnrollTokenTTL
+		}
+
+		if ttl < 0 || MaxEnrollTokenTTL < ttl {
+			return nil, fmt.Errorf("invalid enroll token ttl %s, should in (0, %s]",
+				ttl, MaxEnrollTokenTTL)
+		}
+
+		worker, err := model.FindWorkerByID(req.WorkerID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, errWorkerNotExist
+			}
+
+			return nil, fmt.Errorf("db find worker failed, %v", err)
+		}
+
+		enrollToken, token, err := server.S.CreateEnrollToken(uint64(worker.ID), uint64(user.ID), ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.CreateEnrollTokenResponse{
+			ID:          uint64(enrollToken.ID),
+			WorkerID:    uint64(worker.ID),
+			EnrollToken: token,
+			ExpiredAt:   enrollToken.ExpiredAt.Unix(),
+		}, nil
+	})
+
+	Audited("/user/enable-worker", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageWorker, 0); err != nil {
 			return false, err
 		}
 
@@ -200,8 +546,8 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/disable-worker", func(req *types.UpdateWorkerRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	Audited("/user/disable-worker", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageWorker, 0); err != nil {
 			return false, err
 		}
 
@@ -222,8 +568,8 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/add-worker-user", func(req *types.UpdateWorkerRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	Audited("/user/add-worker-user", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageWorker, 0); err != nil {
 			return false, err
 		}
 
@@ -237,8 +583,25 @@ func init() {
 			return false, fmt.Errorf("db find user failed, %v", err)
 		}
 
-		_, err = model.FindUserWorker(req.UserID, req.WorkerID)
+		if req.Level == 0 {
+			req.Level = types.GrantRun
+		}
+
+		if _, ok := types.GrantLevelNames[req.Level]; !ok {
+			return false, fmt.Errorf("invalid grant level %d", req.Level)
+		}
+
+		userWorker, err := model.FindUserWorker(req.UserID, req.WorkerID)
 		if err == nil {
+			if userWorker.Level == req.Level {
+				return true, nil
+			}
+
+			err = userWorker.UpdateLevel(req.Level)
+			if err != nil {
+				return false, fmt.Errorf("db update user worker level failed, %v", err)
+			}
+
 			return true, nil
 		}
 
@@ -246,7 +609,7 @@ func init() {
 			return false, fmt.Errorf("db find user worker failed, %v", err)
 		}
 
-		userWorker := model.NewUserWorker(req.UserID, req.WorkerID)
+		userWorker = model.NewUserWorker(req.UserID, req.WorkerID, req.Level)
 		err = userWorker.Insert()
 		if err != nil {
 			return false, fmt.Errorf("db insert user worker failed, %v", err)
@@ -255,8 +618,8 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/remove-worker-user", func(req *types.UpdateWorkerRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	Audited("/user/remove-worker-user", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageWorker, 0); err != nil {
 			return false, err
 		}
 
@@ -287,8 +650,8 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/remove-worker", func(req *types.UpdateWorkerRequest) (bool, error) {
-		if err := validAdmin(req.Token); err != nil {
+	Audited("/user/remove-worker", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermManageWorker, 0); err != nil {
 			return false, err
 		}
 
@@ -311,25 +674,15 @@ func init() {
 			return false, fmt.Errorf("db delete worker failed, %v", err)
 		}
 
-		server.S.RemoveWorker(req.WorkerID)
+		err = server.S.RemoveWorker(req.WorkerID)
+		if err != nil {
+			return false, err
+		}
 
 		return true, nil
 	})
 }
 
-func validAdmin(token string) error {
-	user, err := validUser(token)
-	if err != nil {
-		return err
-	}
-
-	if !user.IsAdmin() {
-		return errPermissionDenied
-	}
-
-	return nil
-}
-
 func validWorkerNameDesc(name, desc string) error {
 	if len(name) < MinWorkerNameLen || MaxWorkerNameLen < len(name) {
 		return fmt.Errorf("invalid worker name length %d, should in [%d, %d]",
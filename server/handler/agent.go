@@ -3,6 +3,7 @@ package handler
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/tidyoux/router/common/types"
 	"github.com/tidyoux/router/server"
@@ -10,12 +11,17 @@ import (
 )
 
 var (
-	ErrInvalidAgentToken = fmt.Errorf("invalid agent token")
+	ErrInvalidAgentToken = server.ErrInvalidAgentToken
+	ErrAgentReplaced     = server.ErrAgentReplaced
+	// ErrTaskLeased tells the agent that another agent holds the lease of the task.
+	ErrTaskLeased = fmt.Errorf("task is leased by another agent")
+
+	errTaskNotDue = fmt.Errorf("task is delayed and not due yet")
 )
 
 func init() {
-	H("/agent/login", func(req *types.AgentLoginRequest) (*types.AgentLoginResponse, error) {
-		token, err := server.S.AgentLogin(req.WorkerID, req.WorkerKey)
+	Public("/agent/login", func(ctx *Context, req *types.AgentLoginRequest) (*types.AgentLoginResponse, error) {
+		token, err := server.S.AgentLogin(req.WorkerID, req.WorkerKey, ctx.IP, ctx.UserAgent)
 		if err != nil {
 			return nil, err
 		}
@@ -23,30 +29,40 @@ func init() {
 		return &types.AgentLoginResponse{token}, nil
 	})
 
-	validAgent := func(token string) (uint64, error) {
-		workerID, ok := server.S.ValidAgentToken(token)
-		if !ok {
-			return 0, ErrInvalidAgentToken
+	Public("/agent/enroll", func(ctx *Context, req *types.AgentEnrollRequest) (*types.AgentEnrollResponse, error) {
+		workerID, key, err := server.S.Enroll(req.EnrollToken, ctx.IP)
+		if err != nil {
+			return nil, err
 		}
 
-		worker, err := model.FindWorkerByID(workerID)
+		return &types.AgentEnrollResponse{
+			WorkerID:  workerID,
+			WorkerKey: key,
+		}, nil
+	})
+
+	Auth("/agent/", func(ctx *Context) error {
+		worker, sessionID, err := validAgent(ctx.Token)
 		if err != nil {
-			return 0, fmt.Errorf("db find worker failed, %v", err)
+			return err
 		}
 
-		if err := validWorkerEnabled(worker.Status); err != nil {
-			return 0, err
-		}
+		ctx.Worker = worker
+		ctx.AgentSessionID = sessionID
+		return nil
+	})
 
-		return workerID, nil
-	}
-	H("/agent/list-task", func(req *types.AgentListTaskRequest) (*types.ListTaskResponse, error) {
-		workerID, err := validAgent(req.Token)
+	H("/agent/logout", func(ctx *Context, req *types.AgentLogoutRequest) (bool, error) {
+		err := server.S.AgentLogout(ctx.Token)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
 
-		tasks, err := model.FindAllUnfinishedTasksByWorkerID(workerID)
+		return true, nil
+	})
+
+	H("/agent/list-task", func(ctx *Context, req *types.AgentListTaskRequest) (*types.ListTaskResponse, error) {
+		tasks, err := model.FindAllUnfinishedTasksByWorkerID(uint64(ctx.Worker.ID), time.Now())
 		if err != nil {
 			return nil, fmt.Errorf("db find tasks failed, %v", err)
 		}
@@ -63,42 +79,90 @@ func init() {
 				Status:    t.Status,
 				Progress:  t.Progress,
 				CreatedAt: t.CreatedAt.Unix(),
+
+				CancelRequested: t.CancelRequested,
 			})
 		}
 
 		return resp, nil
 	})
 
-	H("/agent/accept-task", func(req *types.AcceptTaskRequest) (bool, error) {
-		if _, err := validAgent(req.Token); err != nil {
-			return false, err
+	H("/agent/claim-task", func(ctx *Context, req *types.ClaimTaskRequest) (*types.ClaimTaskResponse, error) {
+		task, err := server.S.ClaimTask(uint64(ctx.Worker.ID), ctx.AgentSessionID)
+		if err != nil {
+			return nil, err
+		}
+
+		if task == nil {
+			return &types.ClaimTaskResponse{}, nil
 		}
 
+		resp := &types.ClaimTaskResponse{
+			Task: &types.Task{
+				ID:        uint64(task.ID),
+				Params:    task.Params,
+				Status:    task.Status,
+				Progress:  task.Progress,
+				CreatedAt: task.CreatedAt.Unix(),
+
+				Attempt:     task.Attempt,
+				MaxAttempts: task.MaxAttempts,
+				LeaseOwner:  task.LeaseOwner,
+				Priority:    task.Priority,
+			},
+		}
+		if task.LeaseExpiredAt != nil {
+			resp.Task.LeaseExpiredAt = task.LeaseExpiredAt.Unix()
+		}
+
+		return resp, nil
+	})
+
+	H("/agent/accept-task", func(ctx *Context, req *types.AcceptTaskRequest) (bool, error) {
 		task, err := model.FindTaskByID(req.TaskID)
 		if err != nil {
 			return false, fmt.Errorf("db find task failed, %v", err)
 		}
 
+		if task.WorkerID != uint64(ctx.Worker.ID) {
+			return false, errWorkerOwner
+		}
+
 		switch task.Status {
 		case types.TaskAccepted:
+			// An agent resumes its task after restart by accepting it again.
+			ok, err := server.S.RenewTaskLease(task, ctx.AgentSessionID)
+			if err != nil {
+				return false, err
+			}
+
+			if !ok {
+				return false, ErrTaskLeased
+			}
+
 			return true, nil
-		case types.TaskFailed, types.TaskSuccess:
+		case types.TaskFailed, types.TaskCanceled, types.TaskSuccess:
 			return false, fmt.Errorf("can't accept a finished task")
 		default:
-			err = task.Accept()
+			if task.RunAt != nil && task.RunAt.After(time.Now()) {
+				return false, errTaskNotDue
+			}
+
+			ok, err := server.S.AcceptTask(task, ctx.AgentSessionID)
 			if err != nil {
-				return false, fmt.Errorf("db accept task failed, %v", err)
+				return false, err
+			}
+
+			if !ok {
+				// Another agent of the worker has accepted it, or it has been canceled.
+				return false, ErrTaskLeased
 			}
 
 			return true, nil
 		}
 	})
 
-	H("/agent/update-task", func(req *types.UpdateTaskRequest) (bool, error) {
-		if _, err := validAgent(req.Token); err != nil {
-			return false, err
-		}
-
+	H("/agent/update-task", func(ctx *Context, req *types.UpdateTaskRequest) (bool, error) {
 		req.Detail = strings.TrimSpace(req.Detail)
 
 		if len(req.Detail) > types.MaxTaskDetailLen {
@@ -111,12 +175,20 @@ func init() {
 			return false, fmt.Errorf("db find task failed, %v", err)
 		}
 
+		if task.WorkerID != uint64(ctx.Worker.ID) {
+			return false, errWorkerOwner
+		}
+
 		switch task.Status {
 		case types.TaskRecord:
 			return false, fmt.Errorf("task must accept first")
-		case types.TaskFailed, types.TaskSuccess:
+		case types.TaskFailed, types.TaskCanceled, types.TaskSuccess:
 			return false, fmt.Errorf("can't update a finished task")
 		default:
+			if !leaseHeld(ctx, task) {
+				return false, ErrTaskLeased
+			}
+
 			err = task.UpdateProgress(req.Progress, req.Detail)
 			if err != nil {
 				return false, fmt.Errorf("db update task progress failed, %v", err)
@@ -126,11 +198,52 @@ func init() {
 		}
 	})
 
-	H("/agent/finish-task", func(req *types.FinishTaskRequest) (bool, error) {
-		if _, err := validAgent(req.Token); err != nil {
-			return false, err
+	H("/agent/task-status", func(ctx *Context, req *types.TaskStatusRequest) (*types.TaskStatusResponse, error) {
+		task, err := model.FindTaskByID(req.TaskID)
+		if err != nil {
+			return nil, fmt.Errorf("db find task failed, %v", err)
 		}
 
+		if task.WorkerID != uint64(ctx.Worker.ID) {
+			return nil, errWorkerOwner
+		}
+
+		return taskStatusResponse(task), nil
+	})
+
+	H("/agent/renew-lease", func(ctx *Context, req *types.TaskStatusRequest) (*types.TaskStatusResponse, error) {
+		task, err := model.FindTaskByID(req.TaskID)
+		if err != nil {
+			return nil, fmt.Errorf("db find task failed, %v", err)
+		}
+
+		if task.WorkerID != uint64(ctx.Worker.ID) {
+			return nil, errWorkerOwner
+		}
+
+		if task.Status == types.TaskAccepted {
+			ok, err := server.S.RenewTaskLease(task, ctx.AgentSessionID)
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				// The task may have been finished after it was found.
+				task, err = model.FindTaskByID(req.TaskID)
+				if err != nil {
+					return nil, fmt.Errorf("db find task failed, %v", err)
+				}
+
+				if task.Status == types.TaskAccepted {
+					return nil, ErrTaskLeased
+				}
+			}
+		}
+
+		return taskStatusResponse(task), nil
+	})
+
+	H("/agent/finish-task", func(ctx *Context, req *types.FinishTaskRequest) (bool, error) {
 		req.Detail = strings.TrimSpace(req.Detail)
 
 		if len(req.Detail) > types.MaxTaskDetailLen {
@@ -143,32 +256,102 @@ func init() {
 			return false, fmt.Errorf("db find task failed, %v", err)
 		}
 
+		if task.WorkerID != uint64(ctx.Worker.ID) {
+			return false, errWorkerOwner
+		}
+
 		switch task.Status {
 		case types.TaskRecord:
 			return false, fmt.Errorf("task must accept first")
 		case types.TaskSuccess:
 			if !req.Success || req.Detail != task.Detail {
-				return false, fmt.Errorf("task has finished already")
+				return false, errTaskFinished
 			}
 
 			return true, nil
 		case types.TaskFailed:
-			if req.Success || req.Detail != task.Detail {
-				return false, fmt.Errorf("task has finished already")
+			if req.Success || req.Canceled || req.Detail != task.Detail {
+				return false, errTaskFinished
+			}
+
+			return true, nil
+		case types.TaskCanceled:
+			if !req.Canceled || req.Detail != task.Detail {
+				return false, errTaskFinished
 			}
 
 			return true, nil
 		default:
-			if req.Success {
-				err = task.Success(req.Detail)
-			} else {
-				err = task.Fail(req.Detail)
+			if !leaseHeld(ctx, task) {
+				return false, ErrTaskLeased
 			}
+
+			status := int8(types.TaskFailed)
+			if req.Canceled {
+				if !task.CancelRequested {
+					return false, fmt.Errorf("task is not asked to cancel")
+				}
+
+				status = types.TaskCanceled
+			} else if req.Success {
+				status = types.TaskSuccess
+			}
+
+			ok, err := server.S.FinishTask(task, status, req.Detail)
 			if err != nil {
-				return false, fmt.Errorf("db finish task failed, %v", err)
+				return false, err
+			}
+
+			if !ok {
+				return false, errTaskFinished
 			}
 
 			return true, nil
 		}
 	})
 }
+
+func validAgent(token string) (*model.Worker, uint64, error) {
+	session, err := server.S.ValidAgentSession(token)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	worker, err := model.FindWorkerByID(session.OwnerID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("db find worker failed, %v", err)
+	}
+
+	if err := validWorkerEnabled(worker.Status); err != nil {
+		return nil, 0, err
+	}
+
+	return worker, uint64(session.ID), nil
+}
+
+// leaseHeld tells if the agent of ctx may work on the accepted task, the tasks
+// accepted before leases have no lease owner.
+func leaseHeld(ctx *Context, task *model.Task) bool {
+	return task.LeaseOwner == 0 || task.LeaseOwner == ctx.AgentSessionID
+}
+
+func taskStatusResponse(task *model.Task) *types.TaskStatusResponse {
+	resp := &types.TaskStatusResponse{
+		Status:   task.Status,
+		Progress: task.Progress,
+
+		CancelRequested: task.CancelRequested,
+
+		Attempt:     task.Attempt,
+		MaxAttempts: task.MaxAttempts,
+		LeaseOwner:  task.LeaseOwner,
+		Priority:    task.Priority,
+	}
+	if task.LeaseExpiredAt != nil {
+		resp.LeaseExpiredAt = task.LeaseExpiredAt.Unix()
+	}
+	if task.RunAt != nil {
+		resp.RunAt = task.RunAt.Unix()
+	}
+	return resp
+}
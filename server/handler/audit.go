@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultAuditLimit = 20
+	MaxAuditLimit     = 100
+)
+
+var (
+	// auditTargetFields are the request fields naming the target of an action, by precedence.
+	auditTargetFields = []struct {
+		field string
+		kind  string
+	}{
+		{"WorkerID", "worker"},
+		{"TaskID", "task"},
+		{"ScheduleID", "schedule"},
+		{"PipelineID", "pipeline"},
+		{"PipelineRunID", "pipeline_run"},
+		{"UserID", "user"},
+		{"SessionID", "session"},
+		{"AccessTokenID", "access_token"},
+	}
+
+	// auditSecretFields are the request fields never written into the audit log.
+	auditSecretFields = []string{"token", "password", "code"}
+)
+
+func init() {
+	H("/user/list-audit", func(ctx *Context, req *types.ListAuditRequest) (*types.ListAuditResponse, error) {
+		if _, err := authorize(ctx, PermViewAudit, 0); err != nil {
+			return nil, err
+		}
+
+		if req.Limit <= 0 {
+			req.Limit = DefaultAuditLimit
+		}
+
+		if req.Limit > MaxAuditLimit {
+			req.Limit = MaxAuditLimit
+		}
+
+		filter := &model.AuditFilter{
+			ActorID: req.ActorID,
+			Action:  strings.TrimSpace(req.Action),
+			Target:  strings.TrimSpace(req.Target),
+		}
+		if req.Since > 0 {
+			filter.Since = time.Unix(req.Since, 0)
+		}
+		if req.Until > 0 {
+			filter.Until = time.Unix(req.Until, 0)
+		}
+
+		total, err := model.FindAuditEventCount(filter)
+		if err != nil {
+			return nil, fmt.Errorf("db find audit event count failed, %v", err)
+		}
+
+		events, err := model.FindAuditEvents(filter, req.Offset, req.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("db find audit events failed, %v", err)
+		}
+
+		resp := &types.ListAuditResponse{
+			Total:  total,
+			Events: make([]*types.AuditEvent, 0, len(events)),
+		}
+		for _, e := range events {
+			resp.Events = append(resp.Events, &types.AuditEvent{
+				ID:            uint64(e.ID),
+				ActorID:       e.ActorID,
+				Actor:         e.Actor,
+				AccessTokenID: e.AccessTokenID,
+				Action:        e.Action,
+				Target:        e.Target,
+				Summary:       e.Summary,
+				IP:            e.IP,
+				Error:         e.Error,
+				CreatedAt:     e.CreatedAt.Unix(),
+			})
+		}
+
+		return resp, nil
+	})
+}
+
+// audit records a call of an audited handler, it never fails the call.
+func audit(ctx *Context, handlerPath string, req reflect.Value, resp reflect.Value, err error) {
+	var (
+		actorID       uint64
+		actor         string
+		accessTokenID uint64
+		action        = path.Base(handlerPath)
+		errMsg        string
+	)
+	if ctx.User != nil {
+		actorID = uint64(ctx.User.ID)
+		actor = ctx.User.Name
+		accessTokenID = ctx.User.AccessTokenID
+	}
+
+	if err != nil {
+		errMsg = err.Error()
+	}
+
+	event := model.NewAuditEvent(actorID, actor, accessTokenID, action,
+		auditTarget(action, req, resp), auditSummary(req), ctx.IP, errMsg)
+	if err := event.Insert(); err != nil {
+		log.Errorf("db insert audit event %s by %s failed, %v", action, actor, err)
+	}
+}
+
+func auditTarget(action string, req reflect.Value, resp reflect.Value) string {
+	for _, v := range []reflect.Value{req, resp} {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				break
+			}
+			v = v.Elem()
+		}
+
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+
+		for _, t := range auditTargetFields {
+			f := v.FieldByName(t.field)
+			if f.IsValid() && f.Kind() == reflect.Uint64 && f.Uint() > 0 {
+				return fmt.Sprintf("%s:%d", t.kind, f.Uint())
+			}
+		}
+
+		// The created object of add-xxx or create-xxx is in the response ID.
+		f := v.FieldByName("ID")
+		if f.IsValid() && f.Kind() == reflect.Uint64 && f.Uint() > 0 {
+			kind := strings.TrimPrefix(strings.TrimPrefix(action, "add-"), "create-")
+			return fmt.Sprintf("%s:%d", strings.Replace(kind, "-", "_", -1), f.Uint())
+		}
+	}
+
+	return ""
+}
+
+func auditSummary(req reflect.Value) string {
+	buf, err := json.Marshal(req.Interface())
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		return ""
+	}
+
+	for _, k := range auditSecretFields {
+		delete(fields, k)
+	}
+
+	buf, err = json.Marshal(fields)
+	if err != nil {
+		return ""
+	}
+
+	return string(buf)
+}
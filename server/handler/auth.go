@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server"
+)
+
+func init() {
+	Public("/user/list-auth-provider", func(ctx *Context, req *types.ListAuthProviderRequest) (types.ListAuthProviderResponse, error) {
+		return server.S.AuthProviders(), nil
+	})
+
+	Public("/user/external-login", func(ctx *Context, req *types.ExternalLoginRequest) (*types.ExternalLoginResponse, error) {
+		url, err := server.S.StartExternalLogin(req.Provider)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.ExternalLoginResponse{
+			URL: url,
+		}, nil
+	})
+
+	Public("/user/external-login-callback", func(ctx *Context, req *types.ExternalLoginCallbackRequest) (*types.UserLoginResponse, error) {
+		userID, token, err := server.S.FinishExternalLogin(req.State, req.Code, ctx.IP, ctx.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.UserLoginResponse{
+			UserID: userID,
+			Token:  token,
+		}, nil
+	})
+}
@@ -4,12 +4,17 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 
+	"github.com/tidyoux/router/server/model"
 	"github.com/gin-gonic/gin"
 )
 
 var (
-	handlers = map[string]interface{}{}
+	handlers       = map[string]interface{}{}
+	publicHandlers = map[string]bool{}
+	auditHandlers  = map[string]bool{}
+	authenticators = map[string]func(ctx *Context) error{}
 )
 
 func H(path string, h interface{}) {
@@ -24,6 +29,80 @@ func H(path string, h interface{}) {
 	handlers[path] = h
 }
 
+// Public registers a handler which is called without authentication, like login.
+func Public(path string, h interface{}) {
+	H(path, h)
+	publicHandlers[path] = true
+}
+
+// Audited registers a mutating handler, every call of it is recorded in the audit log.
+func Audited(path string, h interface{}) {
+	H(path, h)
+	auditHandlers[path] = true
+}
+
+// Auth registers the authenticator of handlers whose path has prefix,
+// it resolves the caller of ctx.Token before the handler is called.
+func Auth(prefix string, a func(ctx *Context) error) {
+	authenticators[prefix] = a
+}
+
+func authenticator(path string) func(ctx *Context) error {
+	var (
+		a      func(ctx *Context) error
+		length int
+	)
+	for prefix, f := range authenticators {
+		if strings.HasPrefix(path, prefix) && len(prefix) > length {
+			a = f
+			length = len(prefix)
+		}
+	}
+	return a
+}
+
+// Context carries the request information which is not part of the request body.
+// A handler receives it when its first input is a *Context.
+type Context struct {
+	Path      string
+	IP        string
+	UserAgent string
+
+	// Token comes from the "Authorization: Bearer" header,
+	// or the deprecated token field of the request body.
+	Token string
+
+	// User is the caller of user handlers.
+	User *Caller
+	// Worker is the caller of agent handlers.
+	Worker *model.Worker
+	// AgentSessionID tells apart the agents of the same worker.
+	AgentSessionID uint64
+}
+
+func newContext(c *gin.Context, path string, req reflect.Value) *Context {
+	return &Context{
+		Path:      path,
+		IP:        c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Token:     requestToken(c, req),
+	}
+}
+
+func requestToken(c *gin.Context, req reflect.Value) string {
+	const bearer = "Bearer "
+	auth := c.GetHeader("Authorization")
+	if len(auth) > len(bearer) && strings.EqualFold(auth[:len(bearer)], bearer) {
+		return strings.TrimSpace(auth[len(bearer):])
+	}
+
+	if f := req.Elem().FieldByName("Token"); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+
+	return ""
+}
+
 type Response struct {
 	Error string      `json:"error"`
 	Data  interface{} `json:"data"`
@@ -51,14 +130,19 @@ func Init(group *gin.RouterGroup) error {
 		ft := fv.Type()
 
 		// Check input.
-		if ft.NumIn() != 1 {
-			return fmt.Errorf("invalid %s handler input count: %d, should be %d",
-				path, ft.NumIn(), 1)
+		if ft.NumIn() != 1 && ft.NumIn() != 2 {
+			return fmt.Errorf("invalid %s handler input count: %d, should be %d or %d",
+				path, ft.NumIn(), 1, 2)
+		}
+
+		if ft.NumIn() == 2 && ft.In(0) != reflect.TypeOf((*Context)(nil)) {
+			return fmt.Errorf("invalid %s handler first input type: %s, should be %s",
+				path, ft.In(0), reflect.TypeOf((*Context)(nil)))
 		}
 
-		if ft.In(0).Kind() != reflect.Ptr {
+		if ft.In(ft.NumIn()-1).Kind() != reflect.Ptr {
 			return fmt.Errorf("invalid %s handler input type: %s, should be %s",
-				path, ft.In(0).Kind(), reflect.Ptr)
+				path, ft.In(ft.NumIn()-1).Kind(), reflect.Ptr)
 		}
 
 		// Check output.
@@ -72,9 +156,19 @@ func Init(group *gin.RouterGroup) error {
 				path)
 		}
 
+		// Check authenticator.
+		var auth func(ctx *Context) error
+		if !publicHandlers[path] {
+			auth = authenticator(path)
+			if auth == nil {
+				return fmt.Errorf("no authenticator for %s handler", path)
+			}
+		}
+
 		// Register handler.
+		path := path
 		group.POST(path, func(c *gin.Context) {
-			data, err := handle(c, fv, ft)
+			data, err := handle(c, path, fv, ft, auth)
 			if err != nil {
 				c.JSON(http.StatusOK, NewFailResponse(err.Error()))
 				return
@@ -87,17 +181,39 @@ func Init(group *gin.RouterGroup) error {
 	return nil
 }
 
-func handle(c *gin.Context, fv reflect.Value, ft reflect.Type) (interface{}, error) {
-	reqT := ft.In(0).Elem()
+func handle(c *gin.Context, path string, fv reflect.Value, ft reflect.Type, auth func(ctx *Context) error) (interface{}, error) {
+	reqT := ft.In(ft.NumIn() - 1).Elem()
 	reqV := reflect.New(reqT)
 	req := reqV.Interface()
 	if err := c.ShouldBindJSON(&req); err != nil {
 		return nil, fmt.Errorf("bind args failed, %v", err)
 	}
 
-	rets := fv.Call([]reflect.Value{reqV})
+	ctx := newContext(c, path, reqV)
+	if auth != nil {
+		if err := auth(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	args := []reflect.Value{reqV}
+	if ft.NumIn() == 2 {
+		args = []reflect.Value{reflect.ValueOf(ctx), reqV}
+	}
+
+	rets := fv.Call(args)
+
+	var err error
 	if !rets[1].IsNil() {
-		return nil, rets[1].Interface().(error)
+		err = rets[1].Interface().(error)
+	}
+
+	if auditHandlers[path] {
+		audit(ctx, path, reqV, rets[0], err)
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
 	return rets[0].Interface(), nil
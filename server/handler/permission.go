@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+)
+
+type Permission int
+
+// Permissions.
+const (
+	// PermViewUser allows to list users and their sessions.
+	PermViewUser Permission = iota + 1
+	// PermManageUser allows to update, reset, enable, disable users and their roles.
+	PermManageUser
+	// PermManageWorker allows to add, enable, disable, remove workers and grant them to users.
+	PermManageWorker
+	// PermAllWorkers allows to access workers without being granted.
+	PermAllWorkers
+	// PermViewWorker allows to list workers and their tasks.
+	PermViewWorker
+	// PermEditWorker allows to update worker name and desc.
+	PermEditWorker
+	// PermSendTask allows to send tasks to workers.
+	PermSendTask
+	// PermViewAudit allows to list the audit log.
+	PermViewAudit
+)
+
+var (
+	errPermissionDenied = fmt.Errorf("permission denied")
+
+	rolePermissions = map[string][]Permission{
+		types.RoleAdmin: {
+			PermViewUser,
+			PermManageUser,
+			PermManageWorker,
+			PermAllWorkers,
+			PermViewWorker,
+			PermEditWorker,
+			PermSendTask,
+			PermViewAudit,
+		},
+		types.RoleAuditor: {
+			PermViewUser,
+			PermAllWorkers,
+			PermViewWorker,
+			PermViewAudit,
+		},
+		types.RoleOperator: {
+			PermViewWorker,
+			PermEditWorker,
+			PermSendTask,
+		},
+		types.RoleViewer: {
+			PermViewWorker,
+		},
+	}
+)
+
+// permissionGrantLevels is the minimum worker grant level required by worker permissions.
+var permissionGrantLevels = map[Permission]int8{
+	PermViewWorker: types.GrantView,
+	PermSendTask:   types.GrantRun,
+	PermEditWorker: types.GrantManage,
+}
+
+// Caller is the user resolved from a request token.
+type Caller struct {
+	*model.User
+
+	// AccessTokenID is not 0 if the caller comes with an access token.
+	AccessTokenID uint64
+	// WorkerIDs restricts the workers the caller can access if it is not empty.
+	WorkerIDs []uint64
+}
+
+func (c *Caller) AllowWorker(workerID uint64) bool {
+	if len(c.WorkerIDs) == 0 {
+		return true
+	}
+
+	for _, id := range c.WorkerIDs {
+		if id == workerID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func hasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorize returns the caller of ctx if the caller's role has perm.
+// If workerID is not 0, the caller must be granted the worker too,
+// unless the role has PermAllWorkers.
+func authorize(ctx *Context, perm Permission, workerID uint64) (*Caller, error) {
+	user := ctx.User
+	if !hasPermission(user.Role, perm) {
+		return nil, errPermissionDenied
+	}
+
+	if workerID == 0 {
+		return user, nil
+	}
+
+	if err := authorizeWorker(user, workerID, perm); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// authorizeWorker checks that user has been granted the worker with
+// a level high enough for perm, it is for the case that workerID is
+// unknown before authorize.
+func authorizeWorker(user *Caller, workerID uint64, perm Permission) error {
+	level, err := workerLevel(user, workerID)
+	if err != nil {
+		return err
+	}
+
+	if level == 0 {
+		return errWorkerOwner
+	}
+
+	if level < permissionGrantLevels[perm] {
+		return errPermissionDenied
+	}
+
+	return nil
+}
+
+// AuthorizeSendTask checks that the user can send tasks to the worker, the server
+// checks it before sending a task for a schedule or a pipeline run.
+func AuthorizeSendTask(user *model.User, workerID uint64) error {
+	if !hasPermission(user.Role, PermSendTask) {
+		return errPermissionDenied
+	}
+
+	return authorizeWorker(&Caller{User: user}, workerID, PermSendTask)
+}
+
+// workerLevel returns the effective grant level of user on the worker,
+// which is limited by both the user's role and grant, 0 if not granted.
+func workerLevel(user *Caller, workerID uint64) (int8, error) {
+	if !user.AllowWorker(workerID) {
+		return 0, nil
+	}
+
+	var level int8
+	for perm, l := range permissionGrantLevels {
+		if l > level && hasPermission(user.Role, perm) {
+			level = l
+		}
+	}
+
+	if hasPermission(user.Role, PermAllWorkers) {
+		return level, nil
+	}
+
+	userWorker, err := model.FindUserWorker(uint64(user.ID), workerID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("db find user worker failed, %v", err)
+	}
+
+	if userWorker.Level < level {
+		level = userWorker.Level
+	}
+
+	return level, nil
+}
@@ -0,0 +1,444 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	MaxPipelineNameLen = 64
+	MaxPipelineDescLen = 255
+	MaxPipelineCount   = 128
+
+	DefaultPipelineRunLimit = 10
+	MaxPipelineRunLimit     = 50
+)
+
+var (
+	errPipelineNotExist      = fmt.Errorf("pipeline not exist")
+	errPipelineRunNotExist   = fmt.Errorf("pipeline run not exist")
+	errPipelineRunFinished   = fmt.Errorf("pipeline run has finished already")
+	errPipelineRunning       = fmt.Errorf("pipeline has running runs, cancel them first")
+	errPipelineNameDuplicate = fmt.Errorf("pipeline name exists already")
+)
+
+func init() {
+	H("/user/list-pipeline", func(ctx *Context, req *types.ListPipelineRequest) (types.ListPipelineResponse, error) {
+		user, err := authorize(ctx, PermViewWorker, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		pipelines, err := model.FindAllPipelines()
+		if err != nil {
+			return nil, fmt.Errorf("db find pipelines failed, %v", err)
+		}
+
+		resp := make([]*types.Pipeline, 0, len(pipelines))
+		for _, p := range pipelines {
+			nodes, err := pipelineNodes(p)
+			if err != nil {
+				return nil, err
+			}
+
+			// A pipeline is listed to those who can view all of its workers.
+			level := int8(types.GrantManage)
+			for _, node := range nodes {
+				l, err := workerLevel(user, node.WorkerID)
+				if err != nil {
+					return nil, err
+				}
+
+				if l < level {
+					level = l
+				}
+			}
+
+			if level < types.GrantView {
+				continue
+			}
+
+			owner := p.Creator
+			if u, err := model.FindUserByID(p.UserID); err == nil {
+				owner = u.Name
+			}
+
+			resp = append(resp, &types.Pipeline{
+				ID:            uint64(p.ID),
+				Name:          p.Name,
+				Desc:          p.Desc,
+				Nodes:         nodes,
+				FailurePolicy: p.FailurePolicy,
+				OwnerID:       p.UserID,
+				Owner:         owner,
+				CanRun:        hasPermission(user.Role, PermSendTask) && level >= types.GrantRun,
+				CreatedAt:     p.CreatedAt.Unix(),
+				UpdatedAt:     p.UpdatedAt.Unix(),
+			})
+		}
+
+		return resp, nil
+	})
+
+	Audited("/user/add-pipeline", func(ctx *Context, req *types.PipelineRequest) (*types.AddPipelineResponse, error) {
+		user, err := authorize(ctx, PermSendTask, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		pipelines, err := model.FindAllPipelines()
+		if err != nil {
+			return nil, fmt.Errorf("db find pipelines failed, %v", err)
+		}
+
+		if len(pipelines) >= MaxPipelineCount {
+			return nil, fmt.Errorf("too many pipelines, should <= %d", MaxPipelineCount)
+		}
+
+		pipeline := model.NewPipeline(uint64(user.ID), user.Name)
+		err = setPipeline(pipeline, user, req)
+		if err != nil {
+			return nil, err
+		}
+
+		err = pipeline.Insert()
+		if err != nil {
+			return nil, fmt.Errorf("db insert pipeline failed, %v", err)
+		}
+
+		return &types.AddPipelineResponse{
+			PipelineID: uint64(pipeline.ID),
+		}, nil
+	})
+
+	Audited("/user/update-pipeline", func(ctx *Context, req *types.PipelineRequest) (bool, error) {
+		user, pipeline, _, err := authorizePipeline(ctx, req.PipelineID, PermSendTask)
+		if err != nil {
+			return false, err
+		}
+
+		// The tasks are sent as the runner, the owner only records who last saved it.
+		pipeline.UserID = uint64(user.ID)
+		pipeline.Creator = user.Name
+		err = setPipeline(pipeline, user, req)
+		if err != nil {
+			return false, err
+		}
+
+		err = pipeline.Save()
+		if err != nil {
+			return false, fmt.Errorf("db update pipeline failed, %v", err)
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/remove-pipeline", func(ctx *Context, req *types.PipelineRequest) (bool, error) {
+		_, pipeline, _, err := authorizePipeline(ctx, req.PipelineID, PermSendTask)
+		if err != nil {
+			if err == errPipelineNotExist {
+				return true, nil
+			}
+
+			return false, err
+		}
+
+		count, err := model.FindRunningPipelineRunCountByPipelineID(uint64(pipeline.ID))
+		if err != nil {
+			return false, fmt.Errorf("db find running pipeline run count failed, %v", err)
+		}
+
+		if count > 0 {
+			return false, errPipelineRunning
+		}
+
+		err = model.DeletePipelineRunsByPipelineID(uint64(pipeline.ID))
+		if err != nil {
+			return false, fmt.Errorf("db delete pipeline runs failed, %v", err)
+		}
+
+		err = pipeline.Delete()
+		if err != nil {
+			return false, fmt.Errorf("db delete pipeline failed, %v", err)
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/run-pipeline", func(ctx *Context, req *types.RunPipelineRequest) (*types.RunPipelineResponse, error) {
+		user, pipeline, nodes, err := authorizePipeline(ctx, req.PipelineID, PermSendTask)
+		if err != nil {
+			return nil, err
+		}
+
+		run, err := server.S.StartPipelineRun(pipeline, nodes, user.User)
+		if err != nil {
+			return nil, err
+		}
+
+		// The first tasks are sent at once, the pipeliner sends the others.
+		err = server.S.AdvancePipelineRun(run, AuthorizeSendTask)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.RunPipelineResponse{
+			PipelineRunID: uint64(run.ID),
+		}, nil
+	})
+
+	H("/user/list-pipeline-run", func(ctx *Context, req *types.ListPipelineRunRequest) (*types.ListPipelineRunResponse, error) {
+		_, pipeline, _, err := authorizePipeline(ctx, req.PipelineID, PermViewWorker)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.Limit <= 0 {
+			req.Limit = DefaultPipelineRunLimit
+		}
+
+		if req.Limit > MaxPipelineRunLimit {
+			req.Limit = MaxPipelineRunLimit
+		}
+
+		total, err := model.FindPipelineRunCountByPipelineID(uint64(pipeline.ID))
+		if err != nil {
+			return nil, fmt.Errorf("db find pipeline run count failed, %v", err)
+		}
+
+		runs, err := model.FindPipelineRunsByPipelineID(uint64(pipeline.ID), req.Offset, req.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("db find pipeline runs failed, %v", err)
+		}
+
+		resp := &types.ListPipelineRunResponse{
+			Total: total,
+			Runs:  make([]*types.PipelineRun, 0, len(runs)),
+		}
+		for _, r := range runs {
+			runner := r.Creator
+			if user, err := model.FindUserByID(r.UserID); err == nil {
+				runner = user.Name
+			}
+
+			nodes, err := model.FindPipelineRunNodes(uint64(r.ID))
+			if err != nil {
+				return nil, fmt.Errorf("db find pipeline run nodes failed, %v", err)
+			}
+
+			run := &types.PipelineRun{
+				ID:            uint64(r.ID),
+				PipelineID:    r.PipelineID,
+				Name:          r.Name,
+				Runner:        runner,
+				Status:        r.Status,
+				FailurePolicy: r.FailurePolicy,
+				Nodes:         make([]*types.PipelineRunNode, 0, len(nodes)),
+				CreatedAt:     r.CreatedAt.Unix(),
+
+				CancelRequested: r.CancelRequested,
+			}
+			if r.FinishedAt != nil {
+				run.FinishedAt = r.FinishedAt.Unix()
+			}
+
+			for _, n := range nodes {
+				node := &types.PipelineRunNode{
+					Name:      n.Name,
+					WorkerID:  n.WorkerID,
+					Params:    n.Params,
+					Status:    n.Status,
+					TaskID:    n.TaskID,
+					Detail:    n.Detail,
+					UpdatedAt: n.UpdatedAt.Unix(),
+				}
+				if len(n.Needs) > 0 {
+					node.Needs = strings.Split(n.Needs, ",")
+				}
+				run.Nodes = append(run.Nodes, node)
+			}
+
+			resp.Runs = append(resp.Runs, run)
+		}
+
+		return resp, nil
+	})
+
+	Audited("/user/cancel-pipeline-run", func(ctx *Context, req *types.CancelPipelineRunRequest) (bool, error) {
+		user, err := authorize(ctx, PermSendTask, 0)
+		if err != nil {
+			return false, err
+		}
+
+		run, err := model.FindPipelineRunByID(req.PipelineRunID)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return false, errPipelineRunNotExist
+			}
+
+			return false, fmt.Errorf("db find pipeline run failed, %v", err)
+		}
+
+		nodes, err := model.FindPipelineRunNodes(uint64(run.ID))
+		if err != nil {
+			return false, fmt.Errorf("db find pipeline run nodes failed, %v", err)
+		}
+
+		for _, node := range nodes {
+			if err := authorizeWorker(user, node.WorkerID, PermSendTask); err != nil {
+				return false, err
+			}
+		}
+
+		ok, err := run.RequestCancel()
+		if err != nil {
+			return false, fmt.Errorf("db request pipeline run cancel failed, %v", err)
+		}
+
+		if !ok {
+			return false, errPipelineRunFinished
+		}
+
+		// The pending nodes are skipped and the running tasks canceled at once.
+		run.CancelRequested = true
+		err = server.S.AdvancePipelineRun(run, AuthorizeSendTask)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	})
+}
+
+// authorizePipeline returns the caller of ctx, the pipeline and its nodes if
+// the caller has perm on all workers of the pipeline.
+func authorizePipeline(ctx *Context, pipelineID uint64, perm Permission) (*Caller, *model.Pipeline, []*types.PipelineNode, error) {
+	user, err := authorize(ctx, perm, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pipeline, err := model.FindPipelineByID(pipelineID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, nil, errPipelineNotExist
+		}
+
+		return nil, nil, nil, fmt.Errorf("db find pipeline failed, %v", err)
+	}
+
+	nodes, err := pipelineNodes(pipeline)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, node := range nodes {
+		if err := authorizeWorker(user, node.WorkerID, perm); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return user, pipeline, nodes, nil
+}
+
+func pipelineNodes(pipeline *model.Pipeline) ([]*types.PipelineNode, error) {
+	var nodes []*types.PipelineNode
+	err := json.Unmarshal([]byte(pipeline.Nodes), &nodes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nodes of pipeline %d, %v", pipeline.ID, err)
+	}
+
+	return nodes, nil
+}
+
+// setPipeline validates req and sets it to pipeline, the nodes are kept in the
+// order they can be sent.
+func setPipeline(pipeline *model.Pipeline, user *Caller, req *types.PipelineRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Desc = strings.TrimSpace(req.Desc)
+
+	if len(req.Name) == 0 || len(req.Name) > MaxPipelineNameLen {
+		return fmt.Errorf("invalid pipeline name length: %d, should be in [1, %d]",
+			len(req.Name), MaxPipelineNameLen)
+	}
+
+	if len(req.Desc) > MaxPipelineDescLen {
+		return fmt.Errorf("invalid pipeline desc length: %d, should <= %d",
+			len(req.Desc), MaxPipelineDescLen)
+	}
+
+	if _, ok := types.PipelineFailurePolicyNames[req.FailurePolicy]; !ok {
+		return fmt.Errorf("invalid pipeline failure policy: %d", req.FailurePolicy)
+	}
+
+	if p, err := model.FindPipelineByName(req.Name); err == nil {
+		if p.ID != pipeline.ID {
+			return errPipelineNameDuplicate
+		}
+	} else if err != gorm.ErrRecordNotFound {
+		return fmt.Errorf("db find pipeline failed, %v", err)
+	}
+
+	maxPriority := server.S.MaxTaskPriority(user.Role)
+	for _, node := range req.Nodes {
+		if node == nil {
+			return fmt.Errorf("invalid pipeline node: null")
+		}
+
+		node.Name = strings.TrimSpace(node.Name)
+		node.Params = strings.TrimSpace(node.Params)
+		for i := range node.Needs {
+			node.Needs[i] = strings.TrimSpace(node.Needs[i])
+		}
+
+		if len(node.Params) > MaxTaskParamsLen {
+			return fmt.Errorf("invalid task params length of node %q: %d, should <= %d",
+				node.Name, len(node.Params), MaxTaskParamsLen)
+		}
+
+		if node.Retries < 0 || node.Retries > types.MaxTaskRetries {
+			return fmt.Errorf("invalid task retries of node %q: %d, should be in [0, %d]",
+				node.Name, node.Retries, types.MaxTaskRetries)
+		}
+
+		if node.Priority < 0 || node.Priority > maxPriority {
+			return fmt.Errorf("invalid task priority of node %q: %d, should be in [0, %d] for role %s",
+				node.Name, node.Priority, maxPriority, user.Role)
+		}
+
+		if _, err := model.FindWorkerByID(node.WorkerID); err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return fmt.Errorf("worker %d of node %q not exist", node.WorkerID, node.Name)
+			}
+
+			return fmt.Errorf("db find worker failed, %v", err)
+		}
+
+		if err := authorizeWorker(user, node.WorkerID, PermSendTask); err != nil {
+			return fmt.Errorf("worker %d of node %q: %v", node.WorkerID, node.Name, err)
+		}
+	}
+
+	nodes, err := server.SortPipelineNodes(req.Nodes)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return fmt.Errorf("encode pipeline nodes failed, %v", err)
+	}
+
+	pipeline.Name = req.Name
+	pipeline.Desc = req.Desc
+	pipeline.Nodes = string(data)
+	pipeline.FailurePolicy = req.FailurePolicy
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	MaxScheduleNameLen = 64
+	MaxScheduleCount   = 64
+)
+
+var errScheduleNotExist = fmt.Errorf("schedule not exist")
+
+func init() {
+	H("/user/list-schedule", func(ctx *Context, req *types.ListScheduleRequest) (types.ListScheduleResponse, error) {
+		if _, err := authorize(ctx, PermViewWorker, req.WorkerID); err != nil {
+			return nil, err
+		}
+
+		schedules, err := model.FindSchedulesByWorkerID(req.WorkerID)
+		if err != nil {
+			return nil, fmt.Errorf("db find schedules failed, %v", err)
+		}
+
+		resp := make([]*types.Schedule, 0, len(schedules))
+		for _, s := range schedules {
+			owner := s.Creator
+			if user, err := model.FindUserByID(s.UserID); err == nil {
+				owner = user.Name
+			}
+
+			schedule := &types.Schedule{
+				ID:         uint64(s.ID),
+				WorkerID:   s.WorkerID,
+				Name:       s.Name,
+				Params:     s.Params,
+				Cron:       s.Cron,
+				TimeZone:   s.TimeZone,
+				Enabled:    s.Enabled,
+				Retries:    s.Retries,
+				Priority:   s.Priority,
+				OwnerID:    s.UserID,
+				Owner:      owner,
+				LastTaskID: s.LastTaskID,
+				LastError:  s.LastError,
+				CreatedAt:  s.CreatedAt.Unix(),
+				UpdatedAt:  s.UpdatedAt.Unix(),
+			}
+			if s.NextRunAt != nil {
+				schedule.NextRunAt = s.NextRunAt.Unix()
+			}
+			if s.LastRunAt != nil {
+				schedule.LastRunAt = s.LastRunAt.Unix()
+			}
+
+			resp = append(resp, schedule)
+		}
+
+		return resp, nil
+	})
+
+	Audited("/user/add-schedule", func(ctx *Context, req *types.ScheduleRequest) (*types.AddScheduleResponse, error) {
+		user, err := authorize(ctx, PermSendTask, req.WorkerID)
+		if err != nil {
+			return nil, err
+		}
+
+		worker, err := model.FindWorkerByID(req.WorkerID)
+		if err != nil {
+			return nil, fmt.Errorf("db find worker failed, %v", err)
+		}
+
+		if err := validWorkerEnabled(worker.Status); err != nil {
+			return nil, err
+		}
+
+		schedules, err := model.FindSchedulesByWorkerID(req.WorkerID)
+		if err != nil {
+			return nil, fmt.Errorf("db find schedules failed, %v", err)
+		}
+
+		if len(schedules) >= MaxScheduleCount {
+			return nil, fmt.Errorf("too many schedules of the worker, should <= %d", MaxScheduleCount)
+		}
+
+		schedule := model.NewSchedule(req.WorkerID, uint64(user.ID), user.Name)
+		err = setSchedule(schedule, user, req)
+		if err != nil {
+			return nil, err
+		}
+
+		err = schedule.Insert()
+		if err != nil {
+			return nil, fmt.Errorf("db insert schedule failed, %v", err)
+		}
+
+		return &types.AddScheduleResponse{
+			ScheduleID: uint64(schedule.ID),
+		}, nil
+	})
+
+	Audited("/user/update-schedule", func(ctx *Context, req *types.ScheduleRequest) (bool, error) {
+		user, schedule, err := authorizeSchedule(ctx, req.ScheduleID)
+		if err != nil {
+			return false, err
+		}
+
+		// The tasks are sent as the one who last saved the schedule,
+		// so no one can send tasks with the permissions of another.
+		schedule.UserID = uint64(user.ID)
+		schedule.Creator = user.Name
+		err = setSchedule(schedule, user, req)
+		if err != nil {
+			return false, err
+		}
+
+		err = schedule.Save()
+		if err != nil {
+			return false, fmt.Errorf("db update schedule failed, %v", err)
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/remove-schedule", func(ctx *Context, req *types.ScheduleRequest) (bool, error) {
+		_, schedule, err := authorizeSchedule(ctx, req.ScheduleID)
+		if err != nil {
+			if err == errScheduleNotExist {
+				return true, nil
+			}
+
+			return false, err
+		}
+
+		err = schedule.Delete()
+		if err != nil {
+			return false, fmt.Errorf("db delete schedule failed, %v", err)
+		}
+
+		return true, nil
+	})
+}
+
+// authorizeSchedule returns the caller of ctx and the schedule if the caller
+// can send tasks to the worker of the schedule.
+func authorizeSchedule(ctx *Context, scheduleID uint64) (*Caller, *model.Schedule, error) {
+	user, err := authorize(ctx, PermSendTask, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	schedule, err := model.FindScheduleByID(scheduleID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, errScheduleNotExist
+		}
+
+		return nil, nil, fmt.Errorf("db find schedule failed, %v", err)
+	}
+
+	if err := authorizeWorker(user, schedule.WorkerID, PermSendTask); err != nil {
+		return nil, nil, err
+	}
+
+	return user, schedule, nil
+}
+
+// setSchedule validates req and sets it to schedule, the next run is counted from now.
+func setSchedule(schedule *model.Schedule, user *Caller, req *types.ScheduleRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Params = strings.TrimSpace(req.Params)
+	req.Cron = strings.TrimSpace(req.Cron)
+	req.TimeZone = strings.TrimSpace(req.TimeZone)
+
+	if len(req.Name) > MaxScheduleNameLen {
+		return fmt.Errorf("invalid schedule name length: %d, should <= %d",
+			len(req.Name), MaxScheduleNameLen)
+	}
+
+	if len(req.Params) > MaxTaskParamsLen {
+		return fmt.Errorf("invalid task params length: %d, should <= %d",
+			len(req.Params), MaxTaskParamsLen)
+	}
+
+	if req.Retries < 0 || req.Retries > types.MaxTaskRetries {
+		return fmt.Errorf("invalid task retries: %d, should be in [0, %d]",
+			req.Retries, types.MaxTaskRetries)
+	}
+
+	if maxPriority := server.S.MaxTaskPriority(user.Role); req.Priority < 0 || req.Priority > maxPriority {
+		return fmt.Errorf("invalid task priority: %d, should be in [0, %d] for role %s",
+			req.Priority, maxPriority, user.Role)
+	}
+
+	next, err := server.NextScheduleRun(req.Cron, req.TimeZone, time.Now())
+	if err != nil {
+		return err
+	}
+
+	schedule.Name = req.Name
+	schedule.Params = req.Params
+	schedule.Cron = req.Cron
+	schedule.TimeZone = req.TimeZone
+	schedule.Enabled = req.Enabled
+	schedule.Retries = req.Retries
+	schedule.Priority = req.Priority
+	schedule.NextRunAt = nil
+	if req.Enabled {
+		schedule.NextRunAt = &next
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server"
+)
+
+var (
+	errIncorrectPassword = fmt.Errorf("incorrect password")
+
+	// totpSetupPaths are the handlers a user can call before enrolling the required 2FA.
+	totpSetupPaths = map[string]bool{
+		"/user/info":        true,
+		"/user/ping":        true,
+		"/user/logout":      true,
+		"/user/setup-totp":  true,
+		"/user/enable-totp": true,
+
+		"/user/update-password": true,
+	}
+
+	// passwordChangePaths are the handlers a user can call before changing the reset password.
+	passwordChangePaths = map[string]bool{
+		"/user/info":            true,
+		"/user/ping":            true,
+		"/user/logout":          true,
+		"/user/update-password": true,
+	}
+)
+
+func init() {
+	Public("/user/login-2fa", func(ctx *Context, req *types.UserLogin2FARequest) (*types.UserLoginResponse, error) {
+		userID, token, err := server.S.UserLogin2FA(req.TwoFactorToken, req.Code, ctx.IP, ctx.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.UserLoginResponse{
+			UserID: userID,
+			Token:  token,
+		}, nil
+	})
+
+	Audited("/user/setup-totp", func(ctx *Context, req *types.SetupTOTPRequest) (*types.SetupTOTPResponse, error) {
+		user, err := validLoginUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		secret, uri, err := server.S.SetupTOTP(user.User)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.SetupTOTPResponse{
+			Secret: secret,
+			URI:    uri,
+		}, nil
+	})
+
+	Audited("/user/enable-totp", func(ctx *Context, req *types.EnableTOTPRequest) (*types.RecoveryCodesResponse, error) {
+		user, err := validLoginUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		codes, err := server.S.EnableTOTP(user.User, req.Code)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.RecoveryCodesResponse{
+			RecoveryCodes: codes,
+		}, nil
+	})
+
+	Audited("/user/disable-totp", func(ctx *Context, req *types.DisableTOTPRequest) (bool, error) {
+		user, err := validLoginUser(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if ok, _ := crypto.VerifyPassword(user.Password, req.Password); !ok {
+			return false, errIncorrectPassword
+		}
+
+		err = server.S.DisableTOTP(user.User)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/regenerate-recovery-codes", func(ctx *Context, req *types.RegenerateRecoveryCodesRequest) (*types.RecoveryCodesResponse, error) {
+		user, err := validLoginUser(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		codes, err := server.S.RegenerateRecoveryCodes(user.User, req.Code)
+		if err != nil {
+			return nil, err
+		}
+
+		return &types.RecoveryCodesResponse{
+			RecoveryCodes: codes,
+		}, nil
+	})
+}
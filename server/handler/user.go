@@ -3,8 +3,8 @@ package handler
 import (
 	"fmt"
 	"strings"
+	"time"
 
-	"github.com/tidyoux/router/common/crypto"
 	"github.com/tidyoux/router/common/types"
 	"github.com/tidyoux/router/server"
 	"github.com/tidyoux/router/server/model"
@@ -12,105 +12,151 @@ import (
 )
 
 const (
-	MinUsernameLen     = 1
-	MaxUsernameLen     = 32
-	MinUserPasswordLen = 6
+	MinUsernameLen = 1
+	MaxUsernameLen = 32
 
 	MaxTaskParamsLen = 1024
 )
 
 var (
-	errInvalidUserToken = fmt.Errorf("invalid user token")
-	errUserDisabled     = fmt.Errorf("user disabled")
-
+	errInvalidUserToken       = fmt.Errorf("invalid user token")
+	errAccessTokenNotAllowed  = fmt.Errorf("access token is not allowed, please login")
+	errUserDisabled           = fmt.Errorf("user disabled")
+	errTOTPSetupRequired      = fmt.Errorf("2fa is required for your role, please set it up first")
+	errPasswordChangeRequired = fmt.Errorf("your password has been reset, please change it first")
+	errExternalUser           = fmt.Errorf("user is managed by an external auth provider")
+
+	errWorkerNotExist = fmt.Errorf("worker not exist")
 	errWorkerDisabled = fmt.Errorf("worker disabled")
 	errWorkerOwner    = fmt.Errorf("you don't own this worker")
+
+	errTaskFinished = fmt.Errorf("task has finished already")
 )
 
 func init() {
-	validUsernamePassword := func(name, password string) error {
-		if len(name) < MinUsernameLen || MaxUsernameLen < len(name) {
-			return fmt.Errorf("invalid user name length %d, should in [%d, %d]",
-				len(name), MinUsernameLen, MaxUsernameLen)
+	Auth("/user/", func(ctx *Context) error {
+		user, err := validUser(ctx.Token)
+		if err != nil {
+			return err
 		}
 
-		if len(password) < MinUserPasswordLen {
-			return fmt.Errorf("invalid user password length: %d, should >= %d",
-				len(password), MinUserPasswordLen)
+		if user.MustChangePassword && !passwordChangePaths[ctx.Path] {
+			return errPasswordChangeRequired
 		}
 
+		if !user.TOTPEnabled && server.S.TOTPRequired(user.User) && !totpSetupPaths[ctx.Path] {
+			return errTOTPSetupRequired
+		}
+
+		ctx.User = user
 		return nil
-	}
+	})
 
-	H("/user/login", func(req *types.UserLoginRequest) (*types.UserLoginResponse, error) {
+	Public("/user/login", func(ctx *Context, req *types.UserLoginRequest) (*types.UserLoginResponse, error) {
 		req.Username = strings.TrimSpace(req.Username)
 		req.Password = strings.TrimSpace(req.Password)
 
-		err := validUsernamePassword(req.Username, req.Password)
+		err := validUsername(req.Username)
 		if err != nil {
 			return nil, err
 		}
 
-		userID, token, err := server.S.UserLogin(req.Username, req.Password)
+		if len(req.Password) == 0 {
+			return nil, errIncorrectPassword
+		}
+
+		userID, token, twoFactorToken, err := server.S.UserLogin(req.Username, req.Password, ctx.IP, ctx.UserAgent)
 		if err != nil {
 			return nil, err
 		}
 
 		return &types.UserLoginResponse{
-			UserID: userID,
-			Token:  token,
+			UserID:         userID,
+			Token:          token,
+			TwoFactorToken: twoFactorToken,
 		}, nil
 	})
 
-	H("/user/logout", func(req *types.UserLogoutRequest) (bool, error) {
-		user, err := validUser(req.Token)
+	Audited("/user/logout", func(ctx *Context, req *types.UserLogoutRequest) (bool, error) {
+		err := server.S.UserLogout(ctx.Token)
 		if err != nil {
 			return false, err
 		}
 
-		server.S.UserLogout(uint64(user.ID))
 		return true, nil
 	})
 
-	H("/user/ping", func(req *types.UserPingRequest) (bool, error) {
-		if _, err := validUser(req.Token); err != nil {
-			return false, err
-		}
-
+	H("/user/ping", func(ctx *Context, req *types.UserPingRequest) (bool, error) {
 		return true, nil
 	})
 
-	H("/user/update-password", func(req *types.UserUpdatePasswordRequest) (bool, error) {
-		user, err := validUser(req.Token)
+	H("/user/info", func(ctx *Context, req *types.UserPingRequest) (*types.User, error) {
+		user := ctx.User
+		return &types.User{
+			ID:                 uint64(user.ID),
+			Name:               user.Name,
+			Detail:             user.Detail,
+			Status:             user.Status,
+			Role:               user.Role,
+			Provider:           user.Provider,
+			MustChangePassword: user.MustChangePassword,
+			TOTPEnabled:        user.TOTPEnabled,
+			TOTPRequired:       server.S.TOTPRequired(user.User),
+			RecoveryCodes:      len(user.RecoveryCodeHashes()),
+			MaxTaskPriority:    server.S.MaxTaskPriority(user.Role),
+		}, nil
+	})
+
+	Audited("/user/update-password", func(ctx *Context, req *types.UserUpdatePasswordRequest) (bool, error) {
+		user := ctx.User
+
+		if user.AccessTokenID > 0 {
+			return false, errAccessTokenNotAllowed
+		}
+
+		if user.External() {
+			return false, errExternalUser
+		}
+
+		err := validUsernamePassword(user.Name, req.Password)
 		if err != nil {
 			return false, err
 		}
 
-		err = validUsernamePassword(user.Name, req.Password)
+		err = server.S.ChangePassword(user.User, req.Password)
 		if err != nil {
 			return false, err
 		}
 
-		password := crypto.Sum([]byte(req.Password)).String()
-		if password != user.Password {
-			err = user.UpdatePassword(password)
-			if err != nil {
-				return false, fmt.Errorf("db update user password failed, %v", err)
-			}
+		err = server.S.UserLogoutAll(uint64(user.ID))
+		if err != nil {
+			return false, err
 		}
 
-		server.S.UserLogout(uint64(user.ID))
 		return true, nil
 	})
 
-	H("/user/list-worker", func(req *types.ListWorkerRequest) (types.ListWorkerResponse, error) {
-		user, err := validUser(req.Token)
+	H("/user/list-session", func(ctx *Context, req *types.ListSessionRequest) (types.ListSessionResponse, error) {
+		return listSession(uint64(ctx.User.ID), ctx.Token)
+	})
+
+	Audited("/user/revoke-session", func(ctx *Context, req *types.RevokeSessionRequest) (bool, error) {
+		err := server.S.RevokeUserSession(uint64(ctx.User.ID), req.SessionID)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	})
+
+	H("/user/list-worker", func(ctx *Context, req *types.ListWorkerRequest) (types.ListWorkerResponse, error) {
+		user, err := authorize(ctx, PermViewWorker, 0)
 		if err != nil {
 			return nil, err
 		}
 
 		var workers []*model.Worker
-		if user.IsAdmin() {
+		if hasPermission(user.Role, PermAllWorkers) {
 			workers, err = model.FindAllWorkers()
 		} else {
 			workers, err = model.FindWorkersByUserID(uint64(user.ID))
@@ -121,27 +167,51 @@ func init() {
 
 		resp := make([]*types.Worker, 0, len(workers))
 		for _, w := range workers {
-			userIDs, err := model.FindWorkerUsers(uint64(w.ID))
+			level, err := workerLevel(user, uint64(w.ID))
+			if err != nil {
+				return nil, err
+			}
+
+			if level == 0 {
+				continue
+			}
+
+			userWorkers, err := model.FindWorkerGrants(uint64(w.ID))
 			if err != nil {
 				return nil, fmt.Errorf("db find worker users failed, %v", err)
 			}
 
+			var (
+				userIDs = make([]uint64, 0, len(userWorkers))
+				grants  = make([]*types.WorkerGrant, 0, len(userWorkers))
+			)
+			for _, u := range userWorkers {
+				userIDs = append(userIDs, u.UserID)
+				grants = append(grants, &types.WorkerGrant{
+					UserID: u.UserID,
+					Level:  u.Level,
+				})
+			}
+
 			resp = append(resp, &types.Worker{
 				ID:        uint64(w.ID),
-				Key:       w.Key,
 				Name:      w.Name,
 				Desc:      w.Desc,
 				Status:    w.Status,
 				CreatedAt: w.CreatedAt.Unix(),
+				Level:     level,
 				Users:     userIDs,
+				Grants:    grants,
+
+				MaxRunTime:         w.MaxRunTime,
+				LeaseExpiredPolicy: w.LeaseExpiredPolicy,
 			})
 		}
 		return resp, nil
 	})
 
-	H("/user/update-worker-name", func(req *types.UpdateWorkerRequest) (bool, error) {
-		user, err := validUser(req.Token)
-		if err != nil {
+	Audited("/user/update-worker-name", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermEditWorker, req.WorkerID); err != nil {
 			return false, err
 		}
 
@@ -150,10 +220,6 @@ func init() {
 			return false, fmt.Errorf("db find worker failed, %v", err)
 		}
 
-		if err := validWorkerOwner(user, req.WorkerID); err != nil {
-			return false, err
-		}
-
 		if err := validWorkerEnabled(worker.Status); err != nil {
 			return false, err
 		}
@@ -185,9 +251,8 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/update-worker-desc", func(req *types.UpdateWorkerRequest) (bool, error) {
-		user, err := validUser(req.Token)
-		if err != nil {
+	Audited("/user/update-worker-desc", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermEditWorker, req.WorkerID); err != nil {
 			return false, err
 		}
 
@@ -196,10 +261,6 @@ func init() {
 			return false, fmt.Errorf("db find worker failed, %v", err)
 		}
 
-		if err := validWorkerOwner(user, req.WorkerID); err != nil {
-			return false, err
-		}
-
 		if err := validWorkerEnabled(worker.Status); err != nil {
 			return false, err
 		}
@@ -223,20 +284,79 @@ func init() {
 		return true, nil
 	})
 
-	H("/user/list-task", func(req *types.UserListTaskRequest) (*types.ListTaskResponse, error) {
-		user, err := validUser(req.Token)
+	Audited("/user/update-worker-lease-expired-policy", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermEditWorker, req.WorkerID); err != nil {
+			return false, err
+		}
+
+		worker, err := model.FindWorkerByID(req.WorkerID)
 		if err != nil {
-			return nil, err
+			return false, fmt.Errorf("db find worker failed, %v", err)
 		}
 
-		if _, err := model.FindWorkerByID(req.WorkerID); err != nil {
-			return nil, fmt.Errorf("db find worker failed, %v", err)
+		if err := validWorkerEnabled(worker.Status); err != nil {
+			return false, err
+		}
+
+		if _, ok := types.LeaseExpiredPolicyNames[req.LeaseExpiredPolicy]; !ok {
+			return false, fmt.Errorf("invalid lease expired policy: %d", req.LeaseExpiredPolicy)
 		}
 
-		if err := validWorkerOwner(user, req.WorkerID); err != nil {
+		if req.LeaseExpiredPolicy == worker.LeaseExpiredPolicy {
+			return true, nil
+		}
+
+		err = worker.UpdateLeaseExpiredPolicy(req.LeaseExpiredPolicy)
+		if err != nil {
+			return false, fmt.Errorf("db update worker lease expired policy failed, %v", err)
+		}
+
+		return true, nil
+	})
+
+	Audited("/user/update-worker-max-run-time", func(ctx *Context, req *types.UpdateWorkerRequest) (bool, error) {
+		if _, err := authorize(ctx, PermEditWorker, req.WorkerID); err != nil {
+			return false, err
+		}
+
+		worker, err := model.FindWorkerByID(req.WorkerID)
+		if err != nil {
+			return false, fmt.Errorf("db find worker failed, %v", err)
+		}
+
+		if err := validWorkerEnabled(worker.Status); err != nil {
+			return false, err
+		}
+
+		if req.MaxRunTime < 0 || req.MaxRunTime > types.MaxWorkerMaxRunTime {
+			return false, fmt.Errorf("invalid max run time: %d, should be in [0, %d] seconds",
+				req.MaxRunTime, types.MaxWorkerMaxRunTime)
+		}
+
+		if req.MaxRunTime == worker.MaxRunTime {
+			return true, nil
+		}
+
+		err = worker.UpdateMaxRunTime(req.MaxRunTime)
+		if err != nil {
+			return false, fmt.Errorf("db update worker max run time failed, %v", err)
+		}
+
+		return true, nil
+	})
+
+	H("/user/list-task", func(ctx *Context, req *types.UserListTaskRequest) (*types.ListTaskResponse, error) {
+		if _, err := authorize(ctx, PermViewWorker, req.WorkerID); err != nil {
 			return nil, err
 		}
 
+		worker, err := model.FindWorkerByID(req.WorkerID)
+		if err != nil {
+			return nil, fmt.Errorf("db find worker failed, %v", err)
+		}
+
+		maxRunTime := server.S.TaskMaxRunTime(worker)
+
 		total, err := model.FindTaskCountByWorkerID(req.WorkerID)
 		if err != nil {
 			return nil, fmt.Errorf("db find task count failed, %v", err)
@@ -247,30 +367,107 @@ func init() {
 			return nil, fmt.Errorf("db find tasks failed, %v", err)
 		}
 
+		queue, err := server.S.TaskQueue(req.WorkerID)
+		if err != nil {
+			return nil, err
+		}
+
+		positions := make(map[uint]int64, len(queue))
+		for i, t := range queue {
+			positions[t.ID] = int64(i + 1)
+		}
+
+		var retriedIDs []uint64
+		for _, t := range tasks {
+			if t.Attempt > 1 {
+				retriedIDs = append(retriedIDs, uint64(t.ID))
+			}
+		}
+
+		attempts := make(map[uint64][]*model.TaskAttempt, len(retriedIDs))
+		if len(retriedIDs) > 0 {
+			found, err := model.FindTaskAttemptsByTaskIDs(retriedIDs)
+			if err != nil {
+				return nil, fmt.Errorf("db find task attempts failed, %v", err)
+			}
+
+			for _, a := range found {
+				attempts[a.TaskID] = append(attempts[a.TaskID], a)
+			}
+		}
+
 		resp := &types.ListTaskResponse{
 			Total: total,
 			Tasks: make([]*types.Task, 0, len(tasks)),
 		}
 
 		for _, t := range tasks {
-			user, _ := model.FindUserByID(t.UserID)
-			resp.Tasks = append(resp.Tasks, &types.Task{
+			creator := t.Creator
+			if user, err := model.FindUserByID(t.UserID); err == nil {
+				creator = user.Name
+			}
+
+			task := &types.Task{
 				ID:        uint64(t.ID),
 				Params:    t.Params,
-				Creator:   user.Name,
+				Creator:   creator,
 				Status:    t.Status,
 				Progress:  t.Progress,
 				Detail:    t.Detail,
 				CreatedAt: t.CreatedAt.Unix(),
 				UpdatedAt: t.UpdatedAt.Unix(),
-			})
+
+				CancelRequested: t.CancelRequested,
+
+				Attempt:     t.Attempt,
+				MaxAttempts: t.MaxAttempts,
+				LeaseOwner:  t.LeaseOwner,
+
+				Priority:      t.Priority,
+				QueuePosition: positions[t.ID],
+
+				ScheduleID:    t.ScheduleID,
+				PipelineRunID: t.PipelineRunID,
+			}
+			if t.RunAt != nil {
+				task.RunAt = t.RunAt.Unix()
+			}
+			if t.AcceptedAt != nil {
+				task.AcceptedAt = t.AcceptedAt.Unix()
+				if maxRunTime > 0 {
+					task.Deadline = t.AcceptedAt.Add(maxRunTime).Unix()
+				}
+			}
+			if t.LeaseExpiredAt != nil {
+				task.LeaseExpiredAt = t.LeaseExpiredAt.Unix()
+			}
+
+			for _, a := range attempts[uint64(t.ID)] {
+				if a.Attempt >= t.Attempt {
+					continue
+				}
+
+				attempt := &types.TaskAttempt{
+					Attempt:    a.Attempt,
+					Status:     a.Status,
+					Progress:   a.Progress,
+					Detail:     a.Detail,
+					FinishedAt: a.CreatedAt.Unix(),
+				}
+				if a.AcceptedAt != nil {
+					attempt.AcceptedAt = a.AcceptedAt.Unix()
+				}
+				task.Attempts = append(task.Attempts, attempt)
+			}
+
+			resp.Tasks = append(resp.Tasks, task)
 		}
 
 		return resp, nil
 	})
 
-	H("/user/send-task", func(req *types.SendTaskRequest) (*types.SendTaskResponse, error) {
-		user, err := validUser(req.Token)
+	Audited("/user/send-task", func(ctx *Context, req *types.SendTaskRequest) (*types.SendTaskResponse, error) {
+		user, err := authorize(ctx, PermSendTask, req.WorkerID)
 		if err != nil {
 			return nil, err
 		}
@@ -287,15 +484,35 @@ func init() {
 			return nil, fmt.Errorf("db find worker failed, %v", err)
 		}
 
-		if err := validWorkerOwner(user, req.WorkerID); err != nil {
+		if err := validWorkerEnabled(worker.Status); err != nil {
 			return nil, err
 		}
 
-		if err := validWorkerEnabled(worker.Status); err != nil {
-			return nil, err
+		if req.Retries < 0 || req.Retries > types.MaxTaskRetries {
+			return nil, fmt.Errorf("invalid task retries: %d, should be in [0, %d]",
+				req.Retries, types.MaxTaskRetries)
+		}
+
+		if maxPriority := server.S.MaxTaskPriority(user.Role); req.Priority < 0 || req.Priority > maxPriority {
+			return nil, fmt.Errorf("invalid task priority: %d, should be in [0, %d] for role %s",
+				req.Priority, maxPriority, user.Role)
+		}
+
+		var runAt *time.Time
+		if now := time.Now(); req.RunAt > now.Unix() {
+			if req.RunAt > now.Unix()+types.MaxTaskDelay {
+				return nil, fmt.Errorf("invalid task run at: %s, should be within %s from now",
+					time.Unix(req.RunAt, 0).Format(time.RFC3339), time.Duration(types.MaxTaskDelay)*time.Second)
+			}
+
+			t := time.Unix(req.RunAt, 0)
+			runAt = &t
 		}
 
 		task := model.NewTask(uint64(user.ID), req.WorkerID, req.Params)
+		task.MaxAttempts += req.Retries
+		task.Priority = req.Priority
+		task.RunAt = runAt
 		err = task.Insert()
 		if err != nil {
 			return nil, fmt.Errorf("db insert task failed, %v", err)
@@ -306,8 +523,45 @@ func init() {
 		}, nil
 	})
 
-	H("/user/task-status", func(req *types.TaskStatusRequest) (*types.TaskStatusResponse, error) {
-		user, err := validUser(req.Token)
+	Audited("/user/cancel-task", func(ctx *Context, req *types.CancelTaskRequest) (bool, error) {
+		user, err := authorize(ctx, PermSendTask, 0)
+		if err != nil {
+			return false, err
+		}
+
+		task, err := model.FindTaskByID(req.TaskID)
+		if err != nil {
+			return false, fmt.Errorf("db find task failed, %v", err)
+		}
+
+		if err := authorizeWorker(user, task.WorkerID, PermSendTask); err != nil {
+			return false, err
+		}
+
+		// The task may be accepted between the two updates, so both are tried.
+		ok, err := task.Cancel(fmt.Sprintf("canceled by %s before accepted", user.Name))
+		if err != nil {
+			return false, fmt.Errorf("db cancel task failed, %v", err)
+		}
+
+		if ok {
+			return true, nil
+		}
+
+		ok, err = task.RequestCancel()
+		if err != nil {
+			return false, fmt.Errorf("db request task cancel failed, %v", err)
+		}
+
+		if !ok {
+			return false, errTaskFinished
+		}
+
+		return true, nil
+	})
+
+	H("/user/task-status", func(ctx *Context, req *types.TaskStatusRequest) (*types.TaskStatusResponse, error) {
+		user, err := authorize(ctx, PermViewWorker, 0)
 		if err != nil {
 			return nil, err
 		}
@@ -317,22 +571,60 @@ func init() {
 			return nil, fmt.Errorf("db find task failed, %v", err)
 		}
 
-		if err := validWorkerOwner(user, task.WorkerID); err != nil {
+		if err := authorizeWorker(user, task.WorkerID, PermViewWorker); err != nil {
 			return nil, err
 		}
 
-		return &types.TaskStatusResponse{
-			Status:   task.Status,
-			Progress: task.Progress,
-			Detail:   task.Detail,
-		}, nil
+		resp := taskStatusResponse(task)
+		resp.Detail = task.Detail
+
+		if task.Status == types.TaskRecord {
+			resp.QueuePosition, err = server.S.QueuePosition(task)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return resp, nil
 	})
 }
 
-func validUser(token string) (*model.User, error) {
-	userID, ok := server.S.ValidUserToken(token)
-	if !ok {
-		return nil, errInvalidUserToken
+func validUsername(name string) error {
+	if len(name) < MinUsernameLen || MaxUsernameLen < len(name) {
+		return fmt.Errorf("invalid user name length %d, should in [%d, %d]",
+			len(name), MinUsernameLen, MaxUsernameLen)
+	}
+
+	return nil
+}
+
+func validUsernamePassword(name, password string) error {
+	if err := validUsername(name); err != nil {
+		return err
+	}
+
+	return server.S.ValidPassword(password)
+}
+
+func validUser(token string) (*Caller, error) {
+	caller := &Caller{}
+
+	var userID uint64
+	if server.IsAccessToken(token) {
+		accessToken, ok := server.S.ValidAccessToken(token)
+		if !ok {
+			return nil, errInvalidUserToken
+		}
+
+		userID = accessToken.UserID
+		caller.AccessTokenID = uint64(accessToken.ID)
+		caller.WorkerIDs = accessToken.WorkerIDs()
+	} else {
+		var ok bool
+		userID, ok = server.S.ValidUserToken(token)
+		if !ok {
+			return nil, errInvalidUserToken
+		}
 	}
 
 	user, err := model.FindUserByID(userID)
@@ -344,28 +636,34 @@ func validUser(token string) (*model.User, error) {
 		return nil, errUserDisabled
 	}
 
-	return user, nil
+	caller.User = user
+	return caller, nil
 }
 
-func validWorkerEnabled(workerStatus int8) error {
-	if workerStatus != types.WorkerEnabled {
-		return errWorkerDisabled
+func listSession(userID uint64, token string) (types.ListSessionResponse, error) {
+	sessions, err := server.S.UserSessions(userID)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
-}
-
-func validWorkerOwner(user *model.User, workerID uint64) error {
-	if user.IsAdmin() {
-		return nil
+	hash := server.SessionTokenHash(token)
+	resp := make([]*types.Session, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, &types.Session{
+			ID:         uint64(s.ID),
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			Current:    s.Hash == hash,
+			CreatedAt:  s.CreatedAt.Unix(),
+			LastUsedAt: s.LastUsedAt.Unix(),
+		})
 	}
+	return resp, nil
+}
 
-	if _, err := model.FindUserWorker(uint64(user.ID), workerID); err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return errWorkerOwner
-		}
-
-		return fmt.Errorf("db find user worker failed, %v", err)
+func validWorkerEnabled(workerStatus int8) error {
+	if workerStatus != types.WorkerEnabled {
+		return errWorkerDisabled
 	}
 
 	return nil
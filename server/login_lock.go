@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	errTooManyLoginFailures = fmt.Errorf("too many failed login attempts, please try again later")
+)
+
+type loginTarget struct {
+	kind   int8
+	target string
+}
+
+func (t loginTarget) String() string {
+	return types.LoginLockKindNames[t.kind] + ":" + t.target
+}
+
+// loginTargets returns the account target first, then the ip target if ip is known.
+func loginTargets(kind int8, account, ip string) []loginTarget {
+	targets := []loginTarget{{kind, account}}
+	if len(ip) > 0 {
+		targets = append(targets, loginTarget{types.LoginLockIP, ip})
+	}
+	return targets
+}
+
+func (s *Server) maxLoginFailures(kind int8) int64 {
+	if kind == types.LoginLockIP {
+		return s.cfg.LoginIPMaxFailures
+	}
+
+	return s.cfg.LoginMaxFailures
+}
+
+// checkLoginLocks returns errTooManyLoginFailures if any of targets is locked out.
+func (s *Server) checkLoginLocks(targets []loginTarget) error {
+	now := time.Now()
+	for _, t := range targets {
+		if s.maxLoginFailures(t.kind) <= 0 {
+			continue
+		}
+
+		lock, err := model.FindLoginLock(t.kind, t.target)
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				continue
+			}
+
+			return fmt.Errorf("db find login lock failed, %v", err)
+		}
+
+		if lock.Locked(now) {
+			return errTooManyLoginFailures
+		}
+	}
+
+	return nil
+}
+
+// loginFailed counts a failed login for all targets, and locks out those have too many.
+func (s *Server) loginFailed(targets []loginTarget, ip string) {
+	now := time.Now()
+	for _, t := range targets {
+		maxFailures := s.maxLoginFailures(t.kind)
+		if maxFailures <= 0 {
+			continue
+		}
+
+		lock, err := model.FindLoginLock(t.kind, t.target)
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				log.Errorf("db find login lock %s failed, %v", t, err)
+				continue
+			}
+
+			lock = model.NewLoginLock(t.kind, t.target)
+		}
+
+		if now.Sub(lock.LastFailedAt) > s.cfg.LoginFailureWindow && !lock.Locked(now) {
+			lock.Failures = 0
+		}
+
+		lock.Failures++
+		lock.LastFailedAt = now
+
+		if int64(lock.Failures) >= maxFailures {
+			lockout := s.loginLockout(int64(lock.Failures) - maxFailures)
+			lockedUntil := now.Add(lockout)
+			lock.LockedUntil = &lockedUntil
+			s.recordLockout(t, lock, ip)
+		}
+
+		if err := lock.Save(); err != nil {
+			log.Errorf("db save login lock %s failed, %v", t, err)
+		}
+	}
+}
+
+// loginLockout returns the lockout after extra failures over the max,
+// which doubles with each failure. It stays at the longest duration once doubling
+// would overflow.
+func (s *Server) loginLockout(extra int64) time.Duration {
+	lockout := time.Duration(math.MaxInt64)
+	if extra < 63 && s.cfg.LoginLockout <= lockout>>uint(extra) {
+		lockout = s.cfg.LoginLockout << uint(extra)
+	}
+
+	if s.cfg.LoginMaxLockout > 0 && lockout > s.cfg.LoginMaxLockout {
+		lockout = s.cfg.LoginMaxLockout
+	}
+
+	return lockout
+}
+
+func (s *Server) recordLockout(t loginTarget, lock *model.LoginLock, ip string) {
+	log.Warnf("login of %s is locked out until %s after %d failures",
+		t, lock.LockedUntil.Format(time.RFC3339), lock.Failures)
+
+	summary := fmt.Sprintf(`{"failures":%d,"locked_until":%d}`, lock.Failures, lock.LockedUntil.Unix())
+	event := model.NewAuditEvent(0, "", 0, "login-lockout", t.String(), summary, ip, "")
+	if err := event.Insert(); err != nil {
+		log.Errorf("db insert audit event login-lockout failed, %v", err)
+	}
+}
+
+// loginSucceeded clears the failures of the account target.
+func (s *Server) loginSucceeded(t loginTarget) {
+	if err := model.DeleteLoginLock(t.kind, t.target); err != nil {
+		log.Errorf("db delete login lock %s failed, %v", t, err)
+	}
+}
+
+// LoginLocks returns the login locks which are locked out now.
+func (s *Server) LoginLocks() ([]*model.LoginLock, error) {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	locks, err := model.FindLockedLoginLocks(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("db find login locks failed, %v", err)
+	}
+
+	return locks, nil
+}
+
+// ClearLoginLock lifts the lockout of target early and clears its failures.
+func (s *Server) ClearLoginLock(kind int8, target string) error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	err := model.DeleteLoginLock(kind, target)
+	if err != nil {
+		return fmt.Errorf("db delete login lock failed, %v", err)
+	}
+
+	return nil
+}
+
+// SweepLoginLocks deletes the login locks which are neither locked nor failed recently.
+func (s *Server) SweepLoginLocks() error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	now := time.Now()
+	err := model.DeleteStaleLoginLocks(now.Add(-s.cfg.LoginFailureWindow), now)
+	if err != nil {
+		return fmt.Errorf("db delete stale login locks failed, %v", err)
+	}
+
+	return nil
+}
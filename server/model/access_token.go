@@ -0,0 +1,103 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+)
+
+type AccessToken struct {
+	Model
+
+	UserID     uint64 `gorm:"index"`
+	Name       string `gorm:"size:64"`
+	Hash       string `gorm:"size:64;unique_index"`
+	Workers    string `gorm:"type:text"` // comma separated worker ids, empty means no restriction.
+	ExpiredAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+func NewAccessToken(userID uint64, name, hash string, workerIDs []uint64, expiredAt *time.Time) *AccessToken {
+	workers := make([]string, 0, len(workerIDs))
+	for _, id := range workerIDs {
+		workers = append(workers, strconv.FormatUint(id, 10))
+	}
+
+	return &AccessToken{
+		UserID:    userID,
+		Name:      name,
+		Hash:      hash,
+		Workers:   strings.Join(workers, ","),
+		ExpiredAt: expiredAt,
+	}
+}
+
+func (*AccessToken) TableName() string { return "access_token" }
+
+func (t *AccessToken) Insert() error {
+	return db.Default().Create(t).Error
+}
+
+func (t *AccessToken) WorkerIDs() []uint64 {
+	if len(t.Workers) == 0 {
+		return nil
+	}
+
+	ids := make([]uint64, 0, strings.Count(t.Workers, ",")+1)
+	for _, s := range strings.Split(t.Workers, ",") {
+		if id, err := strconv.ParseUint(s, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (t *AccessToken) Expired(now time.Time) bool {
+	return t.ExpiredAt != nil && now.After(*t.ExpiredAt)
+}
+
+func (t *AccessToken) Touch() error {
+	return db.Default().Model(t).Updates(M{
+		"last_used_at": time.Now(),
+	}).Error
+}
+
+func (t *AccessToken) Delete() error {
+	return db.Default().Delete(t).Error
+}
+
+func FindAccessTokenByHash(hash string) (*AccessToken, error) {
+	var token AccessToken
+	err := db.Default().First(&token, "hash = ?", hash).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func FindAccessTokenByID(id uint64) (*AccessToken, error) {
+	var token AccessToken
+	err := db.Default().First(&token, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func FindAccessTokensByUserID(userID uint64) ([]*AccessToken, error) {
+	var tokens []*AccessToken
+	err := db.Default().Order("id").Find(&tokens, "user_id = ?", userID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func DeleteAccessTokensByUserID(userID uint64) error {
+	return db.Default().Where("user_id = ?", userID).Delete(AccessToken{}).Error
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/tidyoux/router/common/db"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	MaxAuditSummaryLen = 4096
+	MaxAuditErrorLen   = 255
+)
+
+// AuditEvent is append only, so it has no update or delete method.
+type AuditEvent struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+
+	ActorID       uint64 `gorm:"index"`
+	Actor         string `gorm:"size:32"`
+	AccessTokenID uint64
+	Action        string `gorm:"size:64;index"`
+	Target        string `gorm:"size:64;index"`
+	Summary       string `gorm:"type:text"`
+	IP            string `gorm:"size:64"`
+	Error         string `gorm:"size:255"`
+}
+
+func NewAuditEvent(actorID uint64, actor string, accessTokenID uint64, action, target, summary, ip, errMsg string) *AuditEvent {
+	return &AuditEvent{
+		ActorID:       actorID,
+		Actor:         actor,
+		AccessTokenID: accessTokenID,
+		Action:        action,
+		Target:        target,
+		Summary:       truncate(summary, MaxAuditSummaryLen),
+		IP:            ip,
+		Error:         truncate(errMsg, MaxAuditErrorLen),
+	}
+}
+
+// truncate cuts s to n bytes at most, on a rune boundary so that no invalid
+// UTF-8 is stored.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+func (*AuditEvent) TableName() string { return "audit_event" }
+
+func (e *AuditEvent) Insert() error {
+	return db.Default().Create(e).Error
+}
+
+// AuditFilter filters audit events, zero fields are ignored.
+type AuditFilter struct {
+	ActorID uint64
+	Action  string
+	Target  string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f *AuditFilter) apply(scope *gorm.DB) *gorm.DB {
+	if f.ActorID > 0 {
+		scope = scope.Where("actor_id = ?", f.ActorID)
+	}
+
+	if len(f.Action) > 0 {
+		scope = scope.Where("action = ?", f.Action)
+	}
+
+	if len(f.Target) > 0 {
+		scope = scope.Where("target = ?", f.Target)
+	}
+
+	if !f.Since.IsZero() {
+		scope = scope.Where("created_at >= ?", f.Since)
+	}
+
+	if !f.Until.IsZero() {
+		scope = scope.Where("created_at < ?", f.Until)
+	}
+
+	return scope
+}
+
+func FindAuditEventCount(filter *AuditFilter) (int64, error) {
+	var count int64
+	err := filter.apply(db.Default().Model(AuditEvent{})).Count(&count).Error
+	return count, err
+}
+
+func FindAuditEvents(filter *AuditFilter, offset, limit int64) ([]*AuditEvent, error) {
+	var events []*AuditEvent
+	err := filter.apply(db.Default()).Offset(offset).Limit(limit).Order("id desc").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
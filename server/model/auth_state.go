@@ -0,0 +1,58 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+)
+
+// AuthState keeps a redirect login between leaving for the auth provider and coming back.
+type AuthState struct {
+	Model
+
+	State     string `gorm:"size:64;unique_index"`
+	Provider  string `gorm:"size:32"`
+	Nonce     string `gorm:"size:64"`
+	Verifier  string `gorm:"size:128"` // PKCE code verifier.
+	ExpiredAt time.Time
+}
+
+func NewAuthState(state, provider, nonce, verifier string, expiredAt time.Time) *AuthState {
+	return &AuthState{
+		State:     state,
+		Provider:  provider,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiredAt: expiredAt,
+	}
+}
+
+func (*AuthState) TableName() string { return "auth_state" }
+
+func (s *AuthState) Insert() error {
+	return db.Default().Create(s).Error
+}
+
+// Take deletes the state, it returns false if the state has been taken already.
+func (s *AuthState) Take() (bool, error) {
+	r := db.Default().Delete(s)
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	return r.RowsAffected > 0, nil
+}
+
+func FindAuthState(state string) (*AuthState, error) {
+	var s AuthState
+	err := db.Default().First(&s, "state = ?", state).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func DeleteExpiredAuthStates(now time.Time) error {
+	return db.Default().Where("expired_at < ?", now).Delete(AuthState{}).Error
+}
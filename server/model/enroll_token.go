@@ -0,0 +1,74 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+)
+
+// EnrollToken is a single use token which an agent exchanges for its worker's credentials.
+type EnrollToken struct {
+	Model
+
+	WorkerID  uint64 `gorm:"index"`
+	CreatorID uint64
+	Hash      string `gorm:"size:64;unique_index"`
+	ExpiredAt time.Time
+	UsedAt    *time.Time
+}
+
+func NewEnrollToken(workerID, creatorID uint64, hash string, expiredAt time.Time) *EnrollToken {
+	return &EnrollToken{
+		WorkerID:  workerID,
+		CreatorID: creatorID,
+		Hash:      hash,
+		ExpiredAt: expiredAt,
+	}
+}
+
+func (*EnrollToken) TableName() string { return "enroll_token" }
+
+func (t *EnrollToken) Insert() error {
+	return db.Default().Create(t).Error
+}
+
+func (t *EnrollToken) Valid(now time.Time) bool {
+	return t.UsedAt == nil && now.Before(t.ExpiredAt)
+}
+
+// Use marks the token used, it returns false if the token has been used already.
+func (t *EnrollToken) Use() (bool, error) {
+	now := time.Now()
+	r := db.Default().Model(EnrollToken{}).
+		Where("id = ? and used_at is null", t.ID).
+		Updates(M{"used_at": now})
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return false, nil
+	}
+
+	t.UsedAt = &now
+	return true, nil
+}
+
+func FindEnrollTokenByHash(hash string) (*EnrollToken, error) {
+	var token EnrollToken
+	err := db.Default().First(&token, "hash = ?", hash).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func DeleteEnrollTokensByWorkerID(workerID uint64) error {
+	return db.Default().Where("worker_id = ?", workerID).Delete(EnrollToken{}).Error
+}
+
+// DeleteStaleEnrollTokens deletes the tokens which are used or expired before.
+func DeleteStaleEnrollTokens(before time.Time) error {
+	return db.Default().Where("expired_at < ? or used_at < ?", before, before).Delete(EnrollToken{}).Error
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+)
+
+// LoginLock counts the recent failed logins of a target, which is a user name,
+// worker id or IP, and locks the target out when there are too many.
+type LoginLock struct {
+	Model
+
+	Kind         int8   `gorm:"type:tinyint;unique_index:idx_login_lock"`
+	Target       string `gorm:"size:64;unique_index:idx_login_lock"`
+	Failures     int
+	LastFailedAt time.Time `gorm:"index"`
+	LockedUntil  *time.Time
+}
+
+func NewLoginLock(kind int8, target string) *LoginLock {
+	return &LoginLock{
+		Kind:   kind,
+		Target: target,
+	}
+}
+
+func (*LoginLock) TableName() string { return "login_lock" }
+
+func (l *LoginLock) Save() error {
+	return db.Default().Save(l).Error
+}
+
+func (l *LoginLock) Delete() error {
+	return db.Default().Delete(l).Error
+}
+
+func (l *LoginLock) Locked(now time.Time) bool {
+	return l.LockedUntil != nil && now.Before(*l.LockedUntil)
+}
+
+func FindLoginLock(kind int8, target string) (*LoginLock, error) {
+	var lock LoginLock
+	err := db.Default().First(&lock, "kind = ? and target = ?", kind, target).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+// FindLockedLoginLocks returns the login locks which are still locked at now.
+func FindLockedLoginLocks(now time.Time) ([]*LoginLock, error) {
+	var locks []*LoginLock
+	err := db.Default().Order("id").Find(&locks, "locked_until > ?", now).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return locks, nil
+}
+
+func DeleteLoginLock(kind int8, target string) error {
+	return db.Default().Where("kind = ? and target = ?", kind, target).Delete(LoginLock{}).Error
+}
+
+// DeleteStaleLoginLocks deletes login locks which failed last before lastFailedBefore
+// and are not locked at now.
+func DeleteStaleLoginLocks(lastFailedBefore, now time.Time) error {
+	return db.Default().
+		Where("last_failed_at < ? and (locked_until is null or locked_until <= ?)", lastFailedBefore, now).
+		Delete(LoginLock{}).Error
+}
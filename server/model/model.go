@@ -12,6 +12,17 @@ func Init(db *gorm.DB) error {
 		&Worker{},
 		&UserWorker{},
 		&Task{},
+		&Session{},
+		&AccessToken{},
+		&AuditEvent{},
+		&LoginLock{},
+		&EnrollToken{},
+		&AuthState{},
+		&TaskAttempt{},
+		&Schedule{},
+		&Pipeline{},
+		&PipelineRun{},
+		&PipelineRunNode{},
 	).Error
 }
 
@@ -0,0 +1,278 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+	"github.com/tidyoux/router/common/types"
+)
+
+// Pipeline is a DAG of tasks on several workers, Nodes keeps the JSON of its
+// []*types.PipelineNode. UserID is who last saved it.
+type Pipeline struct {
+	Model
+
+	Name          string `gorm:"size:64;unique_index"`
+	Desc          string `gorm:"size:255"`
+	Nodes         string `gorm:"type:text"`
+	FailurePolicy int8   `gorm:"type:tinyint"`
+
+	UserID uint64 `gorm:"index"`
+	// Creator keeps the user name after the user is deleted.
+	Creator string `gorm:"size:32"`
+}
+
+func NewPipeline(userID uint64, creator string) *Pipeline {
+	return &Pipeline{
+		UserID:  userID,
+		Creator: creator,
+	}
+}
+
+func (*Pipeline) TableName() string { return "pipeline" }
+
+func (p *Pipeline) Insert() error {
+	return db.Default().Create(p).Error
+}
+
+// Save updates all fields of the pipeline.
+func (p *Pipeline) Save() error {
+	return db.Default().Save(p).Error
+}
+
+func (p *Pipeline) Delete() error {
+	return db.Default().Delete(p).Error
+}
+
+func FindPipelineByID(id uint64) (*Pipeline, error) {
+	var pipeline Pipeline
+	err := db.Default().First(&pipeline, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipeline, nil
+}
+
+func FindPipelineByName(name string) (*Pipeline, error) {
+	var pipeline Pipeline
+	err := db.Default().First(&pipeline, "name = ?", name).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipeline, nil
+}
+
+func FindAllPipelines() ([]*Pipeline, error) {
+	var pipelines []*Pipeline
+	err := db.Default().Order("name").Find(&pipelines).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return pipelines, nil
+}
+
+// PipelineRun is a run of a pipeline, its tasks are sent as the runner UserID.
+// The nodes and the failure policy are copied when the run starts, so a run
+// is not changed by updating the pipeline.
+type PipelineRun struct {
+	Model
+
+	PipelineID    uint64 `gorm:"index"`
+	Name          string `gorm:"size:64"`
+	UserID        uint64 `gorm:"index"`
+	Status        int8   `gorm:"type:tinyint;index"`
+	FailurePolicy int8   `gorm:"type:tinyint"`
+
+	// Creator keeps the runner name after the user is deleted.
+	Creator string `gorm:"size:32"`
+
+	// CancelRequested asks the server to stop the run and cancel its tasks.
+	CancelRequested bool
+
+	FinishedAt *time.Time
+}
+
+func NewPipelineRun(pipeline *Pipeline, userID uint64, creator string) *PipelineRun {
+	return &PipelineRun{
+		PipelineID:    uint64(pipeline.ID),
+		Name:          pipeline.Name,
+		UserID:        userID,
+		Status:        types.PipelineRunning,
+		FailurePolicy: pipeline.FailurePolicy,
+		Creator:       creator,
+	}
+}
+
+func (*PipelineRun) TableName() string { return "pipeline_run" }
+
+// Insert inserts the run with its nodes, the run never starts with part of them.
+func (r *PipelineRun) Insert(nodes []*PipelineRunNode) error {
+	tx := db.Default().Begin()
+	err := tx.Create(r).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, node := range nodes {
+		node.RunID = uint64(r.ID)
+		err = tx.Create(node).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// RequestCancel asks the server to stop the running run, it returns false
+// if the run has finished.
+func (r *PipelineRun) RequestCancel() (bool, error) {
+	return r.updateIfStatus(types.PipelineRunning, M{
+		"cancel_requested": true,
+	})
+}
+
+// Finish finishes the running run with status, it returns false if the run
+// has been finished by someone else.
+func (r *PipelineRun) Finish(status int8) (bool, error) {
+	return r.updateIfStatus(types.PipelineRunning, M{
+		"status":      status,
+		"finished_at": time.Now(),
+	})
+}
+
+func (r *PipelineRun) updateIfStatus(status int8, values M) (bool, error) {
+	res := db.Default().Model(PipelineRun{}).Where("id = ? and status = ?", r.ID, status).Updates(values)
+	if res.Error != nil {
+		return false, res.Error
+	}
+
+	return res.RowsAffected > 0, nil
+}
+
+func FindPipelineRunByID(id uint64) (*PipelineRun, error) {
+	var run PipelineRun
+	err := db.Default().First(&run, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+func FindPipelineRunCountByPipelineID(pipelineID uint64) (int64, error) {
+	var count int64
+	err := db.Default().Model(PipelineRun{}).Where("pipeline_id = ?", pipelineID).Count(&count).Error
+	return count, err
+}
+
+func FindPipelineRunsByPipelineID(pipelineID uint64, offset, limit int64) ([]*PipelineRun, error) {
+	var runs []*PipelineRun
+	err := db.Default().Offset(offset).Limit(limit).Order("id desc").Find(&runs, "pipeline_id = ?", pipelineID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+func FindRunningPipelineRuns() ([]*PipelineRun, error) {
+	var runs []*PipelineRun
+	err := db.Default().Order("id").Find(&runs, "status = ?", types.PipelineRunning).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+func FindRunningPipelineRunCountByPipelineID(pipelineID uint64) (int64, error) {
+	var count int64
+	err := db.Default().Model(PipelineRun{}).
+		Where("pipeline_id = ? and status = ?", pipelineID, types.PipelineRunning).Count(&count).Error
+	return count, err
+}
+
+// DeletePipelineRunsByPipelineID deletes the runs of the pipeline with their nodes.
+func DeletePipelineRunsByPipelineID(pipelineID uint64) error {
+	runIDs := db.Default().Model(PipelineRun{}).Select("id").Where("pipeline_id = ?", pipelineID).QueryExpr()
+	err := db.Default().Where("run_id in (?)", runIDs).Delete(PipelineRunNode{}).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Default().Where("pipeline_id = ?", pipelineID).Delete(PipelineRun{}).Error
+}
+
+// PipelineRunNode is a node of a pipeline run, TaskID is the task sent for it.
+// Needs keeps the names of the nodes it needs, joined by commas.
+type PipelineRunNode struct {
+	Model
+
+	RunID    uint64 `gorm:"index"`
+	Name     string `gorm:"size:64"`
+	WorkerID uint64
+	Params   string `gorm:"type:text"`
+	Needs    string `gorm:"type:text"`
+	Retries  int8   `gorm:"type:tinyint"`
+	Priority int8   `gorm:"type:tinyint"`
+
+	Status int8 `gorm:"type:tinyint"`
+	TaskID uint64
+	Detail string `gorm:"size:255"`
+}
+
+func (*PipelineRunNode) TableName() string { return "pipeline_run_node" }
+
+// Release marks the pending node as running before its task is sent, it returns
+// false if the node is no longer pending, so that only one task is sent for it.
+func (n *PipelineRunNode) Release() (bool, error) {
+	return n.updateIfStatus(types.PipelineNodePending, M{
+		"status": types.PipelineNodeRunning,
+	})
+}
+
+// SetTask records the task sent for the running node.
+func (n *PipelineRunNode) SetTask(taskID uint64) error {
+	return db.Default().Model(n).Updates(M{
+		"task_id": taskID,
+	}).Error
+}
+
+// Finish finishes the node from status from, it returns false if the node
+// is no longer in it.
+func (n *PipelineRunNode) Finish(from, status int8, detail string) (bool, error) {
+	if len(detail) > 255 {
+		detail = detail[:255]
+	}
+
+	return n.updateIfStatus(from, M{
+		"status": status,
+		"detail": detail,
+	})
+}
+
+func (n *PipelineRunNode) updateIfStatus(status int8, values M) (bool, error) {
+	r := db.Default().Model(PipelineRunNode{}).Where("id = ? and status = ?", n.ID, status).Updates(values)
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	return r.RowsAffected > 0, nil
+}
+
+// FindPipelineRunNodes returns the nodes of the run in the order they can be sent.
+func FindPipelineRunNodes(runID uint64) ([]*PipelineRunNode, error) {
+	var nodes []*PipelineRunNode
+	err := db.Default().Order("id").Find(&nodes, "run_id = ?", runID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+)
+
+// Schedule sends a task to a worker each time its cron expression matches
+// in its time zone, the tasks are sent as the owner UserID.
+type Schedule struct {
+	Model
+
+	WorkerID uint64 `gorm:"index"`
+	UserID   uint64 `gorm:"index"`
+	Name     string `gorm:"size:64"`
+	Params   string `gorm:"type:text"`
+	Cron     string `gorm:"size:128"`
+	TimeZone string `gorm:"size:64"`
+	Enabled  bool
+
+	Retries  int8 `gorm:"type:tinyint"`
+	Priority int8 `gorm:"type:tinyint"`
+
+	// Creator keeps the owner name after the user is deleted.
+	Creator string `gorm:"size:32"`
+
+	// NextRunAt is when the next task is due, nil if the schedule is disabled.
+	NextRunAt *time.Time `gorm:"index"`
+	LastRunAt *time.Time
+	// LastTaskID is the task of the last run, 0 if it was skipped for LastError.
+	LastTaskID uint64
+	LastError  string `gorm:"size:255"`
+}
+
+func NewSchedule(workerID, userID uint64, creator string) *Schedule {
+	return &Schedule{
+		WorkerID: workerID,
+		UserID:   userID,
+		Creator:  creator,
+	}
+}
+
+func (*Schedule) TableName() string { return "schedule" }
+
+func (s *Schedule) Insert() error {
+	return db.Default().Create(s).Error
+}
+
+// Save updates all fields of the schedule.
+func (s *Schedule) Save() error {
+	return db.Default().Save(s).Error
+}
+
+// Advance records the run due at runAt and moves the schedule to its next run,
+// it returns false if the run has been recorded by someone else.
+func (s *Schedule) Advance(runAt time.Time, nextRunAt *time.Time, taskID uint64, lastError string) (bool, error) {
+	r := db.Default().Model(Schedule{}).
+		Where("id = ? and next_run_at = ?", s.ID, runAt).
+		Updates(M{
+			"next_run_at":  nextRunAt,
+			"last_run_at":  runAt,
+			"last_task_id": taskID,
+			"last_error":   lastError,
+		})
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	return r.RowsAffected > 0, nil
+}
+
+func (s *Schedule) Delete() error {
+	return db.Default().Delete(s).Error
+}
+
+func FindScheduleByID(id uint64) (*Schedule, error) {
+	var schedule Schedule
+	err := db.Default().First(&schedule, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+func FindSchedulesByWorkerID(workerID uint64) ([]*Schedule, error) {
+	var schedules []*Schedule
+	err := db.Default().Order("id").Find(&schedules, "worker_id = ?", workerID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// FindDueSchedules returns the enabled schedules whose next run is not after now.
+func FindDueSchedules(now time.Time) ([]*Schedule, error) {
+	var schedules []*Schedule
+	err := db.Default().Order("next_run_at").
+		Find(&schedules, "enabled = ? and next_run_at <= ?", true, now).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func DeleteSchedulesByWorkerID(workerID uint64) error {
+	return db.Default().Where("worker_id = ?", workerID).Delete(Schedule{}).Error
+}
+
+// DeleteSchedulesByUserID deletes the schedules of the user, they send tasks as the user.
+func DeleteSchedulesByUserID(userID uint64) error {
+	return db.Default().Where("user_id = ?", userID).Delete(Schedule{}).Error
+}
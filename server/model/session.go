@@ -0,0 +1,124 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+)
+
+const (
+	MaxSessionUserAgentLen = 255
+)
+
+// Session keeps the hash of its token, the token itself is only known when the session
+// is created, so that the table can't be used to take over sessions.
+type Session struct {
+	Model
+
+	Token      string    `gorm:"-"` // set by NewSession only.
+	Hash       string    `gorm:"size:64;unique_index"`
+	Kind       int8      `gorm:"type:tinyint;index:idx_session_owner"`
+	OwnerID    uint64    `gorm:"index:idx_session_owner"`
+	LastUsedAt time.Time `gorm:"index"`
+	IP         string    `gorm:"size:64"`
+	UserAgent  string    `gorm:"size:255"`
+
+	// Replaced is true if a newer login of the same owner took over the session.
+	Replaced bool
+}
+
+func NewSession(token, hash string, kind int8, ownerID uint64, ip, userAgent string) *Session {
+	if len(userAgent) > MaxSessionUserAgentLen {
+		userAgent = userAgent[:MaxSessionUserAgentLen]
+	}
+
+	return &Session{
+		Token:      token,
+		Hash:       hash,
+		Kind:       kind,
+		OwnerID:    ownerID,
+		LastUsedAt: time.Now(),
+		IP:         ip,
+		UserAgent:  userAgent,
+	}
+}
+
+func (*Session) TableName() string { return "session" }
+
+func (s *Session) Insert() error {
+	return db.Default().Create(s).Error
+}
+
+func (s *Session) Touch() error {
+	return s.update(M{
+		"last_used_at": time.Now(),
+	})
+}
+
+func (s *Session) Delete() error {
+	return db.Default().Delete(s).Error
+}
+
+func (s *Session) update(values M) error {
+	return db.Default().Model(s).Updates(values).Error
+}
+
+func FindSessionByID(id uint64) (*Session, error) {
+	var session Session
+	err := db.Default().First(&session, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func FindSessionByHash(hash string) (*Session, error) {
+	var session Session
+	err := db.Default().First(&session, "hash = ?", hash).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func FindSessionsByOwner(kind int8, ownerID uint64) ([]*Session, error) {
+	var sessions []*Session
+	err := db.Default().Order("id").Find(&sessions, "kind = ? and owner_id = ?", kind, ownerID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func ReplaceSessionsByOwner(kind int8, ownerID uint64) error {
+	return db.Default().Model(Session{}).
+		Where("kind = ? and owner_id = ? and replaced = ?", kind, ownerID, false).
+		Updates(M{"replaced": true}).Error
+}
+
+func DeleteSessionsByOwner(kind int8, ownerID uint64) error {
+	return db.Default().Where("kind = ? and owner_id = ?", kind, ownerID).Delete(Session{}).Error
+}
+
+// DeleteExpiredSessions deletes sessions of kind which were last used before idleBefore
+// or created before createdBefore. A zero time disables the related check.
+func DeleteExpiredSessions(kind int8, idleBefore, createdBefore time.Time) error {
+	if !idleBefore.IsZero() {
+		err := db.Default().Where("kind = ? and last_used_at < ?", kind, idleBefore).Delete(Session{}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if !createdBefore.IsZero() {
+		err := db.Default().Where("kind = ? and created_at < ?", kind, createdBefore).Delete(Session{}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
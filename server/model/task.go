@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/tidyoux/router/common/db"
 	"github.com/tidyoux/router/common/types"
 )
@@ -14,13 +16,49 @@ type Task struct {
 	Status   int8   `gorm:"type:tinyint;index"`
 	Progress int8   `gorm:"type:tinyint;index"`
 	Detail   string `gorm:"type:text"`
+
+	// Creator keeps the user name after the user is deleted.
+	Creator string `gorm:"size:32"`
+
+	// CancelRequested asks the agent to stop the accepted task.
+	CancelRequested bool
+
+	// AcceptedAt is when the agent accepted the task, the max run time counts from it.
+	AcceptedAt *time.Time
+
+	// Attempt counts from 1, a failed task is sent back to the queue until
+	// it has run MaxAttempts times.
+	Attempt     int8 `gorm:"type:tinyint"`
+	MaxAttempts int8 `gorm:"type:tinyint"`
+
+	// LeaseOwner is the agent session working on the task, the agent renews the lease
+	// before LeaseExpiredAt, which is nil if leases never expire.
+	LeaseOwner     uint64
+	LeaseExpiredAt *time.Time `gorm:"index"`
+
+	// Priority orders the queued tasks of a worker, higher first.
+	Priority int8 `gorm:"type:tinyint"`
+
+	// ScheduleID is the schedule which sent the task for its run at ScheduledAt,
+	// a run sends one task at most.
+	ScheduleID  uint64     `gorm:"unique_index:idx_task_schedule_run"`
+	ScheduledAt *time.Time `gorm:"unique_index:idx_task_schedule_run"`
+
+	// RunAt delays the task, agents don't see it before then. Nil runs it at once.
+	RunAt *time.Time `gorm:"index"`
+
+	// PipelineRunID and PipelineNodeID are the pipeline run and its node which sent the task.
+	PipelineRunID  uint64
+	PipelineNodeID uint64 `gorm:"index"`
 }
 
 func NewTask(userID, workerID uint64, params string) *Task {
 	return &Task{
-		UserID:   userID,
-		WorkerID: workerID,
-		Params:   params,
+		UserID:      userID,
+		WorkerID:    workerID,
+		Params:      params,
+		Attempt:     1,
+		MaxAttempts: 1,
 	}
 }
 
@@ -30,12 +68,63 @@ func (t *Task) Insert() error {
 	return db.Default().Create(t).Error
 }
 
-func (t *Task) Accept() error {
-	return t.update(M{
-		"status": types.TaskAccepted,
+// QueuedAt is when the task joins the queue of its worker, RunAt if it is delayed.
+func (t *Task) QueuedAt() time.Time {
+	if t.RunAt != nil {
+		return *t.RunAt
+	}
+
+	return t.CreatedAt
+}
+
+// Accept accepts the task for the agent session leaseOwner if it has not been accepted,
+// it returns false otherwise, so that only one agent gets the task.
+func (t *Task) Accept(leaseOwner uint64, leaseExpiredAt *time.Time) (bool, error) {
+	return t.updateIfStatus(types.TaskRecord, M{
+		"status":           types.TaskAccepted,
+		"accepted_at":      time.Now(),
+		"lease_owner":      leaseOwner,
+		"lease_expired_at": leaseExpiredAt,
 	})
 }
 
+// RenewLease extends the lease of the accepted task, the lease can be taken over if
+// it has expired. A lease without expiry is held by its owner forever. It returns false if the task is no longer accepted or the lease
+// is held by another agent.
+func (t *Task) RenewLease(leaseOwner uint64, leaseExpiredAt *time.Time, now time.Time) (bool, error) {
+	r := db.Default().Model(Task{}).
+		Where("id = ? and status = ?", t.ID, types.TaskAccepted).
+		Where("lease_owner = ? or lease_owner = 0 or lease_expired_at < ?", leaseOwner, now).
+		Updates(M{
+			"lease_owner":      leaseOwner,
+			"lease_expired_at": leaseExpiredAt,
+		})
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	return r.RowsAffected > 0, nil
+}
+
+// Requeue sends the accepted task back to the queue if its lease is still expired,
+// it resumes at the current progress.
+func (t *Task) Requeue(detail string, now time.Time) (bool, error) {
+	r := db.Default().Model(Task{}).
+		Where("id = ? and status = ? and lease_expired_at < ?", t.ID, types.TaskAccepted, now).
+		Updates(M{
+			"status":           types.TaskRecord,
+			"detail":           detail,
+			"accepted_at":      nil,
+			"lease_owner":      0,
+			"lease_expired_at": nil,
+		})
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	return r.RowsAffected > 0, nil
+}
+
 func (t *Task) UpdateProgress(progress int8, detail string) error {
 	if progress < 0 {
 		progress = 0
@@ -47,27 +136,84 @@ func (t *Task) UpdateProgress(progress int8, detail string) error {
 	})
 }
 
-func (t *Task) Success(detail string) error {
+// Success, Fail, Retry and Canceled finish the attempt of the accepted task,
+// they return false if the task is no longer accepted.
+
+func (t *Task) Success(detail string) (bool, error) {
 	data := M{
 		"status": types.TaskSuccess,
 	}
 	if len(detail) > 0 {
 		data["detail"] = detail
 	}
-	return t.update(data)
+	return t.updateIfStatus(types.TaskAccepted, data)
 }
 
-func (t *Task) Fail(detail string) error {
-	return t.update(M{
+func (t *Task) Fail(detail string) (bool, error) {
+	return t.updateIfStatus(types.TaskAccepted, M{
 		"status": types.TaskFailed,
 		"detail": detail,
 	})
 }
 
+// Retry sends the task back to the queue for the next attempt.
+func (t *Task) Retry(detail string) (bool, error) {
+	return t.updateIfStatus(types.TaskAccepted, M{
+		"status":      types.TaskRecord,
+		"progress":    0,
+		"detail":      detail,
+		"attempt":     t.Attempt + 1,
+		"accepted_at": nil,
+
+		"lease_owner":      0,
+		"lease_expired_at": nil,
+	})
+}
+
+// Cancel cancels the task if it has not been accepted, it returns false otherwise.
+func (t *Task) Cancel(detail string) (bool, error) {
+	return t.updateIfStatus(types.TaskRecord, M{
+		"status": types.TaskCanceled,
+		"detail": detail,
+	})
+}
+
+// RequestCancel asks the agent to stop the task if it has been accepted and not finished,
+// it returns false otherwise.
+func (t *Task) RequestCancel() (bool, error) {
+	return t.updateIfStatus(types.TaskAccepted, M{
+		"cancel_requested": true,
+	})
+}
+
+// Canceled records that the agent has stopped the task for the cancel request.
+func (t *Task) Canceled(detail string) (bool, error) {
+	return t.updateIfStatus(types.TaskAccepted, M{
+		"status": types.TaskCanceled,
+		"detail": detail,
+	})
+}
+
+func (t *Task) updateIfStatus(status int8, values M) (bool, error) {
+	r := db.Default().Model(Task{}).Where("id = ? and status = ?", t.ID, status).Updates(values)
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	return r.RowsAffected > 0, nil
+}
+
 func (t *Task) update(values M) error {
 	return db.Default().Model(t).Updates(values).Error
 }
 
+// UpdateTasksCreator records creator on all tasks of the user.
+func UpdateTasksCreator(userID uint64, creator string) error {
+	return db.Default().Model(Task{}).Where("user_id = ?", userID).Updates(M{
+		"creator": creator,
+	}).Error
+}
+
 func FindTaskByID(id uint64) (*Task, error) {
 	var task Task
 	err := db.Default().First(&task, "id = ?", id).Error
@@ -78,6 +224,26 @@ func FindTaskByID(id uint64) (*Task, error) {
 	return &task, nil
 }
 
+func FindTaskByScheduleRun(scheduleID uint64, scheduledAt time.Time) (*Task, error) {
+	var task Task
+	err := db.Default().First(&task, "schedule_id = ? and scheduled_at = ?", scheduleID, scheduledAt).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+func FindTaskByPipelineNodeID(nodeID uint64) (*Task, error) {
+	var task Task
+	err := db.Default().First(&task, "pipeline_node_id = ?", nodeID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
 func FindTaskCountByWorkerID(workerID uint64) (int64, error) {
 	var count int64
 	err := db.Default().Model(Task{}).Where("worker_id = ?", workerID).Count(&count).Error
@@ -94,9 +260,55 @@ func FindTasksByWorkerID(workerID uint64, offset, limit int64) ([]*Task, error)
 	return tasks, nil
 }
 
-func FindAllUnfinishedTasksByWorkerID(workerID uint64) ([]*Task, error) {
+// FindAllUnfinishedTasksByWorkerID returns the unfinished tasks of the worker,
+// except the delayed ones which are not due at now.
+func FindAllUnfinishedTasksByWorkerID(workerID uint64, now time.Time) ([]*Task, error) {
+	var tasks []*Task
+	err := db.Default().Where("run_at is null or run_at <= ?", now).
+		Find(&tasks, "worker_id = ? and status <= ?", workerID, types.TaskAccepted).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// FindQueuedTasksByWorkerID returns the tasks of the worker waiting to be accepted,
+// which are due at now, in the order they join the queue, see Task.QueuedAt.
+func FindQueuedTasksByWorkerID(workerID uint64, now time.Time) ([]*Task, error) {
+	var tasks []*Task
+	err := db.Default().Order("coalesce(run_at, created_at), id").Where("run_at is null or run_at <= ?", now).
+		Find(&tasks, "worker_id = ? and status = ?", workerID, types.TaskRecord).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func FindAcceptedTasksByWorkerID(workerID uint64) ([]*Task, error) {
+	var tasks []*Task
+	err := db.Default().Find(&tasks, "worker_id = ? and status = ?", workerID, types.TaskAccepted).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func FindAllAcceptedTasks() ([]*Task, error) {
+	var tasks []*Task
+	err := db.Default().Find(&tasks, "status = ?", types.TaskAccepted).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func FindLeaseExpiredTasks(now time.Time) ([]*Task, error) {
 	var tasks []*Task
-	err := db.Default().Find(&tasks, "worker_id = ? and status <= ?", workerID, types.TaskAccepted).Error
+	err := db.Default().Find(&tasks, "status = ? and lease_expired_at < ?", types.TaskAccepted, now).Error
 	if err != nil {
 		return nil, err
 	}
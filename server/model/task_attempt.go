@@ -0,0 +1,47 @@
+package model
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+)
+
+// TaskAttempt records how an attempt of a task finished, a task which is retried
+// has one for each attempt.
+type TaskAttempt struct {
+	Model
+
+	TaskID     uint64 `gorm:"index"`
+	Attempt    int8   `gorm:"type:tinyint"`
+	Status     int8   `gorm:"type:tinyint"`
+	Progress   int8   `gorm:"type:tinyint"`
+	Detail     string `gorm:"type:text"`
+	AcceptedAt *time.Time
+}
+
+func NewTaskAttempt(task *Task, status int8, detail string) *TaskAttempt {
+	return &TaskAttempt{
+		TaskID:     uint64(task.ID),
+		Attempt:    task.Attempt,
+		Status:     status,
+		Progress:   task.Progress,
+		Detail:     detail,
+		AcceptedAt: task.AcceptedAt,
+	}
+}
+
+func (*TaskAttempt) TableName() string { return "task_attempt" }
+
+func (a *TaskAttempt) Insert() error {
+	return db.Default().Create(a).Error
+}
+
+func FindTaskAttemptsByTaskIDs(taskIDs []uint64) ([]*TaskAttempt, error) {
+	var attempts []*TaskAttempt
+	err := db.Default().Order("id").Find(&attempts, "task_id in (?)", taskIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
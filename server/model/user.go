@@ -1,6 +1,8 @@
 package model
 
 import (
+	"strings"
+
 	"github.com/tidyoux/router/common/db"
 	"github.com/tidyoux/router/common/types"
 )
@@ -9,30 +11,102 @@ type User struct {
 	Model
 
 	Name     string `gorm:"size:32;unique_index"`
-	Password string `gorm:"size:64"`
+	Password string `gorm:"size:128"`
 	Detail   string `gorm:"type:text"`
 	Status   int8   `gorm:"type:tinyint;index"`
+	Role     string `gorm:"size:16;index;default:'operator'"`
+
+	// MustChangePassword is true if the password is set by someone else, the user can do
+	// nothing but change it then.
+	MustChangePassword bool
+	PasswordHistory    string `gorm:"type:text"` // comma separated hashes of the previous passwords, newest first.
+
+	// Provider and Subject identify a user created by an external auth provider,
+	// they are empty for local users.
+	Provider string `gorm:"size:32;index:idx_user_subject"`
+	Subject  string `gorm:"size:255;index:idx_user_subject"`
+
+	// TOTPSecret is set when the user starts to enroll 2FA, it takes effect after TOTPEnabled.
+	TOTPSecret  string `gorm:"size:64"`
+	TOTPEnabled bool
+	// TOTPLastStep is the time step of the last accepted code, codes not after it are replays.
+	TOTPLastStep  int64
+	RecoveryCodes string `gorm:"type:text"` // comma separated hashes of the unused recovery codes.
 }
 
 func NewUser(name, password string) *User {
 	return &User{
 		Name:     name,
 		Password: password,
+		Role:     types.RoleOperator,
 	}
 }
 
+func NewExternalUser(name, password, role, provider, subject string) *User {
+	return &User{
+		Name:     name,
+		Password: password,
+		Status:   types.UserEnabled,
+		Role:     role,
+		Provider: provider,
+		Subject:  subject,
+	}
+}
+
+// External reports whether the user is created by an external auth provider.
+func (u *User) External() bool {
+	return len(u.Provider) > 0
+}
+
 func (*User) TableName() string { return "user" }
 
 func (u *User) Insert() error {
 	return db.Default().Create(u).Error
 }
 
+func (u *User) UpdateName(name string) error {
+	return u.update(M{
+		"name": name,
+	})
+}
+
 func (u *User) UpdatePassword(password string) error {
 	return u.update(M{
 		"password": password,
 	})
 }
 
+// ChangePassword sets the password chosen by the user, history is the new PasswordHistory.
+func (u *User) ChangePassword(password string, history []string) error {
+	return u.update(M{
+		"password":             password,
+		"password_history":     strings.Join(history, ","),
+		"must_change_password": false,
+	})
+}
+
+// ResetPassword sets a password the user must change after login.
+func (u *User) ResetPassword(password string) error {
+	return u.update(M{
+		"password":             password,
+		"must_change_password": true,
+	})
+}
+
+func (u *User) RequirePasswordChange() error {
+	return u.update(M{
+		"must_change_password": true,
+	})
+}
+
+func (u *User) PasswordHistoryHashes() []string {
+	if len(u.PasswordHistory) == 0 {
+		return nil
+	}
+
+	return strings.Split(u.PasswordHistory, ",")
+}
+
 func (u *User) UpdateDetail(detail string) error {
 	return u.update(M{
 		"detail": detail,
@@ -51,8 +125,81 @@ func (u *User) Disable() error {
 	})
 }
 
-func (u *User) IsAdmin() bool {
-	return u.Name == types.AdminUsername
+func (u *User) UpdateRole(role string) error {
+	return u.update(M{
+		"role": role,
+	})
+}
+
+// SetupTOTP replaces the user's 2FA with a new secret which is not enabled yet.
+func (u *User) SetupTOTP(secret string) error {
+	return u.update(M{
+		"totp_secret":    secret,
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+		"recovery_codes": "",
+	})
+}
+
+func (u *User) EnableTOTP(step int64, recoveryCodes []string) error {
+	return u.update(M{
+		"totp_enabled":   true,
+		"totp_last_step": step,
+		"recovery_codes": strings.Join(recoveryCodes, ","),
+	})
+}
+
+func (u *User) ResetTOTP() error {
+	return u.SetupTOTP("")
+}
+
+// UseTOTPStep records step as the last accepted one, it returns false
+// if a code of the step or a later one has been accepted already.
+func (u *User) UseTOTPStep(step int64) (bool, error) {
+	r := db.Default().Model(User{}).
+		Where("id = ? and totp_last_step < ?", u.ID, step).
+		Updates(M{"totp_last_step": step})
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return false, nil
+	}
+
+	u.TOTPLastStep = step
+	return true, nil
+}
+
+func (u *User) RecoveryCodeHashes() []string {
+	if len(u.RecoveryCodes) == 0 {
+		return nil
+	}
+
+	return strings.Split(u.RecoveryCodes, ",")
+}
+
+// UpdateRecoveryCodes replaces the recovery codes, it returns false if they
+// have been changed by others since the user was loaded.
+func (u *User) UpdateRecoveryCodes(recoveryCodes []string) (bool, error) {
+	codes := strings.Join(recoveryCodes, ",")
+	r := db.Default().Model(User{}).
+		Where("id = ? and recovery_codes = ?", u.ID, u.RecoveryCodes).
+		Updates(M{"recovery_codes": codes})
+	if r.Error != nil {
+		return false, r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return false, nil
+	}
+
+	u.RecoveryCodes = codes
+	return true, nil
+}
+
+func (u *User) Delete() error {
+	return db.Default().Delete(u).Error
 }
 
 func (u *User) update(values M) error {
@@ -79,6 +226,16 @@ func FindUserByID(id uint64) (*User, error) {
 	return &user, nil
 }
 
+func FindUserBySubject(provider, subject string) (*User, error) {
+	var user User
+	err := db.Default().First(&user, "provider = ? and subject = ?", provider, subject).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 func FindUserByName(name string) (*User, error) {
 	var user User
 	err := db.Default().First(&user, "name = ?", name).Error
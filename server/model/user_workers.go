@@ -11,12 +11,14 @@ type UserWorker struct {
 
 	UserID   uint64 `gorm:"index;unique_index:idx_user_worker"`
 	WorkerID uint64 `gorm:"index;unique_index:idx_user_worker"`
+	Level    int8   `gorm:"type:tinyint;default:3"`
 }
 
-func NewUserWorker(userID, workerID uint64) *UserWorker {
+func NewUserWorker(userID, workerID uint64, level int8) *UserWorker {
 	return &UserWorker{
 		UserID:   userID,
 		WorkerID: workerID,
+		Level:    level,
 	}
 }
 
@@ -26,6 +28,12 @@ func (u *UserWorker) Insert() error {
 	return db.Default().Create(u).Error
 }
 
+func (u *UserWorker) UpdateLevel(level int8) error {
+	return db.Default().Model(u).Updates(M{
+		"level": level,
+	}).Error
+}
+
 func (u *UserWorker) Delete() error {
 	return db.Default().Delete(u).Error
 }
@@ -86,6 +94,20 @@ func FindWorkerUsers(workerID uint64) ([]uint64, error) {
 	return userIDs, nil
 }
 
+func FindWorkerGrants(workerID uint64) ([]*UserWorker, error) {
+	var users []*UserWorker
+	err := db.Default().Order("user_id").Find(&users, "worker_id = ?", workerID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func DeleteUserWorkers(userID uint64) error {
+	return db.Default().Where("user_id = ?", userID).Delete(UserWorker{}).Error
+}
+
 func DeleteWorkerUsers(workerID uint64) error {
 	return db.Default().Where("worker_id = ?", workerID).Delete(UserWorker{}).Error
 }
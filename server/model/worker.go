@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/tidyoux/router/common/db"
 	"github.com/tidyoux/router/common/types"
 )
@@ -8,10 +10,21 @@ import (
 type Worker struct {
 	Model
 
-	Key    string `gorm:"size:64"`
+	Key    string `gorm:"size:128"` // hash of the key.
 	Name   string `gorm:"size:32;unique_index"`
 	Desc   string `gorm:"type:text"`
 	Status int8   `gorm:"type:tinyint;index"`
+
+	// OldKey is the hash of the key before rotation, it works until OldKeyExpiredAt.
+	OldKey          string `gorm:"size:128"`
+	OldKeyExpiredAt *time.Time
+
+	// MaxRunTime is the seconds an accepted task can run before it fails for timeout,
+	// 0 means the server's default.
+	MaxRunTime int64
+
+	// LeaseExpiredPolicy decides what happens to a task whose lease expires.
+	LeaseExpiredPolicy int8 `gorm:"type:tinyint"`
 }
 
 func NewWorker(key, name, desc string) *Worker {
@@ -41,6 +54,14 @@ func (w *Worker) Disable() error {
 	})
 }
 
+func (w *Worker) UpdateKey(key, oldKey string, oldKeyExpiredAt *time.Time) error {
+	return w.update(M{
+		"key":                key,
+		"old_key":            oldKey,
+		"old_key_expired_at": oldKeyExpiredAt,
+	})
+}
+
 func (w *Worker) UpdateName(name string) error {
 	return w.update(M{
 		"name": name,
@@ -53,6 +74,18 @@ func (w *Worker) UpdateDesc(desc string) error {
 	})
 }
 
+func (w *Worker) UpdateMaxRunTime(seconds int64) error {
+	return w.update(M{
+		"max_run_time": seconds,
+	})
+}
+
+func (w *Worker) UpdateLeaseExpiredPolicy(policy int8) error {
+	return w.update(M{
+		"lease_expired_policy": policy,
+	})
+}
+
 func (w *Worker) Delete() error {
 	return db.Default().Delete(w).Error
 }
@@ -0,0 +1,361 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/config"
+)
+
+const (
+	oidcHTTPTimeout = time.Second * 10
+	// oidcClockSkew is the tolerance of the expiry of id tokens.
+	oidcClockSkew = time.Minute
+)
+
+var errInvalidIDToken = fmt.Errorf("invalid id token")
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcJWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// OIDCProvider authenticates users with the authorization code flow of OpenID Connect,
+// PKCE is always used. Only RS256 signed id tokens are accepted.
+type OIDCProvider struct {
+	cfg    *config.OIDCProvider
+	client *http.Client
+
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+func NewOIDCProvider(cfg *config.OIDCProvider) *OIDCProvider {
+	return &OIDCProvider{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: oidcHTTPTimeout,
+		},
+	}
+}
+
+func (p *OIDCProvider) Name() string { return p.cfg.Name }
+
+func (p *OIDCProvider) AuthURL(state, nonce, verifier string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+func (p *OIDCProvider) Exchange(code, nonce, verifier string) (*Identity, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("new oidc token request failed, %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = p.doJSON(req, &token)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request failed, %v", err)
+	}
+
+	if len(token.IDToken) == 0 {
+		return nil, fmt.Errorf("oidc token response has no id token")
+	}
+
+	claims, err := p.verifyIDToken(d, token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, _ := claims["nonce"].(string); s != nonce {
+		return nil, errInvalidIDToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	if len(subject) == 0 {
+		return nil, errInvalidIDToken
+	}
+
+	username, _ := claims[p.cfg.UsernameClaim].(string)
+	if len(username) == 0 {
+		username = subject
+	}
+
+	var groups []string
+	switch v := claims[p.cfg.GroupsClaim].(type) {
+	case string:
+		groups = []string{v}
+	case []interface{}:
+		for _, g := range v {
+			if g, ok := g.(string); ok {
+				groups = append(groups, g)
+			}
+		}
+	}
+
+	return &Identity{
+		Provider: p.cfg.Name,
+		Subject:  subject,
+		Username: username,
+		Role:     p.mapRole(groups),
+	}, nil
+}
+
+// mapRole returns the highest role mapped by groups, the default role if none is mapped.
+func (p *OIDCProvider) mapRole(groups []string) string {
+	best := -1
+	for _, g := range groups {
+		role, ok := p.cfg.GroupRoles[g]
+		if !ok {
+			continue
+		}
+
+		for i, r := range types.Roles {
+			if r == role && (best < 0 || i < best) {
+				best = i
+			}
+		}
+	}
+
+	if best < 0 {
+		return p.cfg.DefaultRole
+	}
+
+	return types.Roles[best]
+}
+
+func (p *OIDCProvider) verifyIDToken(d *oidcDiscovery, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidIDToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil || header.Alg != "RS256" {
+		return nil, errInvalidIDToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidIDToken
+	}
+
+	key, err := p.getKey(d, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+		return nil, errInvalidIDToken
+	}
+
+	var claims map[string]interface{}
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, errInvalidIDToken
+	}
+
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, errInvalidIDToken
+	}
+
+	audOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOK = aud == p.cfg.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == p.cfg.ClientID {
+				audOK = true
+			}
+		}
+	}
+
+	if !audOK {
+		return nil, errInvalidIDToken
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().Add(-oidcClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errInvalidIDToken
+	}
+
+	return claims, nil
+}
+
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("new oidc discovery request failed, %v", err)
+	}
+
+	var d oidcDiscovery
+	err = p.doJSON(req, &d)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery of %s failed, %v", p.cfg.Name, err)
+	}
+
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery of %s failed, issuer mismatch %s", p.cfg.Name, d.Issuer)
+	}
+
+	if len(d.AuthorizationEndpoint) == 0 || len(d.TokenEndpoint) == 0 || len(d.JWKSURI) == 0 {
+		return nil, fmt.Errorf("oidc discovery of %s failed, endpoints missing", p.cfg.Name)
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// getKey returns the signing key of kid, the keys are refetched for an unknown kid,
+// since providers rotate their keys.
+func (p *OIDCProvider) getKey(d *oidcDiscovery, kid string) (*rsa.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new oidc jwks request failed, %v", err)
+	}
+
+	var jwks struct {
+		Keys []*oidcJWK `json:"keys"`
+	}
+
+	err = p.doJSON(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("oidc jwks request failed, %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.keys = keys
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, errInvalidIDToken
+	}
+
+	return key, nil
+}
+
+func (p *OIDCProvider) doJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,557 @@
+package server_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidyoux/router/common/db"
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server"
+	"github.com/tidyoux/router/server/config"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	testClientID     = "router"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://router.test/"
+)
+
+type fakeUser struct {
+	subject  string
+	username string
+	groups   []string
+}
+
+type fakeCode struct {
+	user      *fakeUser
+	nonce     string
+	challenge string
+}
+
+// fakeIssuer is an in-process OpenID Connect provider, it logs in fakeIssuer.user
+// at the authorization endpoint without asking.
+type fakeIssuer struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+	kid string
+
+	mutex sync.Mutex
+	user  *fakeUser
+	codes map[string]*fakeCode
+	// claims overrides the claims of the next id token.
+	claims map[string]interface{}
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIssuer{
+		key:   key,
+		kid:   "k1",
+		codes: make(map[string]*fakeCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	mux.HandleFunc("/jwks", f.jwks)
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 f.URL,
+		"authorization_endpoint": f.URL + "/authorize",
+		"token_endpoint":         f.URL + "/token",
+		"jwks_uri":               f.URL + "/jwks",
+	})
+}
+
+func (f *fakeIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL ||
+		q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	f.mutex.Lock()
+	code := server.GenToken()
+	f.codes[code] = &fakeCode{
+		user:      f.user,
+		nonce:     q.Get("nonce"),
+		challenge: q.Get("code_challenge"),
+	}
+	f.mutex.Unlock()
+
+	http.Redirect(w, r, testRedirectURL+"?"+url.Values{
+		"code":  {code},
+		"state": {q.Get("state")},
+	}.Encode(), http.StatusFound)
+}
+
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		http.Error(w, "invalid client", http.StatusUnauthorized)
+		return
+	}
+
+	f.mutex.Lock()
+	code, ok := f.codes[r.PostFormValue("code")]
+	delete(f.codes, r.PostFormValue("code"))
+	extra := f.claims
+	f.claims = nil
+	f.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != testRedirectURL ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != code.challenge {
+		http.Error(w, "invalid grant", http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":                f.URL,
+		"aud":                testClientID,
+		"sub":                code.user.subject,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              code.nonce,
+		"preferred_username": code.user.username,
+		"groups":             code.user.groups,
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": server.GenToken(),
+		"token_type":   "Bearer",
+		"id_token":     f.sign(claims),
+	})
+}
+
+func (f *fakeIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": f.kid,
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (f *fakeIssuer) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": f.kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestProvider(f *fakeIssuer) *server.OIDCProvider {
+	return server.NewOIDCProvider(&config.OIDCProvider{
+		Name:          "test",
+		Issuer:        f.URL,
+		ClientID:      testClientID,
+		ClientSecret:  testClientSecret,
+		RedirectURL:   testRedirectURL,
+		Scopes:        []string{"openid", "profile"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		GroupRoles: map[string]string{
+			"ops":    types.RoleOperator,
+			"admins": types.RoleAdmin,
+			"audit":  types.RoleAuditor,
+		},
+		DefaultRole: types.RoleViewer,
+	})
+}
+
+// login runs a redirect login of p like a browser, it returns the state and code
+// the browser comes back with.
+func login(t *testing.T, p server.RedirectAuthProvider, state, nonce, verifier string) (string, string) {
+	authURL, err := p.AuthURL(state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return follow(t, authURL)
+}
+
+// follow sends the browser to authURL, it returns the state and code of the redirect back.
+func follow(t *testing.T, authURL string) (string, string) {
+	browser := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := browser.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status %s", resp.Status)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(location.String(), testRedirectURL) {
+		t.Fatalf("redirected to %s", location)
+	}
+
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+
+	p := newTestProvider(f)
+
+	cases := []struct {
+		groups []string
+		role   string
+	}{
+		{nil, types.RoleViewer},
+		{[]string{"ops"}, types.RoleOperator},
+		{[]string{"ops", "unknown", "admins"}, types.RoleAdmin},
+		{[]string{"audit", "ops"}, types.RoleAuditor},
+	}
+
+	for _, c := range cases {
+		f.user = &fakeUser{subject: "u-1", username: "alice", groups: c.groups}
+
+		state, nonce, verifier := server.GenToken(), server.GenToken(), server.GenToken()
+		gotState, code := login(t, p, state, nonce, verifier)
+		if gotState != state {
+			t.Fatalf("state %s, want %s", gotState, state)
+		}
+
+		identity, err := p.Exchange(code, nonce, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if identity.Provider != "test" || identity.Subject != "u-1" || identity.Username != "alice" {
+			t.Fatalf("unexpected identity %+v", identity)
+		}
+
+		if identity.Role != c.role {
+			t.Fatalf("groups %v got role %s, want %s", c.groups, identity.Role, c.role)
+		}
+	}
+}
+
+func TestOIDCLoginNoDefaultRole(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+
+	p := server.NewOIDCProvider(&config.OIDCProvider{
+		Name:          "test",
+		Issuer:        f.URL,
+		ClientID:      testClientID,
+		ClientSecret:  testClientSecret,
+		RedirectURL:   testRedirectURL,
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		GroupRoles:    map[string]string{"ops": types.RoleOperator},
+	})
+
+	f.user = &fakeUser{subject: "u-2", username: "bob", groups: []string{"sales"}}
+
+	nonce, verifier := server.GenToken(), server.GenToken()
+	_, code := login(t, p, server.GenToken(), nonce, verifier)
+
+	identity, err := p.Exchange(code, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server refuses identities without a role.
+	if identity.Role != "" {
+		t.Fatalf("unmapped groups got role %s", identity.Role)
+	}
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+
+	p := newTestProvider(f)
+	f.user = &fakeUser{subject: "u-1", username: "alice"}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		run  func(nonce, verifier string) (string, string, string)
+	}{
+		{"wrong nonce", func(nonce, verifier string) (string, string, string) {
+			_, code := login(t, p, server.GenToken(), nonce, verifier)
+			return code, server.GenToken(), verifier
+		}},
+		{"wrong verifier", func(nonce, verifier string) (string, string, string) {
+			_, code := login(t, p, server.GenToken(), nonce, verifier)
+			return code, nonce, server.GenToken()
+		}},
+		{"replayed code", func(nonce, verifier string) (string, string, string) {
+			_, code := login(t, p, server.GenToken(), nonce, verifier)
+			if _, err := p.Exchange(code, nonce, verifier); err != nil {
+				t.Fatal(err)
+			}
+			return code, nonce, verifier
+		}},
+		{"wrong audience", func(nonce, verifier string) (string, string, string) {
+			_, code := login(t, p, server.GenToken(), nonce, verifier)
+			f.claims = map[string]interface{}{"aud": "someone-else"}
+			return code, nonce, verifier
+		}},
+		{"wrong issuer", func(nonce, verifier string) (string, string, string) {
+			_, code := login(t, p, server.GenToken(), nonce, verifier)
+			f.claims = map[string]interface{}{"iss": "http://evil.test"}
+			return code, nonce, verifier
+		}},
+		{"expired", func(nonce, verifier string) (string, string, string) {
+			_, code := login(t, p, server.GenToken(), nonce, verifier)
+			f.claims = map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}
+			return code, nonce, verifier
+		}},
+		{"wrong key", func(nonce, verifier string) (string, string, string) {
+			_, code := login(t, p, server.GenToken(), nonce, verifier)
+			// The cached key of the kid no longer matches.
+			f.key, other = other, f.key
+			return code, nonce, verifier
+		}},
+	}
+
+	for _, c := range cases {
+		code, nonce, verifier := c.run(server.GenToken(), server.GenToken())
+		if _, err := p.Exchange(code, nonce, verifier); err == nil {
+			t.Fatalf("%s: exchange succeeded", c.name)
+		}
+	}
+}
+
+// newTestServer returns a server with the redirect provider p. Users and sessions
+// are kept in the MySQL of ROUTER_TEST_DSN, the test is skipped without it.
+func newTestServer(t *testing.T, p server.RedirectAuthProvider) *server.Server {
+	dsn := os.Getenv("ROUTER_TEST_DSN")
+	if len(dsn) == 0 {
+		t.Skip("ROUTER_TEST_DSN is not set")
+	}
+
+	if db.Default() == nil {
+		conn, err := db.New(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = model.Init(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := server.New(&config.Config{})
+	s.RegisterAuthProvider(p)
+	return s
+}
+
+// externalLogin logs in with the test provider of s, the user is who f logs in.
+func externalLogin(t *testing.T, s *server.Server) (uint64, error) {
+	authURL, err := s.StartExternalLogin("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, code := follow(t, authURL)
+	userID, token, err := s.FinishExternalLogin(state, code, "127.0.0.1", "test")
+	if err == nil && len(token) == 0 {
+		t.Fatal("empty session token")
+	}
+
+	return userID, err
+}
+
+func findTestUser(t *testing.T, id uint64) *model.User {
+	user, err := model.FindUserByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestExternalLogin(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+
+	s := newTestServer(t, newTestProvider(f))
+
+	// Names are random so that the test can run again on the same db.
+	name := "ext-" + server.GenToken()[:8]
+	f.user = &fakeUser{subject: server.GenToken(), username: name, groups: []string{"ops"}}
+
+	userID, err := externalLogin(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := findTestUser(t, userID)
+	if user.Name != name || user.Role != types.RoleOperator || !user.External() ||
+		user.Provider != "test" || user.Subject != f.user.subject {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	// The role follows the groups at each login.
+	f.user.groups = []string{"admins"}
+	id, err := externalLogin(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != userID {
+		t.Fatalf("login again got user %d, want %d", id, userID)
+	}
+
+	if user := findTestUser(t, userID); user.Role != types.RoleAdmin {
+		t.Fatalf("role %s, want %s", user.Role, types.RoleAdmin)
+	}
+}
+
+func TestExternalLoginState(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+
+	s := newTestServer(t, newTestProvider(f))
+	f.user = &fakeUser{subject: server.GenToken(), username: "ext-" + server.GenToken()[:8]}
+
+	authURL, err := s.StartExternalLogin("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, code := follow(t, authURL)
+	if _, _, err := s.FinishExternalLogin(server.GenToken(), code, "", ""); err == nil {
+		t.Fatal("login with unknown state")
+	}
+
+	if _, _, err := s.FinishExternalLogin(state, code, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// A state can only be used once, even with a new code.
+	authURL, err = s.StartExternalLogin("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, code = follow(t, authURL)
+	if _, _, err := s.FinishExternalLogin(state, code, "", ""); err == nil {
+		t.Fatal("login with used state")
+	}
+
+	if _, err := s.StartExternalLogin("unknown"); err == nil {
+		t.Fatal("login with unknown provider")
+	}
+}
+
+func TestExternalLoginNameTaken(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+
+	s := newTestServer(t, newTestProvider(f))
+
+	name := "ext-" + server.GenToken()[:8]
+	localID, err := s.UserRegister(name, server.GenToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.user = &fakeUser{subject: server.GenToken(), username: name}
+	userID, err := externalLogin(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userID == localID {
+		t.Fatal("external user took over the local one")
+	}
+
+	if user := findTestUser(t, userID); user.Name != name+"-2" {
+		t.Fatalf("user name %s, want %s", user.Name, name+"-2")
+	}
+
+	if user := findTestUser(t, localID); user.External() {
+		t.Fatalf("local user changed to %+v", user)
+	}
+}
+
+func TestExternalLoginNoRole(t *testing.T) {
+	f := newFakeIssuer(t)
+	defer f.Close()
+
+	s := newTestServer(t, server.NewOIDCProvider(&config.OIDCProvider{
+		Name:          "test",
+		Issuer:        f.URL,
+		ClientID:      testClientID,
+		ClientSecret:  testClientSecret,
+		RedirectURL:   testRedirectURL,
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		GroupRoles:    map[string]string{"ops": types.RoleOperator},
+	}))
+
+	f.user = &fakeUser{subject: server.GenToken(), username: "ext-" + server.GenToken()[:8], groups: []string{"sales"}}
+
+	_, err := externalLogin(t, s)
+	if err == nil || err.Error() != "none of your groups is allowed to login" {
+		t.Fatalf("login without role got error %v", err)
+	}
+
+	if _, err := model.FindUserBySubject("test", f.user.subject); err != gorm.ErrRecordNotFound {
+		t.Fatalf("user created without role, %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/server/model"
+)
+
+const (
+	// oneTimePasswordLen is the length of the passwords set by ResetPassword.
+	oneTimePasswordLen = 16
+	// oneTimePasswordChars leaves out the characters easy to misread.
+	oneTimePasswordChars = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	errPasswordReused = fmt.Errorf("password has been used recently, please choose another one")
+)
+
+// ValidPassword checks the plain text password against the password rules.
+func (s *Server) ValidPassword(password string) error {
+	length := utf8.RuneCountInString(password)
+	if int64(length) < s.cfg.PasswordMinLength {
+		return fmt.Errorf("invalid user password length: %d, should >= %d",
+			length, s.cfg.PasswordMinLength)
+	}
+
+	var lower, upper, digit, symbol int64
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+
+	if classes := lower + upper + digit + symbol; classes < s.cfg.PasswordCharClasses {
+		return fmt.Errorf("invalid user password, should have %d of lower case, upper case, digit and symbol characters",
+			s.cfg.PasswordCharClasses)
+	}
+
+	return nil
+}
+
+// ChangePassword sets the plain text password chosen by user, which can't be one of
+// the user's last passwords. It doesn't check the password rules, see ValidPassword.
+// Only the update holds the lock, checking the history may take several bcrypt rounds.
+func (s *Server) ChangePassword(user *model.User, password string) error {
+	// Clients send the digest of passwords to login.
+	digest := crypto.Sum([]byte(password)).String()
+
+	recent := append([]string{user.Password}, user.PasswordHistoryHashes()...)
+	if int64(len(recent)) > s.cfg.PasswordHistory {
+		recent = recent[:s.cfg.PasswordHistory]
+	}
+
+	for _, hash := range recent {
+		if ok, _ := crypto.VerifyPassword(hash, digest); ok {
+			return errPasswordReused
+		}
+	}
+
+	hash, err := crypto.HashPassword(digest)
+	if err != nil {
+		return fmt.Errorf("hash password failed, %v", err)
+	}
+
+	// The new password is the first of the last passwords.
+	if len(recent) > 0 && int64(len(recent)) == s.cfg.PasswordHistory {
+		recent = recent[:len(recent)-1]
+	}
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	err = user.ChangePassword(hash, recent)
+	if err != nil {
+		return fmt.Errorf("db update user password failed, %v", err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a random password for user and returns it, the user must change
+// it after login.
+func (s *Server) ResetPassword(user *model.User) (string, error) {
+	password := genOneTimePassword()
+	hash, err := crypto.HashPassword(crypto.Sum([]byte(password)).String())
+	if err != nil {
+		return "", fmt.Errorf("hash password failed, %v", err)
+	}
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	err = user.ResetPassword(hash)
+	if err != nil {
+		return "", fmt.Errorf("db reset user password failed, %v", err)
+	}
+
+	return password, nil
+}
+
+func genOneTimePassword() string {
+	// Bytes not less than max are dropped, so that each character is equally likely.
+	max := 256 - 256%len(oneTimePasswordChars)
+
+	password := make([]byte, 0, oneTimePasswordLen)
+	for len(password) < oneTimePasswordLen {
+		for _, b := range crypto.RandBytes(oneTimePasswordLen) {
+			if int(b) < max && len(password) < oneTimePasswordLen {
+				password = append(password, oneTimePasswordChars[int(b)%len(oneTimePasswordChars)])
+			}
+		}
+	}
+
+	return string(password)
+}
@@ -0,0 +1,460 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+const MaxPipelineNodeNameLen = 32
+
+// pipelineTaskLostAfter is how long a running node can wait for its task to be
+// recorded, the server may have stopped between sending the task and recording it.
+const pipelineTaskLostAfter = time.Minute
+
+// SortPipelineNodes validates the nodes of a pipeline and returns them in the order
+// they can be sent, each node after the nodes it needs.
+func SortPipelineNodes(nodes []*types.PipelineNode) ([]*types.PipelineNode, error) {
+	if len(nodes) == 0 || len(nodes) > types.MaxPipelineNodes {
+		return nil, fmt.Errorf("invalid pipeline node count: %d, should be in [1, %d]",
+			len(nodes), types.MaxPipelineNodes)
+	}
+
+	indexes := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		if err := validPipelineNodeName(node.Name); err != nil {
+			return nil, err
+		}
+
+		if _, ok := indexes[node.Name]; ok {
+			return nil, fmt.Errorf("duplicate pipeline node %q", node.Name)
+		}
+
+		indexes[node.Name] = i
+	}
+
+	// Kahn's algorithm, the nodes ready at the same time keep their order.
+	var (
+		waits     = make([]int, len(nodes))
+		followers = make([][]int, len(nodes))
+	)
+	for i, node := range nodes {
+		needs := make(map[string]bool, len(node.Needs))
+		for _, need := range node.Needs {
+			j, ok := indexes[need]
+			if !ok {
+				return nil, fmt.Errorf("pipeline node %q needs %q which not exist", node.Name, need)
+			}
+
+			if needs[need] || j == i {
+				return nil, fmt.Errorf("pipeline node %q needs %q more than once or itself", node.Name, need)
+			}
+
+			needs[need] = true
+			waits[i]++
+			followers[j] = append(followers[j], i)
+		}
+	}
+
+	sorted := make([]*types.PipelineNode, 0, len(nodes))
+	for len(sorted) < len(nodes) {
+		ready := -1
+		for i := range nodes {
+			if waits[i] == 0 {
+				ready = i
+				break
+			}
+		}
+
+		if ready < 0 {
+			return nil, fmt.Errorf("pipeline nodes need each other in a cycle")
+		}
+
+		waits[ready] = -1
+		for _, j := range followers[ready] {
+			waits[j]--
+		}
+
+		sorted = append(sorted, nodes[ready])
+	}
+
+	return sorted, nil
+}
+
+// validPipelineNodeName checks the node name, which is joined with others by commas
+// in the needs of a run node.
+func validPipelineNodeName(name string) error {
+	if len(name) == 0 || len(name) > MaxPipelineNodeNameLen {
+		return fmt.Errorf("invalid pipeline node name length: %d, should be in [1, %d]",
+			len(name), MaxPipelineNodeNameLen)
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return fmt.Errorf("invalid pipeline node name %q, should be letters, digits, '-', '_' or '.'", name)
+		}
+	}
+
+	return nil
+}
+
+// StartPipelineRun starts a run of the pipeline as the user, nodes are the sorted nodes
+// of the pipeline. The first tasks are sent at the next advance.
+func (s *Server) StartPipelineRun(pipeline *model.Pipeline, nodes []*types.PipelineNode, user *model.User) (*model.PipelineRun, error) {
+	runNodes := make([]*model.PipelineRunNode, 0, len(nodes))
+	for _, node := range nodes {
+		runNodes = append(runNodes, &model.PipelineRunNode{
+			Name:     node.Name,
+			WorkerID: node.WorkerID,
+			Params:   node.Params,
+			Needs:    strings.Join(node.Needs, ","),
+			Retries:  node.Retries,
+			Priority: node.Priority,
+			Status:   types.PipelineNodePending,
+		})
+	}
+
+	run := model.NewPipelineRun(pipeline, uint64(user.ID), user.Name)
+	err := run.Insert(runNodes)
+	if err != nil {
+		return nil, fmt.Errorf("db insert pipeline run failed, %v", err)
+	}
+
+	return run, nil
+}
+
+// AdvancePipelineRuns moves all running pipeline runs on, see AdvancePipelineRun.
+func (s *Server) AdvancePipelineRuns(authorize TaskAuthorizer) error {
+	runs, err := model.FindRunningPipelineRuns()
+	if err != nil {
+		return fmt.Errorf("db find running pipeline runs failed, %v", err)
+	}
+
+	for _, run := range runs {
+		err := s.AdvancePipelineRun(run, authorize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AdvancePipelineRun moves the running run on: it records the finished tasks of
+// the run, sends the tasks of the nodes whose needs have all succeeded, skips
+// or cancels the others by the failure policy, and finishes the run once no node
+// is pending or running.
+func (s *Server) AdvancePipelineRun(run *model.PipelineRun, authorize TaskAuthorizer) error {
+	nodes, err := model.FindPipelineRunNodes(uint64(run.ID))
+	if err != nil {
+		return fmt.Errorf("db find pipeline run nodes failed, %v", err)
+	}
+
+	statuses := make(map[string]int8, len(nodes))
+	for _, node := range nodes {
+		if node.Status == types.PipelineNodeRunning {
+			err := s.syncPipelineNode(node)
+			if err != nil {
+				return err
+			}
+		}
+
+		statuses[node.Name] = node.Status
+	}
+
+	failed := false
+	for _, node := range nodes {
+		if node.Status == types.PipelineNodeFailed || node.Status == types.PipelineNodeCanceled {
+			failed = true
+		}
+	}
+
+	// The nodes are sorted, so a skipped node is seen by the nodes which need it.
+	for _, node := range nodes {
+		if node.Status != types.PipelineNodePending {
+			continue
+		}
+
+		status, detail := int8(types.PipelineNodePending), ""
+		if run.CancelRequested || (failed && run.FailurePolicy != types.PipelineFailContinue) {
+			status, detail = types.PipelineNodeSkipped, "skipped, pipeline stopped"
+			if run.CancelRequested {
+				detail = "skipped, pipeline canceled"
+			}
+		} else {
+			ready := true
+			for _, need := range splitPipelineNeeds(node.Needs) {
+				switch statuses[need] {
+				case types.PipelineNodeSuccess:
+				case types.PipelineNodeFailed, types.PipelineNodeCanceled, types.PipelineNodeSkipped:
+					status, detail = types.PipelineNodeSkipped, fmt.Sprintf("skipped, %s not succeeded", need)
+				default:
+					ready = false
+				}
+			}
+
+			if ready && status == types.PipelineNodePending {
+				status, detail, err = s.releasePipelineNode(run, node, authorize)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if status == types.PipelineNodePending || status == types.PipelineNodeRunning {
+			node.Status = status
+			statuses[node.Name] = status
+			continue
+		}
+
+		ok, err := node.Finish(types.PipelineNodePending, status, detail)
+		if err != nil {
+			return fmt.Errorf("db finish pipeline node failed, %v", err)
+		}
+
+		if ok {
+			node.Status = status
+			statuses[node.Name] = status
+			if status == types.PipelineNodeFailed {
+				failed = true
+			}
+		}
+	}
+
+	if run.CancelRequested || (failed && run.FailurePolicy == types.PipelineFailCancel) {
+		for _, node := range nodes {
+			if node.Status == types.PipelineNodeRunning && node.TaskID > 0 {
+				err := s.cancelPipelineTask(node)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return s.finishPipelineRun(run, nodes)
+}
+
+// syncPipelineNode finishes the running node if its task has finished.
+func (s *Server) syncPipelineNode(node *model.PipelineRunNode) error {
+	if node.TaskID == 0 {
+		task, err := model.FindTaskByPipelineNodeID(uint64(node.ID))
+		if err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return fmt.Errorf("db find task by pipeline node failed, %v", err)
+			}
+
+			if time.Since(node.UpdatedAt) < pipelineTaskLostAfter {
+				return nil
+			}
+
+			return s.finishPipelineNode(node, types.PipelineNodeFailed, "task lost before sent")
+		}
+
+		err = node.SetTask(uint64(task.ID))
+		if err != nil {
+			return fmt.Errorf("db set pipeline node task failed, %v", err)
+		}
+		node.TaskID = uint64(task.ID)
+	}
+
+	task, err := model.FindTaskByID(node.TaskID)
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return fmt.Errorf("db find task failed, %v", err)
+		}
+
+		return s.finishPipelineNode(node, types.PipelineNodeFailed, "task not exist")
+	}
+
+	switch task.Status {
+	case types.TaskSuccess:
+		return s.finishPipelineNode(node, types.PipelineNodeSuccess, "")
+	case types.TaskFailed:
+		return s.finishPipelineNode(node, types.PipelineNodeFailed, task.Detail)
+	case types.TaskCanceled:
+		return s.finishPipelineNode(node, types.PipelineNodeCanceled, task.Detail)
+	}
+
+	return nil
+}
+
+func (s *Server) finishPipelineNode(node *model.PipelineRunNode, status int8, detail string) error {
+	ok, err := node.Finish(types.PipelineNodeRunning, status, detail)
+	if err != nil {
+		return fmt.Errorf("db finish pipeline node failed, %v", err)
+	}
+
+	if ok {
+		node.Status = status
+	}
+
+	return nil
+}
+
+// releasePipelineNode sends the task of the node as the runner of the run, or returns
+// the failed status and the reason why it can't be sent. A node sends one task at most.
+func (s *Server) releasePipelineNode(run *model.PipelineRun, node *model.PipelineRunNode, authorize TaskAuthorizer) (int8, string, error) {
+	failed := func(reason string) (int8, string, error) {
+		return types.PipelineNodeFailed, "not sent, " + reason, nil
+	}
+
+	user, err := model.FindUserByID(run.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return failed("runner not exist")
+		}
+
+		return 0, "", fmt.Errorf("db find user failed, %v", err)
+	}
+
+	if user.Status != types.UserEnabled {
+		return failed("runner disabled")
+	}
+
+	worker, err := model.FindWorkerByID(node.WorkerID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return failed("worker not exist")
+		}
+
+		return 0, "", fmt.Errorf("db find worker failed, %v", err)
+	}
+
+	if worker.Status != types.WorkerEnabled {
+		return failed("worker disabled")
+	}
+
+	if err := authorize(user, node.WorkerID); err != nil {
+		return failed(err.Error())
+	}
+
+	ok, err := node.Release()
+	if err != nil {
+		return 0, "", fmt.Errorf("db release pipeline node failed, %v", err)
+	}
+
+	if !ok {
+		// Released by someone else, it is synced at the next advance.
+		return types.PipelineNodeRunning, "", nil
+	}
+
+	// The runner's role may allow a lower priority now.
+	priority := node.Priority
+	if maxPriority := s.MaxTaskPriority(user.Role); priority > maxPriority {
+		priority = maxPriority
+	}
+
+	task := model.NewTask(run.UserID, node.WorkerID, node.Params)
+	task.MaxAttempts += node.Retries
+	task.Priority = priority
+	task.PipelineRunID = uint64(run.ID)
+	task.PipelineNodeID = uint64(node.ID)
+	err = task.Insert()
+	if err != nil {
+		return 0, "", fmt.Errorf("db insert task failed, %v", err)
+	}
+
+	err = node.SetTask(uint64(task.ID))
+	if err != nil {
+		return 0, "", fmt.Errorf("db set pipeline node task failed, %v", err)
+	}
+	node.TaskID = uint64(task.ID)
+
+	log.Infof("pipeline run (id: %d, pipeline: %d) node %s sent task %d to worker %d",
+		run.ID, run.PipelineID, node.Name, task.ID, node.WorkerID)
+
+	return types.PipelineNodeRunning, "", nil
+}
+
+// cancelPipelineTask cancels the task of the running node, the node finishes
+// when the task does.
+func (s *Server) cancelPipelineTask(node *model.PipelineRunNode) error {
+	task, err := model.FindTaskByID(node.TaskID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+
+		return fmt.Errorf("db find task failed, %v", err)
+	}
+
+	// The task may be accepted between the two updates, so both are tried.
+	ok, err := task.Cancel("canceled with its pipeline before accepted")
+	if err != nil {
+		return fmt.Errorf("db cancel task failed, %v", err)
+	}
+
+	if ok {
+		return s.finishPipelineNode(node, types.PipelineNodeCanceled, "canceled with its pipeline before accepted")
+	}
+
+	if task.CancelRequested {
+		return nil
+	}
+
+	_, err = task.RequestCancel()
+	if err != nil {
+		return fmt.Errorf("db request task cancel failed, %v", err)
+	}
+
+	return nil
+}
+
+// finishPipelineRun finishes the run if none of its nodes is pending or running.
+func (s *Server) finishPipelineRun(run *model.PipelineRun, nodes []*model.PipelineRunNode) error {
+	status := int8(types.PipelineSuccess)
+	for _, node := range nodes {
+		switch node.Status {
+		case types.PipelineNodePending, types.PipelineNodeRunning:
+			return nil
+		case types.PipelineNodeSuccess:
+		default:
+			status = types.PipelineFailed
+		}
+	}
+
+	if run.CancelRequested && status != types.PipelineSuccess {
+		status = types.PipelineCanceled
+	}
+
+	ok, err := run.Finish(status)
+	if err != nil {
+		return fmt.Errorf("db finish pipeline run failed, %v", err)
+	}
+
+	if !ok {
+		return nil
+	}
+
+	counts := make(map[int8]int, len(nodes))
+	for _, node := range nodes {
+		counts[node.Status]++
+	}
+
+	log.Infof("pipeline run (id: %d, pipeline: %d) finished with status %d", run.ID, run.PipelineID, status)
+
+	target := fmt.Sprintf("pipeline_run:%d", run.ID)
+	summary := fmt.Sprintf(`{"pipeline_id":%d,"user_id":%d,"status":%d,"success":%d,"failed":%d,"canceled":%d,"skipped":%d}`,
+		run.PipelineID, run.UserID, status, counts[types.PipelineNodeSuccess], counts[types.PipelineNodeFailed],
+		counts[types.PipelineNodeCanceled], counts[types.PipelineNodeSkipped])
+	event := model.NewAuditEvent(0, "", 0, "pipeline-run-finish", target, summary, "", "")
+	if err := event.Insert(); err != nil {
+		log.Errorf("db insert audit event pipeline-run-finish failed, %v", err)
+	}
+
+	return nil
+}
+
+func splitPipelineNeeds(needs string) []string {
+	if len(needs) == 0 {
+		return nil
+	}
+
+	return strings.Split(needs, ",")
+}
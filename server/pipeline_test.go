@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server"
+)
+
+func TestSortPipelineNodes(t *testing.T) {
+	nodes := []*types.PipelineNode{
+		{Name: "smoke", WorkerID: 4, Needs: []string{"deploy-b", "deploy-c"}},
+		{Name: "deploy-b", WorkerID: 2, Needs: []string{"build"}},
+		{Name: "deploy-c", WorkerID: 3, Needs: []string{"build"}},
+		{Name: "build", WorkerID: 1},
+	}
+
+	sorted, err := server.SortPipelineNodes(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"build", "deploy-b", "deploy-c", "smoke"}
+	for i, node := range sorted {
+		if node.Name != want[i] {
+			t.Fatalf("node %d got %s, want %s", i, node.Name, want[i])
+		}
+	}
+}
+
+func TestSortPipelineNodesInvalid(t *testing.T) {
+	for name, nodes := range map[string][]*types.PipelineNode{
+		"empty": nil,
+		"no name": {
+			{WorkerID: 1},
+		},
+		"comma in name": {
+			{Name: "a,b", WorkerID: 1},
+		},
+		"duplicate": {
+			{Name: "a", WorkerID: 1},
+			{Name: "a", WorkerID: 2},
+		},
+		"unknown need": {
+			{Name: "a", WorkerID: 1, Needs: []string{"b"}},
+		},
+		"need itself": {
+			{Name: "a", WorkerID: 1, Needs: []string{"a"}},
+		},
+		"cycle": {
+			{Name: "a", WorkerID: 1, Needs: []string{"c"}},
+			{Name: "b", WorkerID: 1, Needs: []string{"a"}},
+			{Name: "c", WorkerID: 1, Needs: []string{"b"}},
+		},
+	} {
+		if _, err := server.SortPipelineNodes(nodes); err == nil {
+			t.Fatalf("%s: nodes sorted", name)
+		}
+	}
+
+	nodes := make([]*types.PipelineNode, 0, types.MaxPipelineNodes+1)
+	for i := 0; i <= types.MaxPipelineNodes; i++ {
+		nodes = append(nodes, &types.PipelineNode{Name: string(rune('a'+i%26)) + string(rune('a'+i/26)), WorkerID: 1})
+	}
+
+	if _, err := server.SortPipelineNodes(nodes); err == nil {
+		t.Fatal("too many nodes sorted")
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/tidyoux/goutils/service"
+)
+
+// Pipeliner moves the running pipeline runs on, it runs as a service worker.
+type Pipeliner struct {
+	service.SimpleWorker
+	s         *Server
+	authorize TaskAuthorizer
+}
+
+func NewPipeliner(s *Server, authorize TaskAuthorizer) *Pipeliner {
+	return &Pipeliner{
+		s:         s,
+		authorize: authorize,
+	}
+}
+
+func (w *Pipeliner) Name() string { return "pipeliner" }
+
+func (w *Pipeliner) Work() {
+	err := w.s.AdvancePipelineRuns(w.authorize)
+	if err != nil {
+		log.Errorf("advance pipeline runs failed, %v", err)
+	}
+}
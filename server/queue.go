@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tidyoux/router/server/model"
+)
+
+// MaxTaskPriority returns the highest task priority the role can send.
+func (s *Server) MaxTaskPriority(role string) int8 {
+	return s.cfg.TaskMaxPriorities[role]
+}
+
+// TaskQueue returns the queued tasks of the worker in the order they run: by priority,
+// then fairly between users, then by the time they joined the queue. Within a priority,
+// the users take turns, and a user who has tasks running waits for as many turns, so
+// that one user's backlog doesn't starve the others.
+//
+// Delayed tasks join the queue when they are due, so they are ordered by their run time
+// rather than when they were sent.
+func (s *Server) TaskQueue(workerID uint64) ([]*model.Task, error) {
+	tasks, err := model.FindQueuedTasksByWorkerID(workerID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("db find queued tasks failed, %v", err)
+	}
+
+	running, err := model.FindAcceptedTasksByWorkerID(workerID)
+	if err != nil {
+		return nil, fmt.Errorf("db find accepted tasks failed, %v", err)
+	}
+
+	type userPriority struct {
+		userID   uint64
+		priority int8
+	}
+
+	var (
+		turns     = make(map[userPriority]int)
+		taskTurns = make(map[uint]int, len(tasks))
+	)
+	for _, t := range running {
+		turns[userPriority{t.UserID, t.Priority}]++
+	}
+
+	// tasks are in the order they joined the queue, so a user's earlier task gets an earlier turn.
+	for _, t := range tasks {
+		key := userPriority{t.UserID, t.Priority}
+		taskTurns[t.ID] = turns[key]
+		turns[key]++
+	}
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+
+		if taskTurns[a.ID] != taskTurns[b.ID] {
+			return taskTurns[a.ID] < taskTurns[b.ID]
+		}
+
+		if !a.QueuedAt().Equal(b.QueuedAt()) {
+			return a.QueuedAt().Before(b.QueuedAt())
+		}
+
+		return a.ID < b.ID
+	})
+
+	return tasks, nil
+}
+
+// QueuePosition returns the position of the queued task in the queue of its worker
+// counting from 1, 0 if the task is not queued.
+func (s *Server) QueuePosition(task *model.Task) (int64, error) {
+	tasks, err := s.TaskQueue(task.WorkerID)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, t := range tasks {
+		if t.ID == task.ID {
+			return int64(i + 1), nil
+		}
+	}
+
+	return 0, nil
+}
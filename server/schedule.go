@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// NextScheduleRun returns the first time after t the cron expression matches
+// in the time zone, which is UTC if empty.
+func NextScheduleRun(cron, timeZone string, t time.Time) (time.Time, error) {
+	c, err := ParseCron(cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time zone %q", timeZone)
+	}
+
+	next := c.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression %q never matches", cron)
+	}
+
+	return next, nil
+}
+
+// RunSchedules sends the tasks of the due schedules. A schedule whose runs were missed,
+// like while the server was down, runs once and moves on to its next run after now.
+func (s *Server) RunSchedules(authorize TaskAuthorizer) error {
+	now := time.Now()
+	schedules, err := model.FindDueSchedules(now)
+	if err != nil {
+		return fmt.Errorf("db find due schedules failed, %v", err)
+	}
+
+	for _, schedule := range schedules {
+		err := s.runSchedule(schedule, now, authorize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) runSchedule(schedule *model.Schedule, now time.Time, authorize TaskAuthorizer) error {
+	runAt := *schedule.NextRunAt
+
+	taskID, reason, err := s.sendScheduledTask(schedule, runAt, authorize)
+	if err != nil {
+		return err
+	}
+
+	// The schedule stops if its next run can't be found, like for a time zone
+	// gone from the server, until it is updated.
+	var nextRunAt *time.Time
+	next, err := NextScheduleRun(schedule.Cron, schedule.TimeZone, now)
+	if err != nil {
+		reason = err.Error()
+	} else {
+		nextRunAt = &next
+	}
+
+	ok, err := schedule.Advance(runAt, nextRunAt, taskID, reason)
+	if err != nil {
+		return fmt.Errorf("db advance schedule failed, %v", err)
+	}
+
+	if !ok {
+		return nil
+	}
+
+	if len(reason) > 0 {
+		log.Warnf("schedule (id: %d, worker: %d) run at %s, %s", schedule.ID, schedule.WorkerID, runAt, reason)
+	} else {
+		log.Infof("schedule (id: %d, worker: %d) run at %s sent task %d", schedule.ID, schedule.WorkerID, runAt, taskID)
+	}
+
+	target := fmt.Sprintf("schedule:%d", schedule.ID)
+	summary := fmt.Sprintf(`{"worker_id":%d,"user_id":%d,"task_id":%d,"scheduled_at":%d}`,
+		schedule.WorkerID, schedule.UserID, taskID, runAt.Unix())
+	event := model.NewAuditEvent(0, "", 0, "schedule-run", target, summary, "", reason)
+	if err := event.Insert(); err != nil {
+		log.Errorf("db insert audit event schedule-run failed, %v", err)
+	}
+
+	return nil
+}
+
+// sendScheduledTask sends the task of the schedule's run at runAt as its owner,
+// or returns the reason why the run is skipped. A run sends one task at most,
+// even if it is tried again after a restart or by another server.
+func (s *Server) sendScheduledTask(schedule *model.Schedule, runAt time.Time, authorize TaskAuthorizer) (uint64, string, error) {
+	task, err := model.FindTaskByScheduleRun(uint64(schedule.ID), runAt)
+	if err == nil {
+		return uint64(task.ID), "", nil
+	}
+
+	if err != gorm.ErrRecordNotFound {
+		return 0, "", fmt.Errorf("db find task by schedule run failed, %v", err)
+	}
+
+	user, err := model.FindUserByID(schedule.UserID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, "skipped, owner not exist", nil
+		}
+
+		return 0, "", fmt.Errorf("db find user failed, %v", err)
+	}
+
+	if user.Status != types.UserEnabled {
+		return 0, "skipped, owner disabled", nil
+	}
+
+	worker, err := model.FindWorkerByID(schedule.WorkerID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, "skipped, worker not exist", nil
+		}
+
+		return 0, "", fmt.Errorf("db find worker failed, %v", err)
+	}
+
+	if worker.Status != types.WorkerEnabled {
+		return 0, "skipped, worker disabled", nil
+	}
+
+	if err := authorize(user, schedule.WorkerID); err != nil {
+		return 0, "skipped, " + err.Error(), nil
+	}
+
+	// The owner's role may allow a lower priority now.
+	priority := schedule.Priority
+	if maxPriority := s.MaxTaskPriority(user.Role); priority > maxPriority {
+		priority = maxPriority
+	}
+
+	task = model.NewTask(schedule.UserID, schedule.WorkerID, schedule.Params)
+	task.MaxAttempts += schedule.Retries
+	task.Priority = priority
+	task.ScheduleID = uint64(schedule.ID)
+	task.ScheduledAt = &runAt
+	err = task.Insert()
+	if err != nil {
+		// The unique index of the run fails the insert if another server has sent the task.
+		if t, e := model.FindTaskByScheduleRun(uint64(schedule.ID), runAt); e == nil {
+			return uint64(t.ID), "", nil
+		}
+
+		return 0, "", fmt.Errorf("db insert task failed, %v", err)
+	}
+
+	return uint64(task.ID), "", nil
+}
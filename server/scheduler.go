@@ -0,0 +1,29 @@
+package server
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/tidyoux/goutils/service"
+)
+
+// Scheduler sends the tasks of due schedules, it runs as a service worker.
+type Scheduler struct {
+	service.SimpleWorker
+	s         *Server
+	authorize TaskAuthorizer
+}
+
+func NewScheduler(s *Server, authorize TaskAuthorizer) *Scheduler {
+	return &Scheduler{
+		s:         s,
+		authorize: authorize,
+	}
+}
+
+func (w *Scheduler) Name() string { return "scheduler" }
+
+func (w *Scheduler) Work() {
+	err := w.s.RunSchedules(w.authorize)
+	if err != nil {
+		log.Errorf("run schedules failed, %v", err)
+	}
+}
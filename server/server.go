@@ -2,49 +2,110 @@ package server
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/tidyoux/router/common/crypto"
 	"github.com/tidyoux/router/common/types"
 	"github.com/tidyoux/router/server/config"
 	"github.com/tidyoux/router/server/model"
 	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
 )
 
 var (
 	errUsernameAlreadyExist = fmt.Errorf("username already exist")
-	errUsernameNotExist     = fmt.Errorf("username not exist")
-	errIncorrectPassword    = fmt.Errorf("incorrect password")
+	errIncorrectPassword    = fmt.Errorf("incorrect username or password")
 	errUserDisabled         = fmt.Errorf("user disabled")
+	errSessionNotExist      = fmt.Errorf("session not exist")
 
-	errIncorrectWorkerKey = fmt.Errorf("incorrect worker key")
+	errIncorrectWorkerKey = fmt.Errorf("incorrect worker id or key")
 	errWorkerDisabled     = fmt.Errorf("worker disabled")
+	errAgentOnline        = fmt.Errorf("worker already has a live agent")
+
+	ErrInvalidAgentToken = fmt.Errorf("invalid agent token")
+	ErrAgentReplaced     = fmt.Errorf("agent replaced by a newer login")
 )
 
+// sessionTouchInterval limits how often a session's last used time is written back.
+const sessionTouchInterval = time.Minute
+
 var S *Server
 
 func Init(cfg *config.Config) error {
+	switch cfg.AgentLoginPolicy {
+	case types.AgentLoginReject, types.AgentLoginReplace, types.AgentLoginMultiple:
+	default:
+		return fmt.Errorf("invalid agent login policy %s", cfg.AgentLoginPolicy)
+	}
+
+	for _, p := range cfg.OIDCProviders {
+		for group, role := range p.GroupRoles {
+			if !validRole(role) {
+				return fmt.Errorf("invalid role %s of group %s in oidc provider %s", role, group, p.Name)
+			}
+		}
+
+		if len(p.DefaultRole) > 0 && !validRole(p.DefaultRole) {
+			return fmt.Errorf("invalid default role %s in oidc provider %s", p.DefaultRole, p.Name)
+		}
+	}
+
+	for role := range cfg.TaskMaxPriorities {
+		if !validRole(role) {
+			return fmt.Errorf("invalid role %s in task max priorities", role)
+		}
+	}
+
 	S = New(cfg)
 
-	for username, password := range types.InitialUserList {
-		_, _, err := S.UserRegister(username, password)
+	_, err := S.UserRegister(types.AdminUsername, types.DefaultPassword)
+	if err != nil && err != errUsernameAlreadyExist {
+		return fmt.Errorf("register user %s failed, %v", types.AdminUsername, err)
+	}
+
+	for _, username := range cfg.InitialUsers {
+		if username == types.AdminUsername {
+			continue
+		}
+
+		_, err := S.UserRegister(username, crypto.Sum(crypto.RandBytes(32)).String())
 		if err != nil && err != errUsernameAlreadyExist {
 			return fmt.Errorf("register user %s failed, %v", username, err)
 		}
 	}
 
+	err = migrateWorkerKeys()
+	if err != nil {
+		return err
+	}
+
 	user, err := model.FindUserByName(types.AdminUsername)
 	if err != nil {
 		return fmt.Errorf("db find admin user failed, %v", err)
 	}
 
-	if user.Status == types.UserEnabled {
-		return nil
+	if user.Status != types.UserEnabled {
+		err = user.Enable()
+		if err != nil {
+			return fmt.Errorf("db enable admin user failed, %v", err)
+		}
 	}
 
-	err = user.Enable()
-	if err != nil {
-		return fmt.Errorf("db enable admin user failed, %v", err)
+	// Nothing works before the default password is changed.
+	if ok, _ := crypto.VerifyPassword(user.Password, types.DefaultPassword); ok && !user.MustChangePassword {
+		err = user.RequirePasswordChange()
+		if err != nil {
+			return fmt.Errorf("db require admin user password change failed, %v", err)
+		}
+	}
+
+	if user.Role != types.RoleAdmin {
+		err = user.UpdateRole(types.RoleAdmin)
+		if err != nil {
+			return fmt.Errorf("db update admin user role failed, %v", err)
+		}
 	}
 
 	return nil
@@ -55,146 +116,448 @@ type Server struct {
 
 	cfg *config.Config
 
-	users      map[string]uint64
-	userTokens map[uint64]string
+	sessions SessionStore
 
-	agents      map[string]uint64
-	agentTokens map[uint64]string
+	password  PasswordAuthProvider
+	providers map[string]RedirectAuthProvider
 }
 
 func New(cfg *config.Config) *Server {
-	return &Server{
-		cfg: cfg,
-
-		users:      make(map[string]uint64),
-		userTokens: make(map[uint64]string),
+	s := &Server{
+		cfg:       cfg,
+		sessions:  NewDBSessionStore(),
+		password:  NewLocalPasswordProvider(),
+		providers: make(map[string]RedirectAuthProvider),
+	}
 
-		agents:      make(map[string]uint64),
-		agentTokens: make(map[uint64]string),
+	for _, p := range cfg.OIDCProviders {
+		s.RegisterAuthProvider(NewOIDCProvider(p))
 	}
+
+	return s
 }
 
-func (s *Server) UserRegister(username, password string) (uint64, string, error) {
+// UserRegister hashes and verifies passwords without the lock, bcrypt is slow on purpose
+// and would hold up all other requests.
+func (s *Server) UserRegister(username, password string) (uint64, error) {
+	user, err := model.FindUserByName(username)
+	if err == nil {
+		if ok, _ := crypto.VerifyPassword(user.Password, password); !ok {
+			return 0, errUsernameAlreadyExist
+		}
+
+		return uint64(user.ID), nil
+	}
+
+	if err != gorm.ErrRecordNotFound {
+		return 0, fmt.Errorf("db find user failed, %v", err)
+	}
+
+	hash, err := crypto.HashPassword(password)
+	if err != nil {
+		return 0, fmt.Errorf("hash password failed, %v", err)
+	}
+
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
-	password = crypto.Sum([]byte(password)).String()
+	// The user may be registered while hashing.
+	_, err = model.FindUserByName(username)
+	if err == nil {
+		return 0, errUsernameAlreadyExist
+	}
 
-	user, err := model.FindUserByName(username)
+	if err != gorm.ErrRecordNotFound {
+		return 0, fmt.Errorf("db find user failed, %v", err)
+	}
+
+	user = model.NewUser(username, hash)
+	err = user.Insert()
 	if err != nil {
-		if err != gorm.ErrRecordNotFound {
-			return 0, "", fmt.Errorf("db find user failed, %v", err)
-		}
+		return 0, fmt.Errorf("db insert user failed, %v", err)
+	}
 
-		user = model.NewUser(username, password)
-		err = user.Insert()
-		if err != nil {
-			return 0, "", fmt.Errorf("db insert user failed, %v", err)
-		}
-	} else {
-		if password != user.Password {
-			return 0, "", errUsernameAlreadyExist
+	return uint64(user.ID), nil
+}
+
+// UserLogin returns a session token, or a 2FA token instead if the user has enabled 2FA,
+// which should be passed to UserLogin2FA with the code.
+// The lock isn't held while the password is verified.
+func (s *Server) UserLogin(username, password, ip, userAgent string) (uint64, string, string, error) {
+	targets := loginTargets(types.LoginLockUser, username, ip)
+
+	s.rwMutex.RLock()
+	err := s.checkLoginLocks(targets)
+	s.rwMutex.RUnlock()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	user, err := s.password.Authenticate(username, password)
+
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	if err != nil {
+		if err == errIncorrectPassword {
+			s.loginFailed(targets, ip)
 		}
+
+		return 0, "", "", err
+	}
+
+	// The failures of a 2FA user are cleared after the code passes,
+	// or the password could reset them to guess more codes.
+	if !user.TOTPEnabled {
+		s.loginSucceeded(targets[0])
+	}
+
+	if user.Status == types.UserDisabled {
+		return 0, "", "", errUserDisabled
 	}
 
-	return uint64(user.ID), s.userLogin(uint64(user.ID)), nil
+	kind := int8(types.SessionUser)
+	if user.TOTPEnabled {
+		kind = types.SessionTwoFactor
+	}
+
+	session, err := s.sessions.Create(kind, uint64(user.ID), ip, userAgent)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("db create session failed, %v", err)
+	}
+
+	if kind == types.SessionTwoFactor {
+		return uint64(user.ID), "", session.Token, nil
+	}
+
+	return uint64(user.ID), session.Token, "", nil
 }
 
-func (s *Server) UserLogin(username, password string) (uint64, string, error) {
+// UserLogout deletes the session of token.
+func (s *Server) UserLogout(token string) error {
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
-	user, err := model.FindUserByName(username)
+	session, err := s.sessions.Find(token)
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return 0, "", errUsernameNotExist
+			return nil
 		}
 
-		return 0, "", fmt.Errorf("db find user failed, %v", err)
+		return fmt.Errorf("db find session failed, %v", err)
 	}
 
-	password = crypto.Sum([]byte(password)).String()
-	if password != user.Password {
-		return 0, "", errIncorrectPassword
+	if session.Kind != types.SessionUser {
+		return nil
 	}
 
-	if user.Status == types.UserDisabled {
-		return 0, "", errUserDisabled
+	err = s.sessions.Delete(session)
+	if err != nil {
+		return fmt.Errorf("db delete session failed, %v", err)
 	}
 
-	return uint64(user.ID), s.userLogin(uint64(user.ID)), nil
+	return nil
 }
 
-func (s *Server) userLogin(userID uint64) string {
-	if token, ok := s.userTokens[userID]; ok {
-		return token
+// UserSessions returns the live sessions of user.
+func (s *Server) UserSessions(userID uint64) ([]*model.Session, error) {
+	s.rwMutex.RLock()
+	defer s.rwMutex.RUnlock()
+
+	return s.liveSessions(types.SessionUser, userID)
+}
+
+// RevokeUserSession deletes the session of sessionID if it belongs to user.
+func (s *Server) RevokeUserSession(userID, sessionID uint64) error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	session, err := s.sessions.FindByID(sessionID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return errSessionNotExist
+		}
+
+		return fmt.Errorf("db find session failed, %v", err)
+	}
+
+	if session.Kind != types.SessionUser || session.OwnerID != userID {
+		return errSessionNotExist
+	}
+
+	err = s.sessions.Delete(session)
+	if err != nil {
+		return fmt.Errorf("db delete session failed, %v", err)
 	}
 
-	token := GenToken()
-	s.users[token] = userID
-	s.userTokens[userID] = token
-	return token
+	return nil
 }
 
-func (s *Server) UserLogout(userID uint64) {
+// UserLogoutAll deletes all sessions of user.
+func (s *Server) UserLogoutAll(userID uint64) error {
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
-	if token, ok := s.userTokens[userID]; ok {
-		delete(s.users, token)
+	err := s.sessions.DeleteByOwner(types.SessionUser, userID)
+	if err != nil {
+		return fmt.Errorf("db delete user sessions failed, %v", err)
 	}
-	delete(s.userTokens, userID)
+
+	return nil
 }
 
 func (s *Server) ValidUserToken(token string) (uint64, bool) {
 	s.rwMutex.RLock()
-	defer s.rwMutex.RUnlock()
+	session, ok := s.validSession(types.SessionUser, token)
+	s.rwMutex.RUnlock()
+	if !ok {
+		return 0, false
+	}
 
-	userID, ok := s.users[token]
-	return userID, ok
+	s.touchSession(session)
+	return session.OwnerID, true
 }
 
-func (s *Server) AgentLogin(workerID uint64, workerKey string) (string, error) {
+func (s *Server) AgentLogin(workerID uint64, workerKey, ip, userAgent string) (string, error) {
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
-	if token, ok := s.agentTokens[workerID]; ok {
-		return token, nil
+	targets := loginTargets(types.LoginLockWorker, strconv.FormatUint(workerID, 10), ip)
+	if err := s.checkLoginLocks(targets); err != nil {
+		return "", err
 	}
 
 	worker, err := model.FindWorkerByID(workerID)
 	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			s.loginFailed(targets, ip)
+			return "", errIncorrectWorkerKey
+		}
+
 		return "", fmt.Errorf("db find worker failed, %v", err)
 	}
 
-	if workerKey != worker.Key {
+	if !verifyWorkerKey(worker, workerKey, time.Now()) {
+		s.loginFailed(targets, ip)
 		return "", errIncorrectWorkerKey
 	}
 
+	s.loginSucceeded(targets[0])
+
 	if worker.Status == types.WorkerDisabled {
 		return "", errWorkerDisabled
 	}
 
-	token := GenToken()
-	s.agents[token] = workerID
-	s.agentTokens[workerID] = token
-	return token, nil
+	switch s.cfg.AgentLoginPolicy {
+	case types.AgentLoginReject:
+		sessions, err := s.liveSessions(types.SessionAgent, workerID)
+		if err != nil {
+			return "", err
+		}
+
+		if len(sessions) > 0 {
+			return "", errAgentOnline
+		}
+	case types.AgentLoginReplace:
+		err = s.sessions.Replace(types.SessionAgent, workerID)
+		if err != nil {
+			return "", fmt.Errorf("db replace agent sessions failed, %v", err)
+		}
+	}
+
+	session, err := s.sessions.Create(types.SessionAgent, workerID, ip, userAgent)
+	if err != nil {
+		return "", fmt.Errorf("db create session failed, %v", err)
+	}
+
+	return session.Token, nil
+}
+
+// AgentLogout deletes the agent session of token.
+func (s *Server) AgentLogout(token string) error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	session, err := s.sessions.Find(token)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+
+		return fmt.Errorf("db find session failed, %v", err)
+	}
+
+	if session.Kind != types.SessionAgent {
+		return nil
+	}
+
+	err = s.sessions.Delete(session)
+	if err != nil {
+		return fmt.Errorf("db delete session failed, %v", err)
+	}
+
+	return nil
 }
 
-func (s *Server) ValidAgentToken(token string) (uint64, bool) {
+// ValidAgentToken returns the worker of token, or ErrAgentReplaced
+// if the agent has been replaced by a newer login.
+func (s *Server) ValidAgentToken(token string) (uint64, error) {
+	session, err := s.ValidAgentSession(token)
+	if err != nil {
+		return 0, err
+	}
+
+	return session.OwnerID, nil
+}
+
+// ValidAgentSession returns the agent session of token, the session tells
+// apart the agents of the same worker.
+func (s *Server) ValidAgentSession(token string) (*model.Session, error) {
+	s.rwMutex.RLock()
+	session, ok := s.validSession(types.SessionAgent, token)
+	s.rwMutex.RUnlock()
+	if ok {
+		s.touchSession(session)
+		return session, nil
+	}
+
 	s.rwMutex.RLock()
 	defer s.rwMutex.RUnlock()
 
-	workerID, ok := s.agents[token]
-	return workerID, ok
+	session, err := s.sessions.Find(token)
+	if err == nil && session.Kind == types.SessionAgent && session.Replaced &&
+		!s.sessionExpired(session, time.Now()) {
+		return nil, ErrAgentReplaced
+	}
+
+	return nil, ErrInvalidAgentToken
 }
 
-func (s *Server) RemoveWorker(workerID uint64) {
+func (s *Server) RemoveWorker(workerID uint64) error {
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 
-	if token, ok := s.agentTokens[workerID]; ok {
-		delete(s.agents, token)
+	err := s.sessions.DeleteByOwner(types.SessionAgent, workerID)
+	if err != nil {
+		return fmt.Errorf("db delete agent sessions failed, %v", err)
 	}
-	delete(s.agentTokens, workerID)
+
+	err = model.DeleteEnrollTokensByWorkerID(workerID)
+	if err != nil {
+		return fmt.Errorf("db delete enroll tokens failed, %v", err)
+	}
+
+	err = model.DeleteSchedulesByWorkerID(workerID)
+	if err != nil {
+		return fmt.Errorf("db delete schedules failed, %v", err)
+	}
+
+	return nil
+}
+
+// SweepSessions deletes all expired sessions.
+func (s *Server) SweepSessions() error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	now := time.Now()
+	for _, kind := range []int8{types.SessionUser, types.SessionAgent, types.SessionTwoFactor} {
+		var idleBefore, createdBefore time.Time
+		idleTTL, ttl := s.sessionTTL(kind)
+		if idleTTL > 0 {
+			idleBefore = now.Add(-idleTTL)
+		}
+		if ttl > 0 {
+			createdBefore = now.Add(-ttl)
+		}
+
+		err := s.sessions.DeleteExpired(kind, idleBefore, createdBefore)
+		if err != nil {
+			return fmt.Errorf("db delete expired sessions failed, %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) liveSessions(kind int8, ownerID uint64) ([]*model.Session, error) {
+	sessions, err := s.sessions.FindByOwner(kind, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("db find sessions failed, %v", err)
+	}
+
+	now := time.Now()
+	live := make([]*model.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if !session.Replaced && !s.sessionExpired(session, now) {
+			live = append(live, session)
+		}
+	}
+
+	return live, nil
+}
+
+func (s *Server) validToken(kind int8, token string) (uint64, bool) {
+	session, ok := s.validSession(kind, token)
+	if !ok {
+		return 0, false
+	}
+
+	return session.OwnerID, true
+}
+
+func (s *Server) validSession(kind int8, token string) (*model.Session, bool) {
+	session, err := s.sessions.Find(token)
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Errorf("db find session failed, %v", err)
+		}
+		return nil, false
+	}
+
+	if session.Kind != kind || session.Replaced {
+		return nil, false
+	}
+
+	if s.sessionExpired(session, time.Now()) {
+		return nil, false
+	}
+
+	return session, true
+}
+
+// touchSession records the use of a valid session, at most once per sessionTouchInterval.
+// It is a write, so it is called without the read lock of validSession.
+func (s *Server) touchSession(session *model.Session) {
+	if time.Since(session.LastUsedAt) < sessionTouchInterval {
+		return
+	}
+
+	if err := s.sessions.Touch(session); err != nil {
+		log.Errorf("db touch session failed, %v", err)
+	}
+}
+
+func (s *Server) sessionExpired(session *model.Session, now time.Time) bool {
+	idleTTL, ttl := s.sessionTTL(session.Kind)
+	if idleTTL > 0 && now.Sub(session.LastUsedAt) > idleTTL {
+		return true
+	}
+
+	if ttl > 0 && now.Sub(session.CreatedAt) > ttl {
+		return true
+	}
+
+	return false
+}
+
+func (s *Server) sessionTTL(kind int8) (time.Duration, time.Duration) {
+	switch kind {
+	case types.SessionAgent:
+		return s.cfg.AgentSessionIdleTTL, s.cfg.AgentSessionTTL
+	case types.SessionTwoFactor:
+		return 0, twoFactorLoginTTL
+	}
+
+	return s.cfg.UserSessionIdleTTL, s.cfg.UserSessionTTL
 }
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/server/model"
+)
+
+// SessionStore keeps the login sessions of users and agents.
+type SessionStore interface {
+	Create(kind int8, ownerID uint64, ip, userAgent string) (*model.Session, error)
+	Find(token string) (*model.Session, error)
+	FindByID(id uint64) (*model.Session, error)
+	FindByOwner(kind int8, ownerID uint64) ([]*model.Session, error)
+	Touch(session *model.Session) error
+	Delete(session *model.Session) error
+	// Replace marks all sessions of the owner as replaced, they are kept until expired
+	// so that their holders can tell being replaced from being logged out.
+	Replace(kind int8, ownerID uint64) error
+	DeleteByOwner(kind int8, ownerID uint64) error
+	DeleteExpired(kind int8, idleBefore, createdBefore time.Time) error
+}
+
+// SessionTokenHash returns the hash a session of token is kept and found by.
+func SessionTokenHash(token string) string {
+	return crypto.Sum([]byte(token)).String()
+}
+
+type dbSessionStore struct{}
+
+// NewDBSessionStore returns a SessionStore backed by the session table.
+func NewDBSessionStore() SessionStore {
+	return &dbSessionStore{}
+}
+
+func (*dbSessionStore) Create(kind int8, ownerID uint64, ip, userAgent string) (*model.Session, error) {
+	token := GenToken()
+	session := model.NewSession(token, SessionTokenHash(token), kind, ownerID, ip, userAgent)
+	err := session.Insert()
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (*dbSessionStore) Find(token string) (*model.Session, error) {
+	return model.FindSessionByHash(SessionTokenHash(token))
+}
+
+func (*dbSessionStore) FindByID(id uint64) (*model.Session, error) {
+	return model.FindSessionByID(id)
+}
+
+func (*dbSessionStore) FindByOwner(kind int8, ownerID uint64) ([]*model.Session, error) {
+	return model.FindSessionsByOwner(kind, ownerID)
+}
+
+func (*dbSessionStore) Touch(session *model.Session) error {
+	return session.Touch()
+}
+
+func (*dbSessionStore) Delete(session *model.Session) error {
+	return session.Delete()
+}
+
+func (*dbSessionStore) Replace(kind int8, ownerID uint64) error {
+	return model.ReplaceSessionsByOwner(kind, ownerID)
+}
+
+func (*dbSessionStore) DeleteByOwner(kind int8, ownerID uint64) error {
+	return model.DeleteSessionsByOwner(kind, ownerID)
+}
+
+func (*dbSessionStore) DeleteExpired(kind int8, idleBefore, createdBefore time.Time) error {
+	return model.DeleteExpiredSessions(kind, idleBefore, createdBefore)
+}
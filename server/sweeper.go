@@ -0,0 +1,53 @@
+package server
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/tidyoux/goutils/service"
+)
+
+// Sweeper purges expired sessions, stale login locks, enrollment tokens and redirect logins,
+// and handles timed out tasks and expired task leases, it runs as a service worker.
+type Sweeper struct {
+	service.SimpleWorker
+	s *Server
+}
+
+func NewSweeper(s *Server) *Sweeper {
+	return &Sweeper{
+		s: s,
+	}
+}
+
+func (w *Sweeper) Name() string { return "sweeper" }
+
+func (w *Sweeper) Work() {
+	err := w.s.SweepSessions()
+	if err != nil {
+		log.Errorf("sweep sessions failed, %v", err)
+	}
+
+	err = w.s.SweepLoginLocks()
+	if err != nil {
+		log.Errorf("sweep login locks failed, %v", err)
+	}
+
+	err = w.s.SweepEnrollTokens()
+	if err != nil {
+		log.Errorf("sweep enroll tokens failed, %v", err)
+	}
+
+	err = w.s.SweepAuthStates()
+	if err != nil {
+		log.Errorf("sweep auth states failed, %v", err)
+	}
+
+	err = w.s.SweepExpiredLeases()
+	if err != nil {
+		log.Errorf("sweep expired leases failed, %v", err)
+	}
+
+	err = w.s.SweepTimedOutTasks()
+	if err != nil {
+		log.Errorf("sweep timed out tasks failed, %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+)
+
+// TaskAuthorizer checks that the user can send tasks to the worker, the owner of
+// a schedule or the runner of a pipeline may have lost the permission since then.
+type TaskAuthorizer func(user *model.User, workerID uint64) error
+
+// TaskMaxRunTime returns how long an accepted task of worker can run, 0 means no limit.
+func (s *Server) TaskMaxRunTime(worker *model.Worker) time.Duration {
+	if worker.MaxRunTime > 0 {
+		return time.Duration(worker.MaxRunTime) * time.Second
+	}
+
+	return s.cfg.TaskMaxRunTime
+}
+
+// leaseExpiredAt returns when a lease granted at now expires, nil if leases never expire.
+func (s *Server) leaseExpiredAt(now time.Time) *time.Time {
+	if s.cfg.TaskLeaseTTL == 0 {
+		return nil
+	}
+
+	t := now.Add(s.cfg.TaskLeaseTTL)
+	return &t
+}
+
+// claimCandidates is how many queued tasks a claim tries, the others may be
+// claimed by other agents of the worker at the same time.
+const claimCandidates = 10
+
+// AcceptTask accepts the task for the agent of session, which holds its lease then.
+// It returns false if the task has been accepted.
+func (s *Server) AcceptTask(task *model.Task, sessionID uint64) (bool, error) {
+	ok, err := task.Accept(sessionID, s.leaseExpiredAt(time.Now()))
+	if err != nil {
+		return false, fmt.Errorf("db accept task failed, %v", err)
+	}
+
+	return ok, nil
+}
+
+// ClaimTask accepts the next queued task of the worker for the agent of session,
+// it returns nil if there is none.
+func (s *Server) ClaimTask(workerID, sessionID uint64) (*model.Task, error) {
+	tasks, err := s.TaskQueue(workerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tasks) > claimCandidates {
+		tasks = tasks[:claimCandidates]
+	}
+
+	for _, task := range tasks {
+		ok, err := s.AcceptTask(task, sessionID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		task, err = model.FindTaskByID(uint64(task.ID))
+		if err != nil {
+			return nil, fmt.Errorf("db find task failed, %v", err)
+		}
+
+		return task, nil
+	}
+
+	return nil, nil
+}
+
+// RenewTaskLease extends the lease of the accepted task for the agent of session,
+// it returns false if the task is no longer accepted or its lease is held by
+// another agent.
+func (s *Server) RenewTaskLease(task *model.Task, sessionID uint64) (bool, error) {
+	now := time.Now()
+	ok, err := task.RenewLease(sessionID, s.leaseExpiredAt(now), now)
+	if err != nil {
+		return false, fmt.Errorf("db renew task lease failed, %v", err)
+	}
+
+	return ok, nil
+}
+
+// SweepExpiredLeases requeues or fails the accepted tasks whose agents have stopped
+// renewing their leases, by the lease expired policy of their workers.
+func (s *Server) SweepExpiredLeases() error {
+	now := time.Now()
+	tasks, err := model.FindLeaseExpiredTasks(now)
+	if err != nil {
+		return fmt.Errorf("db find lease expired tasks failed, %v", err)
+	}
+
+	for _, task := range tasks {
+		policy := int8(types.LeaseExpiredRequeue)
+		worker, err := model.FindWorkerByID(task.WorkerID)
+		if err == nil {
+			policy = worker.LeaseExpiredPolicy
+		} else if err != gorm.ErrRecordNotFound {
+			return fmt.Errorf("db find worker failed, %v", err)
+		}
+
+		detail := fmt.Sprintf("lease expired: agent session %d stopped renewing the lease at step index %d",
+			task.LeaseOwner, task.Progress)
+
+		var ok bool
+		if task.CancelRequested {
+			ok, err = s.FinishTask(task, types.TaskCanceled, detail+", canceled")
+			if err != nil {
+				return err
+			}
+		} else if policy == types.LeaseExpiredFail {
+			ok, err = s.FinishTask(task, types.TaskFailed, detail)
+			if err != nil {
+				return err
+			}
+		} else {
+			ok, err = task.Requeue(detail+", requeued", now)
+			if err != nil {
+				return fmt.Errorf("db requeue task failed, %v", err)
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		log.Warnf("task (id: %d, worker: %d) lease of agent session %d expired, %s",
+			task.ID, task.WorkerID, task.LeaseOwner, types.LeaseExpiredPolicyNames[policy])
+
+		target := fmt.Sprintf("task:%d", task.ID)
+		summary := fmt.Sprintf(`{"worker_id":%d,"lease_owner":%d,"progress":%d,"policy":%q}`,
+			task.WorkerID, task.LeaseOwner, task.Progress, types.LeaseExpiredPolicyNames[policy])
+		event := model.NewAuditEvent(0, "", 0, "lease-expired", target, summary, "", "")
+		if err := event.Insert(); err != nil {
+			log.Errorf("db insert audit event lease-expired failed, %v", err)
+		}
+	}
+
+	return nil
+}
+
+// FinishTask finishes the attempt of the accepted task with status and records it,
+// a failed task is sent back to the queue if it has attempts left and is not asked
+// to cancel. It returns false if the task is no longer accepted.
+func (s *Server) FinishTask(task *model.Task, status int8, detail string) (bool, error) {
+	var (
+		ok  bool
+		err error
+	)
+	switch status {
+	case types.TaskSuccess:
+		ok, err = task.Success(detail)
+	case types.TaskCanceled:
+		ok, err = task.Canceled(detail)
+	default:
+		if !task.CancelRequested && task.Attempt < task.MaxAttempts {
+			ok, err = task.Retry(fmt.Sprintf("attempt %d/%d failed, retrying", task.Attempt, task.MaxAttempts))
+		} else {
+			ok, err = task.Fail(detail)
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("db finish task failed, %v", err)
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	err = model.NewTaskAttempt(task, status, detail).Insert()
+	if err != nil {
+		log.Errorf("db insert task attempt failed, %v", err)
+	}
+
+	return true, nil
+}
+
+// SweepTimedOutTasks fails the accepted tasks which have run longer than the max run time
+// of their workers, in case their agents hang or are gone.
+func (s *Server) SweepTimedOutTasks() error {
+	tasks, err := model.FindAllAcceptedTasks()
+	if err != nil {
+		return fmt.Errorf("db find accepted tasks failed, %v", err)
+	}
+
+	now := time.Now()
+	maxRunTimes := make(map[uint64]time.Duration)
+	for _, task := range tasks {
+		if task.AcceptedAt == nil {
+			continue
+		}
+
+		maxRunTime, ok := maxRunTimes[task.WorkerID]
+		if !ok {
+			worker, err := model.FindWorkerByID(task.WorkerID)
+			if err != nil {
+				if err != gorm.ErrRecordNotFound {
+					return fmt.Errorf("db find worker failed, %v", err)
+				}
+
+				// The worker has been removed.
+				worker = &model.Worker{}
+			}
+
+			maxRunTime = s.TaskMaxRunTime(worker)
+			maxRunTimes[task.WorkerID] = maxRunTime
+		}
+
+		if maxRunTime == 0 || now.Sub(*task.AcceptedAt) < maxRunTime {
+			continue
+		}
+
+		detail := fmt.Sprintf("timeout: task not finished in %s after accepted, at step index %d",
+			maxRunTime, task.Progress)
+		// A task which runs out of its max run time is not retried.
+		ok, err = task.Fail(detail)
+		if err != nil {
+			return fmt.Errorf("db fail task failed, %v", err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		log.Warnf("task (id: %d, worker: %d) failed for timeout after %s", task.ID, task.WorkerID, maxRunTime)
+
+		err = model.NewTaskAttempt(task, types.TaskFailed, detail).Insert()
+		if err != nil {
+			log.Errorf("db insert task attempt failed, %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+)
+
+const (
+	// twoFactorLoginTTL limits how long a login waits for the 2FA code.
+	twoFactorLoginTTL = time.Minute * 5
+
+	// totpSkew allows the codes of the neighbour steps for clock drift.
+	totpSkew = 1
+
+	RecoveryCodeCount = 10
+)
+
+var (
+	errInvalidTwoFactorToken = fmt.Errorf("invalid or expired 2fa login, please login again")
+	errIncorrectTOTPCode     = fmt.Errorf("incorrect 2fa code")
+	errTOTPNotSetup          = fmt.Errorf("2fa is not set up")
+	errTOTPAlreadyEnabled    = fmt.Errorf("2fa is enabled already")
+	errTOTPNotEnabled        = fmt.Errorf("2fa is not enabled")
+	errTOTPRequired          = fmt.Errorf("2fa is required for your role")
+	errTOTPExternalUser      = fmt.Errorf("2fa of external users is up to their auth provider")
+)
+
+// TOTPRequired reports whether user must enroll 2FA, it is up to the provider for external users.
+func (s *Server) TOTPRequired(user *model.User) bool {
+	if user.External() {
+		return false
+	}
+
+	for _, r := range s.cfg.TOTPRequiredRoles {
+		if r == user.Role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UserLogin2FA completes a login of UserLogin with a TOTP or recovery code.
+func (s *Server) UserLogin2FA(twoFactorToken, code, ip, userAgent string) (uint64, string, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	userID, ok := s.validToken(types.SessionTwoFactor, twoFactorToken)
+	if !ok {
+		return 0, "", errInvalidTwoFactorToken
+	}
+
+	user, err := model.FindUserByID(userID)
+	if err != nil {
+		return 0, "", fmt.Errorf("db find user failed, %v", err)
+	}
+
+	targets := loginTargets(types.LoginLockUser, user.Name, ip)
+	if err := s.checkLoginLocks(targets); err != nil {
+		return 0, "", err
+	}
+
+	ok, err = s.verifyTOTPCode(user, code)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if !ok {
+		s.loginFailed(targets, ip)
+		return 0, "", errIncorrectTOTPCode
+	}
+
+	s.loginSucceeded(targets[0])
+
+	if user.Status == types.UserDisabled {
+		return 0, "", errUserDisabled
+	}
+
+	session, err := s.sessions.Find(twoFactorToken)
+	if err == nil {
+		err = s.sessions.Delete(session)
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("db delete 2fa session failed, %v", err)
+	}
+
+	session, err = s.sessions.Create(types.SessionUser, userID, ip, userAgent)
+	if err != nil {
+		return 0, "", fmt.Errorf("db create session failed, %v", err)
+	}
+
+	return userID, session.Token, nil
+}
+
+// SetupTOTP starts to enroll 2FA for user, it returns the secret and its otpauth URI.
+// 2FA takes effect after EnableTOTP with a code of the secret.
+func (s *Server) SetupTOTP(user *model.User) (string, string, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	if user.External() {
+		return "", "", errTOTPExternalUser
+	}
+
+	if user.TOTPEnabled {
+		return "", "", errTOTPAlreadyEnabled
+	}
+
+	secret := crypto.GenTOTPSecret()
+	err := user.SetupTOTP(secret)
+	if err != nil {
+		return "", "", fmt.Errorf("db setup user totp failed, %v", err)
+	}
+
+	return secret, crypto.TOTPURI(s.cfg.TOTPIssuer, user.Name, secret), nil
+}
+
+// EnableTOTP enables the 2FA set up by SetupTOTP, it returns the recovery codes,
+// which can't be got again.
+func (s *Server) EnableTOTP(user *model.User, code string) ([]string, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	if user.TOTPEnabled {
+		return nil, errTOTPAlreadyEnabled
+	}
+
+	if len(user.TOTPSecret) == 0 {
+		return nil, errTOTPNotSetup
+	}
+
+	step, ok := crypto.VerifyTOTP(user.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, errIncorrectTOTPCode
+	}
+
+	codes, hashes := genRecoveryCodes()
+	err := user.EnableTOTP(step, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("db enable user totp failed, %v", err)
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns off 2FA of user, unless it is required for the user's role.
+func (s *Server) DisableTOTP(user *model.User) error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	if s.TOTPRequired(user) {
+		return errTOTPRequired
+	}
+
+	err := user.ResetTOTP()
+	if err != nil {
+		return fmt.Errorf("db reset user totp failed, %v", err)
+	}
+
+	return nil
+}
+
+// ResetTOTP turns off 2FA of user for admin, like when the user lost the device.
+// The user is logged out, and has to enroll again if 2FA is required.
+func (s *Server) ResetTOTP(user *model.User) error {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	err := user.ResetTOTP()
+	if err != nil {
+		return fmt.Errorf("db reset user totp failed, %v", err)
+	}
+
+	err = s.sessions.DeleteByOwner(types.SessionUser, uint64(user.ID))
+	if err != nil {
+		return fmt.Errorf("db delete user sessions failed, %v", err)
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of user after checking code.
+func (s *Server) RegenerateRecoveryCodes(user *model.User, code string) ([]string, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	if !user.TOTPEnabled {
+		return nil, errTOTPNotEnabled
+	}
+
+	ok, err := s.verifyTOTPCode(user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, errIncorrectTOTPCode
+	}
+
+	codes, hashes := genRecoveryCodes()
+	ok, err = user.UpdateRecoveryCodes(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("db update user recovery codes failed, %v", err)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("recovery codes changed, please try again")
+	}
+
+	return codes, nil
+}
+
+// verifyTOTPCode checks code as a TOTP code first, then a recovery code.
+// Accepted codes can't be used again.
+func (s *Server) verifyTOTPCode(user *model.User, code string) (bool, error) {
+	if !user.TOTPEnabled {
+		return false, errTOTPNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := crypto.VerifyTOTP(user.TOTPSecret, code, time.Now(), totpSkew); ok {
+		ok, err := user.UseTOTPStep(step)
+		if err != nil {
+			return false, fmt.Errorf("db update user totp step failed, %v", err)
+		}
+
+		return ok, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	hashes := user.RecoveryCodeHashes()
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+
+		left := append(append([]string{}, hashes[:i]...), hashes[i+1:]...)
+		ok, err := user.UpdateRecoveryCodes(left)
+		if err != nil {
+			return false, fmt.Errorf("db update user recovery codes failed, %v", err)
+		}
+
+		return ok, nil
+	}
+
+	return false, nil
+}
+
+// genRecoveryCodes returns the recovery codes and their hashes.
+func genRecoveryCodes() ([]string, []string) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code := hex.EncodeToString(crypto.RandBytes(5))
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	return crypto.Sum([]byte(code)).String()
+}
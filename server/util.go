@@ -1,15 +1,11 @@
 package server
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
-	"math/rand"
-	"time"
+
+	"github.com/tidyoux/router/common/crypto"
 )
 
 func GenToken() string {
-	seed := time.Now().String() + fmt.Sprint(rand.Intn(10000))
-	hash := sha256.Sum256([]byte(seed))
-	return hex.EncodeToString(hash[:])
+	return hex.EncodeToString(crypto.RandBytes(32))
 }
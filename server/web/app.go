@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/tidyoux/router/client"
+	"github.com/tidyoux/router/common/types"
 	"github.com/tidyoux/router/server/web/cache"
 	"github.com/tidyoux/router/server/web/control"
 	"github.com/tidyoux/router/server/web/view"
@@ -29,6 +30,7 @@ type App struct {
 
 	hasLogin bool
 	userName string
+	userRole string
 }
 
 func New() *App {
@@ -45,19 +47,52 @@ func (a *App) Init() {
 	control.AddListener(control.ERerender, a.onRerender)
 
 	control.AddListener(control.ELogin, a.onLogin)
+	control.AddListener(control.ELogin2FA, a.onLogin2FA)
 	control.AddListener(control.ELogout, a.onLogout)
+	control.AddListener(control.EExternalLogin, a.onExternalLogin)
 
 	control.AddListener(control.EUpdatePassword, a.onUpdatePassword)
 
+	control.AddListener(control.ESetupTOTP, a.onSetupTOTP)
+	control.AddListener(control.EEnableTOTP, a.onEnableTOTP)
+	control.AddListener(control.EDisableTOTP, a.onDisableTOTP)
+	control.AddListener(control.ERegenerateRecoveryCodes, a.onRegenerateRecoveryCodes)
+
+	control.AddListener(control.EListSession, a.onListSession)
+	control.AddListener(control.ERevokeSession, a.onRevokeSession)
+
+	control.AddListener(control.EListAccessToken, a.onListAccessToken)
+	control.AddListener(control.ECreateAccessToken, a.onCreateAccessToken)
+	control.AddListener(control.ERevokeAccessToken, a.onRevokeAccessToken)
+
 	control.AddListener(control.EListWorker, a.onListWorker)
 	control.AddListener(control.EUpdateWorker, a.onUpdateWorker)
 
 	control.AddListener(control.EListTask, a.onListTask)
 	control.AddListener(control.ESendTask, a.onSendTask)
+	control.AddListener(control.ECancelTask, a.onCancelTask)
+
+	control.AddListener(control.EListSchedule, a.onListSchedule)
+	control.AddListener(control.EAddSchedule, a.onAddSchedule)
+	control.AddListener(control.EUpdateSchedule, a.onUpdateSchedule)
+	control.AddListener(control.ERemoveSchedule, a.onRemoveSchedule)
+
+	control.AddListener(control.EListPipeline, a.onListPipeline)
+	control.AddListener(control.EAddPipeline, a.onAddPipeline)
+	control.AddListener(control.EUpdatePipeline, a.onUpdatePipeline)
+	control.AddListener(control.ERemovePipeline, a.onRemovePipeline)
+	control.AddListener(control.ERunPipeline, a.onRunPipeline)
+	control.AddListener(control.EListPipelineRun, a.onListPipelineRun)
+	control.AddListener(control.ECancelPipelineRun, a.onCancelPipelineRun)
 
 	control.AddListener(control.EListUser, a.onListUser)
+	control.AddListener(control.EAddUser, a.onAddUser)
+	control.AddListener(control.ERenameUser, a.onRenameUser)
+	control.AddListener(control.EDeleteUser, a.onDeleteUser)
 	control.AddListener(control.EUpdateUserDetail, a.onUpdateUserDetail)
 	control.AddListener(control.EResetUserPassword, a.onResetUserPassword)
+	control.AddListener(control.EResetUserTOTP, a.onResetUserTOTP)
+	control.AddListener(control.EUpdateUserRole, a.onUpdateUserRole)
 	control.AddListener(control.EEnableUser, a.onEnableUser)
 	control.AddListener(control.EDisableUser, a.onDisableUser)
 	control.AddListener(control.EAddWorker, a.onAddWorker)
@@ -66,12 +101,23 @@ func (a *App) Init() {
 	control.AddListener(control.EAddWorkerUser, a.onAddWorkerUser)
 	control.AddListener(control.ERemoveWorkerUser, a.onRemoveWorkerUser)
 	control.AddListener(control.ERemoveWorker, a.onRemoveWorker)
-
-	if !a.restoreTokenUsername() {
-		a.changeView(a.loginView)
+	control.AddListener(control.ERotateWorkerKey, a.onRotateWorkerKey)
+	control.AddListener(control.ECreateEnrollToken, a.onCreateEnrollToken)
+
+	control.AddListener(control.EListAudit, a.onListAudit)
+
+	if state, code := queryParam("state"), queryParam("code"); len(state) > 0 && len(code) > 0 {
+		clearQuery()
+		if err := a.finishExternalLogin(state, code); err != nil {
+			a.enterLogin()
+			a.loginView.SetNode(err.Error())
+		} else {
+			a.enterHome()
+		}
+	} else if !a.restoreTokenUsername() {
+		a.enterLogin()
 	} else {
-		cache.C().SetUsername(a.userName)
-		a.changeView(a.homeView)
+		a.enterHome()
 	}
 }
 
@@ -98,8 +144,9 @@ func (a *App) restoreTokenUsername() bool {
 		if data := localLoad("username"); data != js.Undefined() {
 			a.userName = data.String()
 
-			err := a.client.Ping()
+			user, err := a.client.Info()
 			if err == nil {
+				a.setUser(user)
 				return true
 			}
 		}
@@ -114,6 +161,7 @@ func (a *App) clearTokenUsername() {
 	localStore("username", "")
 	a.client.SetToken("")
 	a.userName = ""
+	a.userRole = ""
 	cache.C().Clear()
 }
 
@@ -121,29 +169,174 @@ func (a *App) onRerender(e *control.Event) {
 	vecty.Rerender(a)
 }
 
+func (a *App) setUser(user *types.User) {
+	a.userName = user.Name
+	a.userRole = user.Role
+	cache.C().SetProvider(user.Provider)
+	cache.C().SetMustChangePassword(user.MustChangePassword)
+	cache.C().SetMaxTaskPriority(user.MaxTaskPriority)
+	cache.C().SetTOTP(user.TOTPEnabled, user.TOTPRequired, user.RecoveryCodes)
+}
+
 func (a *App) onLogin(e *control.Event) {
 	username, _ := e.Get("username")
 	password, _ := e.Get("password")
 	_, err := a.client.Login(username.(string), password.(string))
+	if err == client.ErrTwoFactorRequired {
+		a.loginView.SetTwoFactor(true)
+		vecty.Rerender(a)
+		return
+	}
+
+	a.finishLogin(err)
+}
+
+func (a *App) onLogin2FA(e *control.Event) {
+	code, _ := e.Get("code")
+	_, err := a.client.Login2FA(code.(string))
+	a.finishLogin(err)
+}
+
+func (a *App) onExternalLogin(e *control.Event) {
+	provider, _ := e.Get("provider")
+	url, err := a.client.ExternalLogin(provider.(string))
+	if err != nil {
+		a.loginView.SetNode(err.Error())
+		vecty.Rerender(a)
+		return
+	}
+
+	redirect(url)
+}
+
+// finishExternalLogin completes the login when the browser comes back from an external auth provider.
+func (a *App) finishExternalLogin(state, code string) error {
+	_, err := a.client.ExternalLoginCallback(state, code)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.client.Info()
+	if err != nil {
+		return err
+	}
+
+	a.setUser(user)
+	a.storeTokenUsername()
+	return nil
+}
+
+func (a *App) finishLogin(err error) {
+	if err == nil {
+		var user *types.User
+		user, err = a.client.Info()
+		if err == nil {
+			a.setUser(user)
+		}
+	}
+
 	if err != nil {
 		a.loginView.SetNode(err.Error())
 	} else {
-		a.userName = username.(string)
 		a.storeTokenUsername()
-		cache.C().SetUsername(a.userName)
-		a.changeView(a.homeView)
+		a.enterHome()
+	}
+
+	vecty.Rerender(a)
+}
+
+// updateTOTP refreshes the 2FA state of the current user, and loads the home
+// if the required 2FA has just been set up.
+func (a *App) updateTOTP() {
+	wasBlocked := cache.C().TOTPRequired() && !cache.C().TOTPEnabled()
+
+	user, err := a.client.Info()
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+		return
+	}
+
+	cache.C().SetTOTP(user.TOTPEnabled, user.TOTPRequired, user.RecoveryCodes)
+	if wasBlocked && user.TOTPEnabled {
+		a.homeView.Init()
+	}
+}
+
+func (a *App) onSetupTOTP(e *control.Event) {
+	resp, err := a.client.SetupTOTP()
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetNewTOTP(resp)
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onEnableTOTP(e *control.Event) {
+	code, _ := e.Get("code")
+	codes, err := a.client.EnableTOTP(code.(string))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetNewTOTP(nil)
+		cache.C().SetNewRecoveryCodes(codes)
+		a.updateTOTP()
 	}
 
 	vecty.Rerender(a)
 }
 
+func (a *App) onDisableTOTP(e *control.Event) {
+	password, _ := e.Get("password")
+	err := a.client.DisableTOTP(password.(string))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetNewRecoveryCodes(nil)
+		a.updateTOTP()
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onRegenerateRecoveryCodes(e *control.Event) {
+	code, _ := e.Get("code")
+	codes, err := a.client.RegenerateRecoveryCodes(code.(string))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetNewRecoveryCodes(codes)
+		a.updateTOTP()
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) enterLogin() {
+	a.changeView(a.loginView)
+
+	providers, err := a.client.ListAuthProvider()
+	if err != nil {
+		a.loginView.SetNode(err.Error())
+	} else {
+		a.loginView.SetProviders(providers)
+	}
+}
+
+func (a *App) enterHome() {
+	cache.C().SetUsername(a.userName)
+	cache.C().SetRole(a.userRole)
+	a.changeView(a.homeView)
+}
+
 func (a *App) onLogout(e *control.Event) {
 	err := a.client.Logout()
 	if err != nil {
 		a.homeView.SetNode(err.Error())
 	} else {
 		a.clearTokenUsername()
-		a.changeView(a.loginView)
+		a.enterLogin()
 	}
 
 	vecty.Rerender(a)
@@ -156,7 +349,90 @@ func (a *App) onUpdatePassword(e *control.Event) {
 		a.homeView.SetNode(err.Error())
 	} else {
 		a.clearTokenUsername()
-		a.changeView(a.loginView)
+		a.enterLogin()
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) updateSessions(userID uint64) {
+	var (
+		sessions []*types.Session
+		err      error
+	)
+	if userID == 0 {
+		sessions, err = a.client.ListSession()
+	} else {
+		sessions, err = a.client.ListUserSession(userID)
+	}
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetSessions(userID, sessions)
+	}
+}
+
+func (a *App) onListSession(e *control.Event) {
+	userID, _ := e.Get("userID")
+	a.updateSessions(userID.(uint64))
+	vecty.Rerender(a)
+}
+
+func (a *App) onRevokeSession(e *control.Event) {
+	userID, _ := e.Get("userID")
+	sessionID, _ := e.Get("sessionID")
+
+	var err error
+	if userID.(uint64) == 0 {
+		err = a.client.RevokeSession(sessionID.(uint64))
+	} else {
+		err = a.client.RevokeUserSession(userID.(uint64), sessionID.(uint64))
+	}
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		a.updateSessions(userID.(uint64))
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) updateAccessTokens() {
+	tokens, err := a.client.ListAccessToken()
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetAccessTokens(tokens)
+	}
+}
+
+func (a *App) onListAccessToken(e *control.Event) {
+	a.updateAccessTokens()
+	vecty.Rerender(a)
+}
+
+func (a *App) onCreateAccessToken(e *control.Event) {
+	name, _ := e.Get("name")
+	workerIDs, _ := e.Get("workerIDs")
+	expiredAt, _ := e.Get("expiredAt")
+	resp, err := a.client.CreateAccessToken(name.(string), workerIDs.([]uint64), expiredAt.(int64))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetNewAccessToken(resp.AccessToken)
+		a.updateAccessTokens()
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onRevokeAccessToken(e *control.Event) {
+	accessTokenID, _ := e.Get("accessTokenID")
+	err := a.client.RevokeAccessToken(accessTokenID.(uint64))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		a.updateAccessTokens()
 	}
 
 	vecty.Rerender(a)
@@ -184,6 +460,10 @@ func (a *App) onUpdateWorker(e *control.Event) {
 		err = a.client.UpdateWorkerName(workerID.(uint64), name.(string))
 	} else if desc, ok := e.Get("desc"); ok {
 		err = a.client.UpdateWorkerDesc(workerID.(uint64), desc.(string))
+	} else if maxRunTime, ok := e.Get("maxRunTime"); ok {
+		err = a.client.UpdateWorkerMaxRunTime(workerID.(uint64), maxRunTime.(int64))
+	} else if policy, ok := e.Get("leaseExpiredPolicy"); ok {
+		err = a.client.UpdateWorkerLeaseExpiredPolicy(workerID.(uint64), policy.(int8))
 	} else {
 		return
 	}
@@ -214,7 +494,29 @@ func (a *App) onListTask(e *control.Event) {
 func (a *App) onSendTask(e *control.Event) {
 	workerID, _ := e.Get("workerID")
 	params, _ := e.Get("params")
-	_, err := a.client.SendTask(workerID.(uint64), params.(string))
+	retries, _ := e.Get("retries")
+	priority, _ := e.Get("priority")
+	runAt, _ := e.Get("runAt")
+	_, err := a.client.SendTaskWithOptions(&types.SendTaskRequest{
+		WorkerID: workerID.(uint64),
+		Params:   params.(string),
+		Retries:  retries.(int8),
+		Priority: priority.(int8),
+		RunAt:    runAt.(int64),
+	})
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updateTasks(workerID.(uint64))
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onCancelTask(e *control.Event) {
+	workerID, _ := e.Get("workerID")
+	taskID, _ := e.Get("taskID")
+	err := a.client.CancelTask(taskID.(uint64))
 	if err != nil {
 		a.homeView.SetNode(err.Error())
 	}
@@ -224,6 +526,150 @@ func (a *App) onSendTask(e *control.Event) {
 	vecty.Rerender(a)
 }
 
+func (a *App) updateSchedules(workerID uint64) {
+	schedules, err := a.client.ListSchedule(workerID)
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetSchedules(workerID, schedules)
+	}
+}
+
+func (a *App) onListSchedule(e *control.Event) {
+	workerID, _ := e.Get("workerID")
+	a.updateSchedules(workerID.(uint64))
+	vecty.Rerender(a)
+}
+
+func (a *App) onAddSchedule(e *control.Event) {
+	workerID, _ := e.Get("workerID")
+	req, _ := e.Get("schedule")
+	_, err := a.client.AddSchedule(req.(*types.ScheduleRequest))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updateSchedules(workerID.(uint64))
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onUpdateSchedule(e *control.Event) {
+	workerID, _ := e.Get("workerID")
+	req, _ := e.Get("schedule")
+	err := a.client.UpdateSchedule(req.(*types.ScheduleRequest))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updateSchedules(workerID.(uint64))
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onRemoveSchedule(e *control.Event) {
+	workerID, _ := e.Get("workerID")
+	scheduleID, _ := e.Get("scheduleID")
+	err := a.client.RemoveSchedule(scheduleID.(uint64))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updateSchedules(workerID.(uint64))
+
+	vecty.Rerender(a)
+}
+
+func (a *App) updatePipelines() {
+	pipelines, err := a.client.ListPipeline()
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetPipelines(pipelines)
+	}
+}
+
+func (a *App) onListPipeline(e *control.Event) {
+	a.updatePipelines()
+	vecty.Rerender(a)
+}
+
+func (a *App) onAddPipeline(e *control.Event) {
+	req, _ := e.Get("pipeline")
+	_, err := a.client.AddPipeline(req.(*types.PipelineRequest))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updatePipelines()
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onUpdatePipeline(e *control.Event) {
+	req, _ := e.Get("pipeline")
+	err := a.client.UpdatePipeline(req.(*types.PipelineRequest))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updatePipelines()
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onRemovePipeline(e *control.Event) {
+	pipelineID, _ := e.Get("pipelineID")
+	err := a.client.RemovePipeline(pipelineID.(uint64))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updatePipelines()
+
+	vecty.Rerender(a)
+}
+
+func (a *App) updatePipelineRuns(pipelineID uint64) {
+	resp, err := a.client.ListPipelineRun(pipelineID, 0, 10)
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetPipelineRuns(pipelineID, resp.Total, resp.Runs)
+	}
+}
+
+func (a *App) onRunPipeline(e *control.Event) {
+	pipelineID, _ := e.Get("pipelineID")
+	_, err := a.client.RunPipeline(pipelineID.(uint64))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updatePipelineRuns(pipelineID.(uint64))
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onListPipelineRun(e *control.Event) {
+	pipelineID, _ := e.Get("pipelineID")
+	a.updatePipelineRuns(pipelineID.(uint64))
+	vecty.Rerender(a)
+}
+
+func (a *App) onCancelPipelineRun(e *control.Event) {
+	pipelineID, _ := e.Get("pipelineID")
+	runID, _ := e.Get("runID")
+	err := a.client.CancelPipelineRun(runID.(uint64))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	}
+
+	a.updatePipelineRuns(pipelineID.(uint64))
+
+	vecty.Rerender(a)
+}
+
 func (a *App) updateUsers() {
 	users, err := a.client.ListUser()
 	if err != nil {
@@ -251,13 +697,81 @@ func (a *App) onUpdateUserDetail(e *control.Event) {
 	vecty.Rerender(a)
 }
 
+func (a *App) onAddUser(e *control.Event) {
+	name, _ := e.Get("name")
+	password, _ := e.Get("password")
+	role, _ := e.Get("role")
+	_, err := a.client.AddUser(name.(string), password.(string), role.(string), "")
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		a.updateUsers()
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onRenameUser(e *control.Event) {
+	userID, _ := e.Get("userID")
+	name, _ := e.Get("name")
+	err := a.client.RenameUser(userID.(uint64), name.(string))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		a.updateUsers()
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onDeleteUser(e *control.Event) {
+	userID, _ := e.Get("userID")
+	err := a.client.DeleteUser(userID.(uint64))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		a.updateUsers()
+		a.updateWorkers()
+	}
+
+	vecty.Rerender(a)
+}
+
 func (a *App) onResetUserPassword(e *control.Event) {
 	userID, _ := e.Get("userID")
-	err := a.client.ResetUserPassword(userID.(uint64))
+	password, err := a.client.ResetUserPassword(userID.(uint64))
 	if err != nil {
 		a.homeView.SetNode(err.Error())
-		vecty.Rerender(a)
+	} else {
+		cache.C().SetNewUserPassword(userID.(uint64), password)
 	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onResetUserTOTP(e *control.Event) {
+	userID, _ := e.Get("userID")
+	err := a.client.ResetUserTOTP(userID.(uint64))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		a.updateUsers()
+	}
+
+	vecty.Rerender(a)
+}
+
+func (a *App) onUpdateUserRole(e *control.Event) {
+	userID, _ := e.Get("userID")
+	role, _ := e.Get("role")
+	err := a.client.UpdateUserRole(userID.(uint64), role.(string))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		a.updateUsers()
+	}
+
+	vecty.Rerender(a)
 }
 
 func (a *App) onEnableUser(e *control.Event) {
@@ -285,16 +799,49 @@ func (a *App) onDisableUser(e *control.Event) {
 }
 
 func (a *App) onAddWorker(e *control.Event) {
-	_, err := a.client.AddWorker(fmt.Sprintf("worker%d", time.Now().Unix()), "; Task command.\n\n(run (-))")
+	resp, err := a.client.AddWorker(fmt.Sprintf("worker%d", time.Now().Unix()), "; Task command.\n\n(run (-))")
 	if err != nil {
 		a.homeView.SetNode(err.Error())
 	} else {
+		cache.C().SetNewWorkerKey(resp.WorkerID, resp.WorkerKey)
+		a.createEnrollToken(resp.WorkerID)
 		a.updateWorkers()
 	}
 
 	vecty.Rerender(a)
 }
 
+func (a *App) onCreateEnrollToken(e *control.Event) {
+	workerID, _ := e.Get("workerID")
+	a.createEnrollToken(workerID.(uint64))
+
+	vecty.Rerender(a)
+}
+
+func (a *App) createEnrollToken(workerID uint64) {
+	resp, err := a.client.CreateEnrollToken(workerID, 0)
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+		return
+	}
+
+	cache.C().SetNewEnrollCommand(resp.WorkerID,
+		fmt.Sprintf("routeragt enroll --server %s --token %s", serverURL(), resp.EnrollToken))
+}
+
+func (a *App) onRotateWorkerKey(e *control.Event) {
+	workerID, _ := e.Get("workerID")
+	grace, _ := e.Get("grace")
+	resp, err := a.client.RotateWorkerKey(workerID.(uint64), grace.(time.Duration))
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetNewWorkerKey(resp.WorkerID, resp.WorkerKey)
+	}
+
+	vecty.Rerender(a)
+}
+
 func (a *App) onEnableWorker(e *control.Event) {
 	workerID, _ := e.Get("workerID")
 	err := a.client.EnableWorker(workerID.(uint64))
@@ -322,7 +869,8 @@ func (a *App) onDisableWorker(e *control.Event) {
 func (a *App) onAddWorkerUser(e *control.Event) {
 	workerID, _ := e.Get("workerID")
 	userID, _ := e.Get("userID")
-	err := a.client.AddWorkerUser(workerID.(uint64), userID.(uint64))
+	level, _ := e.Get("level")
+	err := a.client.AddWorkerUser(workerID.(uint64), userID.(uint64), level.(int8))
 	if err != nil {
 		a.homeView.SetNode(err.Error())
 	} else {
@@ -356,3 +904,23 @@ func (a *App) onRemoveWorker(e *control.Event) {
 
 	vecty.Rerender(a)
 }
+
+func (a *App) onListAudit(e *control.Event) {
+	action, _ := e.Get("action")
+	target, _ := e.Get("target")
+	offset, _ := e.Get("offset")
+	limit, _ := e.Get("limit")
+	resp, err := a.client.ListAudit(&types.ListAuditRequest{
+		Action: action.(string),
+		Target: target.(string),
+		Offset: offset.(int64),
+		Limit:  limit.(int64),
+	})
+	if err != nil {
+		a.homeView.SetNode(err.Error())
+	} else {
+		cache.C().SetAuditEvents(resp.Total, resp.Events)
+	}
+
+	vecty.Rerender(a)
+}
@@ -12,8 +12,14 @@ func C() *Cache {
 
 type Cache struct {
 	username string
-	users    []*types.User
-	userIdx  map[uint64]*types.User
+	role     string
+	provider string
+
+	mustChangePassword bool
+	maxTaskPriority    int8
+
+	users   []*types.User
+	userIdx map[uint64]*types.User
 
 	workers   []*types.Worker
 	workerIdx map[uint64]*types.Worker
@@ -21,6 +27,36 @@ type Cache struct {
 	taskWorkerID uint64
 	totalTask    int64
 	tasks        []*types.Task
+
+	scheduleWorkerID uint64
+	schedules        []*types.Schedule
+
+	pipelines             []*types.Pipeline
+	pipelineRunPipelineID uint64
+	totalPipelineRun      int64
+	pipelineRuns          []*types.PipelineRun
+
+	sessionUserID uint64
+	sessions      []*types.Session
+
+	totpEnabled       bool
+	totpRequired      bool
+	recoveryCodesLeft int
+	newTOTP           *types.SetupTOTPResponse
+	newRecoveryCodes  []string
+
+	accessTokens   []*types.AccessToken
+	newAccessToken string
+
+	newWorkerKeyID   uint64
+	newWorkerKey     string
+	newEnrollCommand string
+
+	newUserPasswordID uint64
+	newUserPassword   string
+
+	totalAudit  int64
+	auditEvents []*types.AuditEvent
 }
 
 func (c *Cache) Username() string {
@@ -31,6 +67,41 @@ func (c *Cache) SetUsername(username string) {
 	c.username = username
 }
 
+func (c *Cache) Role() string {
+	return c.role
+}
+
+func (c *Cache) SetRole(role string) {
+	c.role = role
+}
+
+// Provider is the external auth provider of the current user, empty for local users.
+func (c *Cache) Provider() string {
+	return c.provider
+}
+
+func (c *Cache) SetProvider(provider string) {
+	c.provider = provider
+}
+
+// MustChangePassword reports whether the current user must change the reset password.
+func (c *Cache) MustChangePassword() bool {
+	return c.mustChangePassword
+}
+
+func (c *Cache) SetMustChangePassword(mustChangePassword bool) {
+	c.mustChangePassword = mustChangePassword
+}
+
+// MaxTaskPriority is the highest task priority the current user can send.
+func (c *Cache) MaxTaskPriority() int8 {
+	return c.maxTaskPriority
+}
+
+func (c *Cache) SetMaxTaskPriority(priority int8) {
+	c.maxTaskPriority = priority
+}
+
 func (c *Cache) Users() []*types.User {
 	return c.users
 }
@@ -91,8 +162,167 @@ func (c *Cache) SetTasks(workerID uint64, total int64, tasks []*types.Task) {
 	c.tasks = tasks
 }
 
+func (c *Cache) ScheduleWorkerID() uint64 {
+	return c.scheduleWorkerID
+}
+
+func (c *Cache) Schedules() []*types.Schedule {
+	return c.schedules
+}
+
+func (c *Cache) SetSchedules(workerID uint64, schedules []*types.Schedule) {
+	c.scheduleWorkerID = workerID
+	c.schedules = schedules
+}
+
+func (c *Cache) Pipelines() []*types.Pipeline {
+	return c.pipelines
+}
+
+func (c *Cache) SetPipelines(pipelines []*types.Pipeline) {
+	c.pipelines = pipelines
+}
+
+func (c *Cache) PipelineRunPipelineID() uint64 {
+	return c.pipelineRunPipelineID
+}
+
+func (c *Cache) TotalPipelineRun() int64 {
+	return c.totalPipelineRun
+}
+
+func (c *Cache) PipelineRuns() []*types.PipelineRun {
+	return c.pipelineRuns
+}
+
+func (c *Cache) SetPipelineRuns(pipelineID uint64, total int64, runs []*types.PipelineRun) {
+	c.pipelineRunPipelineID = pipelineID
+	c.totalPipelineRun = total
+	c.pipelineRuns = runs
+}
+
+func (c *Cache) SessionUserID() uint64 {
+	return c.sessionUserID
+}
+
+func (c *Cache) Sessions() []*types.Session {
+	return c.sessions
+}
+
+func (c *Cache) SetSessions(userID uint64, sessions []*types.Session) {
+	c.sessionUserID = userID
+	c.sessions = sessions
+}
+
+func (c *Cache) TOTPEnabled() bool {
+	return c.totpEnabled
+}
+
+// TOTPRequired reports whether the current user must enroll 2FA.
+func (c *Cache) TOTPRequired() bool {
+	return c.totpRequired
+}
+
+func (c *Cache) RecoveryCodesLeft() int {
+	return c.recoveryCodesLeft
+}
+
+func (c *Cache) SetTOTP(enabled, required bool, recoveryCodesLeft int) {
+	c.totpEnabled = enabled
+	c.totpRequired = required
+	c.recoveryCodesLeft = recoveryCodesLeft
+}
+
+// NewTOTP is the 2FA secret being set up, which can't be fetched again from server.
+func (c *Cache) NewTOTP() *types.SetupTOTPResponse {
+	return c.newTOTP
+}
+
+func (c *Cache) SetNewTOTP(totp *types.SetupTOTPResponse) {
+	c.newTOTP = totp
+}
+
+// NewRecoveryCodes are the last generated recovery codes, which can't be fetched again from server.
+func (c *Cache) NewRecoveryCodes() []string {
+	return c.newRecoveryCodes
+}
+
+func (c *Cache) SetNewRecoveryCodes(codes []string) {
+	c.newRecoveryCodes = codes
+}
+
+func (c *Cache) AccessTokens() []*types.AccessToken {
+	return c.accessTokens
+}
+
+func (c *Cache) SetAccessTokens(tokens []*types.AccessToken) {
+	c.accessTokens = tokens
+}
+
+// NewAccessToken is the plain text of the last created access token,
+// which can't be fetched again from server.
+func (c *Cache) NewAccessToken() string {
+	return c.newAccessToken
+}
+
+func (c *Cache) SetNewAccessToken(token string) {
+	c.newAccessToken = token
+}
+
+// NewUserPassword is the one-time password of the last reset user.
+func (c *Cache) NewUserPassword() (uint64, string) {
+	return c.newUserPasswordID, c.newUserPassword
+}
+
+func (c *Cache) SetNewUserPassword(userID uint64, password string) {
+	c.newUserPasswordID = userID
+	c.newUserPassword = password
+}
+
+// NewWorkerKey is the plain text of the last added or rotated worker key,
+// which can't be fetched again from server.
+func (c *Cache) NewWorkerKey() (uint64, string) {
+	return c.newWorkerKeyID, c.newWorkerKey
+}
+
+func (c *Cache) SetNewWorkerKey(workerID uint64, key string) {
+	c.newWorkerKeyID = workerID
+	c.newWorkerKey = key
+	c.newEnrollCommand = ""
+}
+
+// NewEnrollCommand is the agent command to enroll with the last created
+// enrollment token of worker NewWorkerKey returns.
+func (c *Cache) NewEnrollCommand() string {
+	return c.newEnrollCommand
+}
+
+func (c *Cache) SetNewEnrollCommand(workerID uint64, command string) {
+	if c.newWorkerKeyID != workerID {
+		c.newWorkerKeyID = workerID
+		c.newWorkerKey = ""
+	}
+	c.newEnrollCommand = command
+}
+
+func (c *Cache) TotalAudit() int64 {
+	return c.totalAudit
+}
+
+func (c *Cache) AuditEvents() []*types.AuditEvent {
+	return c.auditEvents
+}
+
+func (c *Cache) SetAuditEvents(total int64, events []*types.AuditEvent) {
+	c.totalAudit = total
+	c.auditEvents = events
+}
+
 func (c *Cache) Clear() {
 	c.username = ""
+	c.role = ""
+	c.provider = ""
+	c.mustChangePassword = false
 	c.users = nil
 	c.userIdx = nil
 
@@ -102,4 +332,34 @@ func (c *Cache) Clear() {
 	c.taskWorkerID = 0
 	c.totalTask = 0
 	c.tasks = nil
+
+	c.scheduleWorkerID = 0
+	c.schedules = nil
+
+	c.pipelines = nil
+	c.pipelineRunPipelineID = 0
+	c.totalPipelineRun = 0
+	c.pipelineRuns = nil
+
+	c.sessionUserID = 0
+	c.sessions = nil
+
+	c.totpEnabled = false
+	c.totpRequired = false
+	c.recoveryCodesLeft = 0
+	c.newTOTP = nil
+	c.newRecoveryCodes = nil
+
+	c.accessTokens = nil
+	c.newAccessToken = ""
+
+	c.newWorkerKeyID = 0
+	c.newWorkerKey = ""
+	c.newEnrollCommand = ""
+
+	c.newUserPasswordID = 0
+	c.newUserPassword = ""
+
+	c.totalAudit = 0
+	c.auditEvents = nil
 }
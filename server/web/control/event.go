@@ -4,20 +4,54 @@ package control
 const (
 	ERerender = "rerender"
 
-	ELogin  = "login"
-	ELogout = "logout"
+	ELogin    = "login"
+	ELogin2FA = "login-2fa"
+	ELogout   = "logout"
+
+	EExternalLogin = "external-login"
 
 	EUpdatePassword = "update-password"
 
+	ESetupTOTP               = "setup-totp"
+	EEnableTOTP              = "enable-totp"
+	EDisableTOTP             = "disable-totp"
+	ERegenerateRecoveryCodes = "regenerate-recovery-codes"
+
+	EListSession   = "list-session"
+	ERevokeSession = "revoke-session"
+
+	EListAccessToken   = "list-access-token"
+	ECreateAccessToken = "create-access-token"
+	ERevokeAccessToken = "revoke-access-token"
+
 	EListWorker   = "list-worker"
 	EUpdateWorker = "update-worker"
 
-	EListTask = "list-task"
-	ESendTask = "send-task"
+	EListTask   = "list-task"
+	ESendTask   = "send-task"
+	ECancelTask = "cancel-task"
+
+	EListSchedule   = "list-schedule"
+	EAddSchedule    = "add-schedule"
+	EUpdateSchedule = "update-schedule"
+	ERemoveSchedule = "remove-schedule"
+
+	EListPipeline      = "list-pipeline"
+	EAddPipeline       = "add-pipeline"
+	EUpdatePipeline    = "update-pipeline"
+	ERemovePipeline    = "remove-pipeline"
+	ERunPipeline       = "run-pipeline"
+	EListPipelineRun   = "list-pipeline-run"
+	ECancelPipelineRun = "cancel-pipeline-run"
 
 	EListUser          = "list-user"
+	EAddUser           = "add-user"
+	ERenameUser        = "rename-user"
+	EDeleteUser        = "delete-user"
 	EUpdateUserDetail  = "update-user-detail"
 	EResetUserPassword = "reset-user-password"
+	EResetUserTOTP     = "reset-user-totp"
+	EUpdateUserRole    = "update-user-role"
 	EEnableUser        = "enable-user"
 	EDisableUser       = "disable-user"
 	EAddWorker         = "add-worker"
@@ -26,6 +60,10 @@ const (
 	EAddWorkerUser     = "add-worker-user"
 	ERemoveWorkerUser  = "remove-worker-user"
 	ERemoveWorker      = "remove-worker"
+	ERotateWorkerKey   = "rotate-worker-key"
+	ECreateEnrollToken = "create-enroll-token"
+
+	EListAudit = "list-audit"
 )
 
 type Event struct {
@@ -8,6 +8,32 @@ func localStore(key string, value interface{}) {
 	js.Global().Get("localStorage").Set(key, value)
 }
 
+// serverURL is the url of the server which serves this app.
+func serverURL() string {
+	return js.Global().Get("location").Get("origin").String()
+}
+
+// queryParam returns the parameter key of the url query string.
+func queryParam(key string) string {
+	params := js.Global().Get("URLSearchParams").New(js.Global().Get("location").Get("search"))
+	value := params.Call("get", key)
+	if value == js.Null() {
+		return ""
+	}
+
+	return value.String()
+}
+
+// clearQuery removes the url query string without reloading.
+func clearQuery() {
+	js.Global().Get("history").Call("replaceState", nil, "", js.Global().Get("location").Get("pathname"))
+}
+
+// redirect sends the browser to url.
+func redirect(url string) {
+	js.Global().Get("location").Set("href", url)
+}
+
 func localLoad(key string) js.Value {
 	return js.Global().Get("localStorage").Get(key)
 }
@@ -0,0 +1,262 @@
+package view
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tidyoux/router/server/web/cache"
+	"github.com/tidyoux/router/server/web/control"
+	"github.com/gopherjs/vecty"
+	"github.com/gopherjs/vecty/elem"
+)
+
+var accessTokenExpires = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"never", 0},
+	{"7 days", 7 * 24 * time.Hour},
+	{"30 days", 30 * 24 * time.Hour},
+	{"90 days", 90 * 24 * time.Hour},
+}
+
+type AccessTokens struct {
+	vecty.Core
+	Modal
+
+	name      string
+	expire    string
+	workerIDs map[uint64]bool
+}
+
+func NewAccessTokens() *AccessTokens {
+	return &AccessTokens{}
+}
+
+func (view *AccessTokens) Load() {
+	view.expire = accessTokenExpires[0].name
+	view.workerIDs = make(map[uint64]bool)
+	cache.C().SetNewAccessToken("")
+	control.DispatchEvent(control.NewEvent(control.EListAccessToken))
+}
+
+func (view *AccessTokens) Reset() {
+	view.name = ""
+	view.expire = ""
+	view.workerIDs = nil
+	cache.C().SetNewAccessToken("")
+	view.Modal.Reset()
+}
+
+func (view *AccessTokens) create() {
+	var expiredAt int64
+	for _, e := range accessTokenExpires {
+		if e.name == view.expire && e.duration > 0 {
+			expiredAt = time.Now().Add(e.duration).Unix()
+		}
+	}
+
+	workerIDs := make([]uint64, 0, len(view.workerIDs))
+	for id, ok := range view.workerIDs {
+		if ok {
+			workerIDs = append(workerIDs, id)
+		}
+	}
+
+	control.DispatchEvent(
+		control.NewEvent(control.ECreateAccessToken).
+			Set("name", view.name).
+			Set("workerIDs", workerIDs).
+			Set("expiredAt", expiredAt))
+}
+
+func (view *AccessTokens) Render() vecty.ComponentOrHTML {
+	return view.Modal.Render("Access tokens:", elem.Div(
+		view.renderNewToken(),
+		view.renderList(),
+		view.renderCreate(),
+	), view.Reset)
+}
+
+func (view *AccessTokens) renderNewToken() *vecty.HTML {
+	if len(cache.C().NewAccessToken()) == 0 {
+		return elem.Div()
+	}
+
+	return elem.Div(
+		addClass("notification", "is-success"),
+		elem.Paragraph(
+			addText("Copy the new access token now, it won't be shown again:"),
+		),
+		elem.Paragraph(
+			addClass("is-family-monospace"),
+			addText(cache.C().NewAccessToken()),
+		),
+	)
+}
+
+func (view *AccessTokens) renderList() *vecty.HTML {
+	var (
+		weights    = []int{3, 3, 2, 2, 1}
+		titleColor = "is-info"
+		nodes      = make([]vecty.MarkupOrChild, 0, 1+len(cache.C().AccessTokens()))
+	)
+
+	nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Name"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Workers"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("ExpiredAt"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("LastUsedAt"),
+		),
+
+		elem.Span(),
+	}, weights))
+
+	formatTime := func(t int64, zero string) string {
+		if t == 0 {
+			return zero
+		}
+		return time.Unix(t, 0).Format("2006-01-02 15:04")
+	}
+
+	for i := 0; i < len(cache.C().AccessTokens()); i++ {
+		token := cache.C().AccessTokens()[i]
+
+		workers := "all"
+		if len(token.WorkerIDs) > 0 {
+			workers = ""
+			for _, id := range token.WorkerIDs {
+				name := fmt.Sprintf("#%d", id)
+				if worker, ok := cache.C().WorkerByID(id); ok {
+					name = worker.Name
+				}
+
+				if len(workers) > 0 {
+					workers += ","
+				}
+				workers += name
+			}
+		}
+
+		nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+			elem.Span(
+				addClass("tag", "has-text-link"),
+				addText(token.Name),
+			),
+
+			elem.Span(
+				addClass("is-size-7"),
+				addText(workers),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(formatTime(token.ExpiredAt, "never")),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(formatTime(token.LastUsedAt, "-")),
+			),
+
+			elem.Anchor(
+				addClass("button", "is-small", "has-text-danger"),
+				addText("Revoke"),
+				onClick(func() {
+					control.DispatchEvent(
+						control.NewEvent(control.ERevokeAccessToken).
+							Set("accessTokenID", token.ID))
+				}),
+			),
+		}, weights))
+	}
+
+	return elem.Div(nodes...)
+}
+
+func (view *AccessTokens) renderCreate() *vecty.HTML {
+	options := make([]string, 0, len(accessTokenExpires))
+	for _, e := range accessTokenExpires {
+		options = append(options, e.name)
+	}
+
+	workerNodes := make([]vecty.MarkupOrChild, 0, 1+len(cache.C().Workers()))
+	workerNodes = append(workerNodes, addClass("field", "is-grouped", "is-grouped-multiline"))
+	for i := 0; i < len(cache.C().Workers()); i++ {
+		worker := cache.C().Workers()[i]
+		workerNodes = append(workerNodes, elem.Div(
+			addClass("control"),
+			elem.Label(
+				addClass("checkbox"),
+				elem.Input(
+					addProprety("type", "checkbox"),
+					addProprety("checked", view.workerIDs[worker.ID]),
+					onCheckChange(func(checked bool) {
+						view.workerIDs[worker.ID] = checked
+					}),
+				),
+				addText(" "+worker.Name),
+			),
+		))
+	}
+
+	return elem.Div(
+		elem.HorizontalRule(),
+
+		elem.Div(
+			addClass("field", "has-addons"),
+
+			elem.Div(
+				addClass("control", "is-expanded"),
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "text"),
+					addProprety("placeholder", "Token name"),
+					addProprety("value", view.name),
+				}, func(s string) {
+					view.name = s
+				}),
+			),
+
+			elem.Div(
+				addClass("control"),
+				addSelect(view.expire, options, func(value string) {
+					view.expire = value
+				}),
+			),
+
+			elem.Div(
+				addClass("control"),
+				elem.Button(
+					addClass("button", "is-success"),
+					addText("Create"),
+					onClick(func() {
+						view.create()
+						view.name = ""
+					}),
+				),
+			),
+		),
+
+		elem.Paragraph(
+			addClass("help"),
+			addText("Restrict to workers, all of yours if none is checked:"),
+		),
+
+		elem.Div(workerNodes...),
+	)
+}
@@ -3,6 +3,7 @@ package view
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/tidyoux/router/common/types"
 	"github.com/tidyoux/router/server/web/cache"
@@ -15,40 +16,196 @@ type Admin struct {
 	vecty.Core
 	updateDetail *UpdateUserDetail
 	resetUser    *ResetUser
+	userSessions *Sessions
 
 	selectWorkerUser *SelectWorkerUser
 	removeWorker     *RemoveWorker
+	rotateWorkerKey  *RotateWorkerKey
+	addUser          *AddUser
+	renameUser       *RenameUser
+	deleteUser       *DeleteUser
+	audit            *Audit
+
+	tab string
 }
 
 func NewAdmin() *Admin {
 	return &Admin{
 		updateDetail:     NewUpdateUserDetail(),
 		resetUser:        NewResetUser(),
+		userSessions:     NewSessions(),
 		selectWorkerUser: NewSelectWorkerUser(),
 		removeWorker:     NewRemoveWorker(),
+		rotateWorkerKey:  NewRotateWorkerKey(),
+		addUser:          NewAddUser(),
+		renameUser:       NewRenameUser(),
+		deleteUser:       NewDeleteUser(),
+		audit:            NewAudit(),
+		tab:              AdminTabManage,
 	}
 }
 
 func (view *Admin) Reset() {
 	view.updateDetail.Reset()
 	view.resetUser.Reset()
+	view.userSessions.Reset()
 	view.selectWorkerUser.Reset()
 	view.removeWorker.Reset()
+	view.rotateWorkerKey.Reset()
+	view.addUser.Reset()
+	view.renameUser.Reset()
+	view.deleteUser.Reset()
+	view.audit.Reset()
+	view.tab = AdminTabManage
 }
 
 func (view *Admin) Render() vecty.ComponentOrHTML {
-	return elem.Div(
-		addColumns(1, []vecty.MarkupOrChild{
+	var content vecty.MarkupOrChild
+	switch view.tab {
+	case AdminTabAudit:
+		content = addColumns(1, []vecty.MarkupOrChild{
+			view.audit,
+		}, []int{10})
+	default:
+		content = addColumns(1, []vecty.MarkupOrChild{
 			view.renderUserList(),
 			view.renderWorkerList(),
-		}, []int{4, 6}),
+		}, []int{4, 6})
+	}
+
+	return elem.Div(
+		view.renderTabs(),
+		view.renderNewUserPassword(),
+		view.renderNewWorkerCredentials(),
+		content,
 		view.updateDetail,
 		view.resetUser,
+		view.userSessions,
 		view.selectWorkerUser,
 		view.removeWorker,
+		view.rotateWorkerKey,
+		view.addUser,
+		view.renameUser,
+		view.deleteUser,
+	)
+}
+
+func (view *Admin) renderTabs() *vecty.HTML {
+	items := make([]vecty.MarkupOrChild, 0, len(AdminTabs))
+	for _, tab := range AdminTabs {
+		tab := tab
+
+		var markups []vecty.MarkupOrChild
+		if tab == view.tab {
+			markups = append(markups, addClass("is-active"))
+		}
+		markups = append(markups, elem.Anchor(
+			addText(tab),
+			onClick(func() {
+				view.tab = tab
+				if tab == AdminTabAudit {
+					view.audit.Load()
+				}
+				rerender()
+			}),
+		))
+
+		items = append(items, elem.ListItem(markups...))
+	}
+
+	return elem.Div(
+		addClass("tabs", "is-centered"),
+		elem.UnorderedList(items...),
+	)
+}
+
+func (view *Admin) renderNewUserPassword() *vecty.HTML {
+	userID, password := cache.C().NewUserPassword()
+	if len(password) == 0 {
+		return elem.Div()
+	}
+
+	name := fmt.Sprintf("#%d", userID)
+	if user, ok := cache.C().UserByID(userID); ok {
+		name = user.Name
+	}
+
+	return elem.Div(
+		addClass("notification", "is-success"),
+		elem.Button(
+			addClass("delete"),
+			onClick(func() {
+				cache.C().SetNewUserPassword(0, "")
+				rerender()
+			}),
+		),
+		elem.Paragraph(
+			addText(fmt.Sprintf("Copy the one-time password of user %s now, it won't be shown again, the user must change it after login:", name)),
+		),
+		elem.Paragraph(
+			addClass("is-family-monospace"),
+			addText(password),
+		),
 	)
 }
 
+func (view *Admin) renderNewWorkerCredentials() *vecty.HTML {
+	var (
+		workerID, key = cache.C().NewWorkerKey()
+		command       = cache.C().NewEnrollCommand()
+	)
+	if len(key) == 0 && len(command) == 0 {
+		return elem.Div()
+	}
+
+	name := fmt.Sprintf("#%d", workerID)
+	if worker, ok := cache.C().WorkerByID(workerID); ok {
+		name = worker.Name
+	}
+
+	nodes := []vecty.MarkupOrChild{
+		addClass("notification", "is-success"),
+		elem.Button(
+			addClass("delete"),
+			onClick(func() {
+				cache.C().SetNewWorkerKey(0, "")
+				rerender()
+			}),
+		),
+	}
+
+	if len(command) > 0 {
+		nodes = append(nodes,
+			elem.Paragraph(
+				addText(fmt.Sprintf("Run on the agent host to enroll worker %s (id: %d), the token can be used once:", name, workerID)),
+			),
+			elem.Paragraph(
+				addClass("is-family-monospace"),
+				addText(command),
+			),
+		)
+	}
+
+	if len(key) > 0 {
+		text := fmt.Sprintf("Copy the new key of worker %s (id: %d) now, it won't be shown again:", name, workerID)
+		if len(command) > 0 {
+			text = "Or configure the agent by hand with the key, enrolling will replace it:"
+		}
+
+		nodes = append(nodes,
+			elem.Paragraph(
+				addText(text),
+			),
+			elem.Paragraph(
+				addClass("is-family-monospace"),
+				addText(key),
+			),
+		)
+	}
+
+	return elem.Div(nodes...)
+}
+
 func (view *Admin) renderUserList() *vecty.HTML {
 	nodes := make([]vecty.MarkupOrChild, 0, len(cache.C().Users())+2)
 	nodes = append(nodes, addClass("panal"))
@@ -95,8 +252,20 @@ func (view *Admin) renderUserList() *vecty.HTML {
 			elem.Span(
 				addClass("tag", "has-text-link"),
 				addText(user.Name),
+				onClick(func() {
+					view.renameUser.SetUser(user)
+					view.renameUser.Active()
+					rerender()
+				}),
 			),
 
+			addSelect(user.Role, types.Roles, func(role string) {
+				control.DispatchEvent(
+					control.NewEvent(control.EUpdateUserRole).
+						Set("userID", user.ID).
+						Set("role", role))
+			}),
+
 			elem.Div(
 				addClass("control"),
 
@@ -128,30 +297,77 @@ func (view *Admin) renderUserList() *vecty.HTML {
 					),
 				),
 
+				elem.Div(
+					addClass("control"),
+					elem.Button(
+						addClass("button"),
+						addText("Sessions"),
+						onClick(func() {
+							view.userSessions.SetUser(user.ID, user.Name)
+							view.userSessions.Active()
+							rerender()
+						}),
+					),
+				),
+
 				elem.Div(
 					addClass("control"),
 					elem.Button(
 						addClass("button", "has-text-danger"),
 						addText("Reset"),
 						onClick(func() {
-							view.resetUser.SetUser(user.ID)
+							view.resetUser.SetUser(user.ID, user.TOTPEnabled)
 							view.resetUser.Active()
 							rerender()
 						}),
 					),
 				),
+
+				elem.Div(
+					addClass("control"),
+					elem.Button(
+						addClass("button", "has-text-danger"),
+						elem.Span(
+							addClass("icon"),
+							addIcon("trash-alt"),
+						),
+						onClick(func() {
+							view.deleteUser.SetUser(user.ID)
+							view.deleteUser.Active()
+							rerender()
+						}),
+					),
+				),
 			),
 		))
 	}
 
+	nodes = append(nodes, elem.Div(
+		addClass("panel-block"),
+
+		elem.Button(
+			addClass("button", "is-outlined", "is-fullwidth"),
+
+			elem.Span(
+				addClass("panel-icon", "has-text-success"),
+				addIcon("user-plus"),
+			),
+
+			onClick(func() {
+				view.addUser.Active()
+				rerender()
+			}),
+		),
+	))
+
 	return elem.Div(nodes...)
 }
 
-func (view *Admin) userNames(userIDs []uint64) []string {
-	names := make([]string, 0, len(userIDs))
-	for _, id := range userIDs {
-		if u, ok := cache.C().UserByID(id); ok {
-			names = append(names, u.Name)
+func (view *Admin) userNames(grants []*types.WorkerGrant) []string {
+	names := make([]string, 0, len(grants))
+	for _, g := range grants {
+		if u, ok := cache.C().UserByID(g.UserID); ok {
+			names = append(names, u.Name+":"+types.GrantLevelNames[g.Level])
 		}
 	}
 	return names
@@ -187,7 +403,7 @@ func (view *Admin) renderWorkerList() *vecty.HTML {
 		)
 		if len(worker.Users) > 0 {
 			usersColor = "has-text-info"
-			userNames = "[" + strings.Join(view.userNames(worker.Users), "|") + "]"
+			userNames = "[" + strings.Join(view.userNames(worker.Grants), "|") + "]"
 		}
 
 		nodes = append(nodes, elem.Div(
@@ -236,6 +452,38 @@ func (view *Admin) renderWorkerList() *vecty.HTML {
 					),
 				),
 
+				elem.Div(
+					addClass("control"),
+					elem.Button(
+						addClass("button"),
+						elem.Span(
+							addClass("icon"),
+							addIcon("key"),
+						),
+						onClick(func() {
+							view.rotateWorkerKey.SetWorker(worker.ID, worker.Name)
+							view.rotateWorkerKey.Active()
+							rerender()
+						}),
+					),
+				),
+
+				elem.Div(
+					addClass("control"),
+					elem.Button(
+						addClass("button"),
+						elem.Span(
+							addClass("icon"),
+							addIcon("terminal"),
+						),
+						onClick(func() {
+							control.DispatchEvent(
+								control.NewEvent(control.ECreateEnrollToken).
+									Set("workerID", worker.ID))
+						}),
+					),
+				),
+
 				elem.Div(
 					addClass("control"),
 					elem.Button(
@@ -351,37 +599,313 @@ func (view *UpdateUserDetail) Render() vecty.ComponentOrHTML {
 	), view.Reset)
 }
 
-type ResetUser struct {
+type AddUser struct {
+	vecty.Core
+	Modal
+
+	name     string
+	password string
+	role     string
+}
+
+func NewAddUser() *AddUser {
+	return &AddUser{
+		role: types.RoleOperator,
+	}
+}
+
+func (view *AddUser) Reset() {
+	view.name = ""
+	view.password = ""
+	view.role = types.RoleOperator
+	view.Modal.Reset()
+}
+
+func (view *AddUser) Render() vecty.ComponentOrHTML {
+	return view.Modal.Render("Add user:", elem.Div(
+		elem.Div(
+			addClass("field"),
+
+			elem.Div(
+				addClass("control", "has-icons-left", "is-expanded"),
+
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "text"),
+					addProprety("placeholder", "Username"),
+					addProprety("value", view.name),
+				}, func(s string) {
+					view.name = s
+				}),
+
+				elem.Span(
+					addClass("icon", "is-small", "is-left"),
+					addIcon("user"),
+				),
+			),
+		),
+
+		elem.Div(
+			addClass("field"),
+
+			elem.Div(
+				addClass("control", "has-icons-left", "is-expanded"),
+
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "password"),
+					addProprety("placeholder", "Password"),
+					addProprety("value", view.password),
+				}, func(s string) {
+					view.password = s
+				}),
+
+				elem.Span(
+					addClass("icon", "is-small", "is-left"),
+					addIcon("lock"),
+				),
+			),
+		),
+
+		elem.Div(
+			addClass("field", "is-grouped"),
+
+			elem.Div(
+				addClass("control"),
+				addSelect(view.role, types.Roles, func(role string) {
+					view.role = role
+				}),
+			),
+
+			elem.Div(
+				addClass("control"),
+
+				elem.Anchor(
+					addClass("button", "is-success"),
+					elem.Span(
+						addText("Add"),
+					),
+					onClick(func() {
+						control.DispatchEvent(
+							control.NewEvent(control.EAddUser).
+								Set("name", view.name).
+								Set("password", view.password).
+								Set("role", view.role))
+
+						view.Reset()
+						vecty.Rerender(view)
+					}),
+				),
+			),
+		),
+	), view.Reset)
+}
+
+type RenameUser struct {
+	vecty.Core
+	Modal
+	user *types.User
+
+	name string
+}
+
+func NewRenameUser() *RenameUser {
+	return &RenameUser{}
+}
+
+func (view *RenameUser) SetUser(user *types.User) {
+	view.user = user
+	view.name = user.Name
+}
+
+func (view *RenameUser) Reset() {
+	view.user = nil
+	view.name = ""
+	view.Modal.Reset()
+}
+
+func (view *RenameUser) Render() vecty.ComponentOrHTML {
+	return view.Modal.Render("Rename user:", elem.Div(
+		elem.Div(
+			addClass("field", "has-addons"),
+
+			elem.Div(
+				addClass("control", "has-icons-left", "is-expanded"),
+
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "text"),
+					addProprety("placeholder", "Username"),
+					addProprety("value", view.name),
+				}, func(s string) {
+					view.name = s
+				}),
+
+				elem.Span(
+					addClass("icon", "is-small", "is-left"),
+					addIcon("user"),
+				),
+			),
+
+			elem.Div(
+				addClass("control"),
+
+				elem.Anchor(
+					addClass("button", "is-success"),
+					elem.Span(
+						addClass("icon"),
+						addIcon("paper-plane"),
+					),
+					onClick(func() {
+						if view.user != nil && view.name != view.user.Name {
+							control.DispatchEvent(
+								control.NewEvent(control.ERenameUser).
+									Set("userID", view.user.ID).
+									Set("name", view.name))
+						}
+
+						view.Reset()
+						vecty.Rerender(view)
+					}),
+				),
+			),
+		),
+	), view.Reset)
+}
+
+type DeleteUser struct {
 	vecty.Core
 	Modal
 	userID uint64
+
+	confirm string
+}
+
+func NewDeleteUser() *DeleteUser {
+	return &DeleteUser{}
+}
+
+func (view *DeleteUser) SetUser(userID uint64) {
+	view.userID = userID
+}
+
+func (view *DeleteUser) Reset() {
+	view.userID = 0
+	view.confirm = ""
+	view.Modal.Reset()
+}
+
+func (view *DeleteUser) Render() vecty.ComponentOrHTML {
+	var confirmBtn *vecty.HTML
+	if strings.EqualFold(view.confirm, RemoveWorkerConfirm) {
+		confirmBtn = elem.Anchor(
+			addClass("button", "is-fullwidth"),
+			elem.Span(
+				addClass("has-text-danger"),
+				addText("I understand the consequences, delete this user"),
+			),
+			onClick(func() {
+				control.DispatchEvent(
+					control.NewEvent(control.EDeleteUser).
+						Set("userID", view.userID))
+				view.Reset()
+			}),
+		)
+	} else {
+		confirmBtn = elem.Anchor(
+			addClass("button", "is-fullwidth"),
+			addAttribute("disabled", ""),
+			elem.Span(
+				addClass("has-text-danger"),
+				addText("I understand the consequences, delete this user"),
+			),
+		)
+	}
+
+	return view.Modal.Render("Are you absolutely sure?", elem.Div(
+		elem.Div(
+			addClass("field"),
+
+			elem.Label(
+				addClass("label"),
+				addText("Please type in"),
+				elem.Code(
+					addText(RemoveWorkerConfirm),
+				),
+				addText("to confirm."),
+			),
+
+			elem.Div(
+				addClass("control", "is-expanded"),
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "text"),
+					addProprety("value", view.confirm),
+				}, func(s string) {
+					view.confirm = s
+					vecty.Rerender(view)
+				}),
+			),
+		),
+
+		confirmBtn,
+	), view.Reset)
+}
+
+type ResetUser struct {
+	vecty.Core
+	Modal
+	userID      uint64
+	totpEnabled bool
 }
 
 func NewResetUser() *ResetUser {
 	return &ResetUser{}
 }
 
-func (view *ResetUser) SetUser(userID uint64) {
+func (view *ResetUser) SetUser(userID uint64, totpEnabled bool) {
 	view.userID = userID
+	view.totpEnabled = totpEnabled
 }
 
 func (view *ResetUser) Reset() {
 	view.userID = 0
+	view.totpEnabled = false
 	view.Modal.Reset()
 }
 
 func (view *ResetUser) Render() vecty.ComponentOrHTML {
-	return view.Modal.Render("Reset user password:", elem.Div(
+	var resetTOTP *vecty.HTML
+	if view.totpEnabled {
+		resetTOTP = elem.Div(
+			elem.HorizontalRule(),
+
+			elem.Anchor(
+				addClass("button", "is-fullwidth"),
+				elem.Span(
+					addClass("has-text-danger"),
+					addText("Turn off 2FA of this user, like when the device is lost"),
+				),
+				onClick(func() {
+					control.DispatchEvent(
+						control.NewEvent(control.EResetUserTOTP).
+							Set("userID", view.userID))
+					view.Reset()
+					vecty.Rerender(view)
+				}),
+			),
+		)
+	} else {
+		resetTOTP = elem.Div()
+	}
+
+	return view.Modal.Render("Reset user:", elem.Div(
 		elem.Div(
 			addClass("field"),
 
 			elem.Label(
 				addClass("label"),
-				addText("Reset user password to"),
-				elem.Code(
-					addText("123456"),
-				),
-				addText("."),
+				addText("Reset user password to a random one-time password, the user must change it after login."),
 			),
 		),
 
@@ -399,15 +923,31 @@ func (view *ResetUser) Render() vecty.ComponentOrHTML {
 				vecty.Rerender(view)
 			}),
 		),
+
+		resetTOTP,
 	), view.Reset)
 }
 
+const (
+	GrantNone = "none"
+)
+
+// Admin tabs.
+const (
+	AdminTabManage = "Users & Workers"
+	AdminTabAudit  = "Audit"
+)
+
+var (
+	AdminTabs = []string{AdminTabManage, AdminTabAudit}
+)
+
 type SelectWorkerUser struct {
 	vecty.Core
 	Modal
 
 	workerID     uint64
-	workerOwners map[uint64]struct{}
+	workerOwners map[uint64]int8
 }
 
 func NewSelectWorkerUser() *SelectWorkerUser {
@@ -416,9 +956,9 @@ func NewSelectWorkerUser() *SelectWorkerUser {
 
 func (view *SelectWorkerUser) SetWorker(worker *types.Worker) {
 	view.workerID = worker.ID
-	view.workerOwners = make(map[uint64]struct{}, len(worker.Users))
-	for _, id := range worker.Users {
-		view.workerOwners[id] = struct{}{}
+	view.workerOwners = make(map[uint64]int8, len(worker.Grants))
+	for _, g := range worker.Grants {
+		view.workerOwners[g.UserID] = g.Level
 	}
 }
 
@@ -429,34 +969,57 @@ func (view *SelectWorkerUser) Reset() {
 }
 
 func (view *SelectWorkerUser) Render() vecty.ComponentOrHTML {
-	nodes := make([]vecty.MarkupOrChild, 0, 1+len(cache.C().Users()))
-	nodes = append(nodes, addClass("level"))
+	options := []string{
+		GrantNone,
+		types.GrantLevelNames[types.GrantView],
+		types.GrantLevelNames[types.GrantRun],
+		types.GrantLevelNames[types.GrantManage],
+	}
+
+	nodes := make([]vecty.MarkupOrChild, 0, len(cache.C().Users()))
 	for i := 0; i < len(cache.C().Users()); i++ {
 		user := cache.C().Users()[i]
-		_, isOwner := view.workerOwners[user.ID]
-
-		nodes = append(nodes, elem.Label(
-			addClass("checkbox"),
-			elem.Input(
-				addProprety("type", "checkbox"),
-				addProprety("checked", isOwner),
-				onCheckChange(func(checked bool) {
-					if checked {
-						view.workerOwners[user.ID] = struct{}{}
-						control.DispatchEvent(
-							control.NewEvent(control.EAddWorkerUser).
-								Set("workerID", view.workerID).
-								Set("userID", user.ID))
-					} else {
+
+		value := GrantNone
+		if level, ok := view.workerOwners[user.ID]; ok {
+			value = types.GrantLevelNames[level]
+		}
+
+		nodes = append(nodes, elem.Div(
+			addClass("level"),
+
+			elem.Div(
+				addClass("level-left"),
+				elem.Span(
+					addClass("level-item"),
+					addText(user.Name),
+				),
+			),
+
+			elem.Div(
+				addClass("level-right"),
+				addSelect(value, options, func(value string) {
+					if value == GrantNone {
 						delete(view.workerOwners, user.ID)
 						control.DispatchEvent(
 							control.NewEvent(control.ERemoveWorkerUser).
 								Set("workerID", view.workerID).
 								Set("userID", user.ID))
+						return
+					}
+
+					for level, name := range types.GrantLevelNames {
+						if name == value {
+							view.workerOwners[user.ID] = level
+							control.DispatchEvent(
+								control.NewEvent(control.EAddWorkerUser).
+									Set("workerID", view.workerID).
+									Set("userID", user.ID).
+									Set("level", level))
+						}
 					}
 				}),
 			),
-			addText(" "+user.Name),
 		))
 	}
 	return view.Modal.Render("Select owners:", elem.Div(nodes...), view.Reset)
@@ -544,3 +1107,87 @@ func (view *RemoveWorker) Render() vecty.ComponentOrHTML {
 		confirmBtn,
 	), view.Reset)
 }
+
+var workerKeyGraces = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"none", 0},
+	{"1 hour", time.Hour},
+	{"1 day", 24 * time.Hour},
+	{"7 days", 7 * 24 * time.Hour},
+}
+
+type RotateWorkerKey struct {
+	vecty.Core
+	Modal
+	workerID   uint64
+	workerName string
+
+	grace string
+}
+
+func NewRotateWorkerKey() *RotateWorkerKey {
+	return &RotateWorkerKey{}
+}
+
+func (view *RotateWorkerKey) SetWorker(workerID uint64, workerName string) {
+	view.workerID = workerID
+	view.workerName = workerName
+	view.grace = workerKeyGraces[0].name
+}
+
+func (view *RotateWorkerKey) Reset() {
+	view.workerID = 0
+	view.workerName = ""
+	view.grace = ""
+	view.Modal.Reset()
+}
+
+func (view *RotateWorkerKey) Render() vecty.ComponentOrHTML {
+	options := make([]string, 0, len(workerKeyGraces))
+	for _, g := range workerKeyGraces {
+		options = append(options, g.name)
+	}
+
+	return view.Modal.Render("Rotate key of "+view.workerName+":", elem.Div(
+		elem.Paragraph(
+			addText("The agents of this worker will be logged out, and must log in with the new key."),
+		),
+
+		elem.Div(
+			addClass("field"),
+
+			elem.Label(
+				addClass("label"),
+				addText("The old key still works for:"),
+			),
+
+			elem.Div(
+				addClass("control"),
+				addSelect(view.grace, options, func(value string) {
+					view.grace = value
+				}),
+			),
+		),
+
+		elem.Anchor(
+			addClass("button", "is-fullwidth", "is-warning"),
+			addText("Rotate key"),
+			onClick(func() {
+				var grace time.Duration
+				for _, g := range workerKeyGraces {
+					if g.name == view.grace {
+						grace = g.duration
+					}
+				}
+
+				control.DispatchEvent(
+					control.NewEvent(control.ERotateWorkerKey).
+						Set("workerID", view.workerID).
+						Set("grace", grace))
+				view.Reset()
+			}),
+		),
+	), view.Reset)
+}
@@ -0,0 +1,227 @@
+package view
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/server/web/cache"
+	"github.com/tidyoux/router/server/web/control"
+	"github.com/gopherjs/vecty"
+	"github.com/gopherjs/vecty/elem"
+)
+
+const (
+	AuditPageSize = 20
+)
+
+type Audit struct {
+	vecty.Core
+
+	action string
+	target string
+	offset int64
+}
+
+func NewAudit() *Audit {
+	return &Audit{}
+}
+
+func (view *Audit) Reset() {
+	view.action = ""
+	view.target = ""
+	view.offset = 0
+	cache.C().SetAuditEvents(0, nil)
+}
+
+func (view *Audit) Load() {
+	control.DispatchEvent(
+		control.NewEvent(control.EListAudit).
+			Set("action", strings.TrimSpace(view.action)).
+			Set("target", strings.TrimSpace(view.target)).
+			Set("offset", view.offset).
+			Set("limit", int64(AuditPageSize)))
+}
+
+func (view *Audit) Render() vecty.ComponentOrHTML {
+	return elem.Div(
+		addClass("box"),
+		view.renderFilter(),
+		view.renderList(),
+		view.renderPagination(),
+	)
+}
+
+func (view *Audit) renderFilter() *vecty.HTML {
+	return elem.Div(
+		addClass("field", "has-addons"),
+
+		elem.Div(
+			addClass("control", "is-expanded"),
+			addInput([]vecty.MarkupOrChild{
+				addClass("input"),
+				addProprety("type", "text"),
+				addProprety("placeholder", "Action, like send-task"),
+				addProprety("value", view.action),
+			}, func(s string) {
+				view.action = s
+			}),
+		),
+
+		elem.Div(
+			addClass("control", "is-expanded"),
+			addInput([]vecty.MarkupOrChild{
+				addClass("input"),
+				addProprety("type", "text"),
+				addProprety("placeholder", "Target, like worker:1"),
+				addProprety("value", view.target),
+			}, func(s string) {
+				view.target = s
+			}),
+		),
+
+		elem.Div(
+			addClass("control"),
+			elem.Button(
+				addClass("button", "is-info"),
+				elem.Span(
+					addClass("icon"),
+					addIcon("search"),
+				),
+				onClick(func() {
+					view.offset = 0
+					view.Load()
+				}),
+			),
+		),
+	)
+}
+
+func (view *Audit) renderList() *vecty.HTML {
+	var (
+		weights    = []int{2, 1, 2, 1, 4, 2}
+		titleColor = "is-info"
+		nodes      = make([]vecty.MarkupOrChild, 0, 1+len(cache.C().AuditEvents()))
+	)
+
+	nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Time"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Actor"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Action"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Target"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Summary"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("IP"),
+		),
+	}, weights))
+
+	for i := 0; i < len(cache.C().AuditEvents()); i++ {
+		event := cache.C().AuditEvents()[i]
+
+		actor := event.Actor
+		if event.AccessTokenID > 0 {
+			actor += fmt.Sprintf(" (token #%d)", event.AccessTokenID)
+		}
+
+		actionColor := "is-success"
+		if len(event.Error) > 0 {
+			actionColor = "is-danger"
+		}
+
+		nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+			elem.Span(
+				addClass("tag"),
+				addText(time.Unix(event.CreatedAt, 0).Format("2006-01-02 15:04:05")),
+			),
+
+			elem.Span(
+				addClass("tag", "has-text-link"),
+				addText(actor),
+			),
+
+			elem.Span(
+				addClass("tag", actionColor),
+				addAttribute("title", event.Error),
+				addText(event.Action),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(event.Target),
+			),
+
+			elem.Span(
+				addClass("is-size-7", "is-family-monospace"),
+				addText(event.Summary),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(event.IP),
+			),
+		}, weights))
+	}
+
+	return elem.Div(nodes...)
+}
+
+func (view *Audit) renderPagination() *vecty.HTML {
+	var (
+		total = cache.C().TotalAudit()
+		prev  = elem.Anchor(
+			addClass("pagination-previous"),
+			addText("Previous"),
+			onClick(func() {
+				if view.offset > 0 {
+					view.offset -= AuditPageSize
+					if view.offset < 0 {
+						view.offset = 0
+					}
+					view.Load()
+				}
+			}),
+		)
+		next = elem.Anchor(
+			addClass("pagination-next"),
+			addText("Next"),
+			onClick(func() {
+				if view.offset+AuditPageSize < cache.C().TotalAudit() {
+					view.offset += AuditPageSize
+					view.Load()
+				}
+			}),
+		)
+	)
+
+	to := view.offset + int64(len(cache.C().AuditEvents()))
+	return elem.Navigation(
+		addClass("pagination", "is-small"),
+		prev,
+		next,
+		elem.Span(
+			addClass("pagination-list"),
+			addText(fmt.Sprintf("%d - %d of %d", view.offset+1, to, total)),
+		),
+	)
+}
@@ -11,6 +11,12 @@ import (
 type Home struct {
 	Base
 	updatePassword *UpdatePassword
+	sessions       *Sessions
+	accessTokens   *AccessTokens
+	twoFactor      *TwoFactor
+	pipelines      *Pipelines
+	editPipeline   *EditPipeline
+	pipelineRuns   *PipelineRuns
 	admin          *Admin
 	worker         *Worker
 
@@ -18,15 +24,36 @@ type Home struct {
 }
 
 func NewHome() *Home {
+	editPipeline, pipelineRuns := NewEditPipeline(), NewPipelineRuns()
 	return &Home{
 		updatePassword: NewUpdatePassword(),
+		sessions:       NewSessions(),
+		accessTokens:   NewAccessTokens(),
+		twoFactor:      NewTwoFactor(),
+		pipelines:      NewPipelines(editPipeline, pipelineRuns),
+		editPipeline:   editPipeline,
+		pipelineRuns:   pipelineRuns,
 		admin:          NewAdmin(),
 		worker:         NewWorker(),
 	}
 }
 
 func (view *Home) Init() {
-	if cache.C().Username() == types.AdminUsername {
+	// Nothing else works before the reset password is changed.
+	if cache.C().MustChangePassword() {
+		view.activeView = view.worker
+		view.updatePassword.Active()
+		return
+	}
+
+	// Nothing else works before the required 2FA is set up.
+	if cache.C().TOTPRequired() && !cache.C().TOTPEnabled() {
+		view.activeView = view.worker
+		view.twoFactor.Active()
+		return
+	}
+
+	if cache.C().Role() == types.RoleAdmin {
 		view.activeView = view.admin
 		control.DispatchEvent(control.NewEvent(control.EListUser))
 	} else {
@@ -36,6 +63,12 @@ func (view *Home) Init() {
 }
 
 func (view *Home) Reset() {
+	view.sessions.Reset()
+	view.accessTokens.Reset()
+	view.twoFactor.Reset()
+	view.pipelines.Reset()
+	view.editPipeline.Reset()
+	view.pipelineRuns.Reset()
 	view.admin.Reset()
 	view.worker.Reset()
 	view.Base.Reset()
@@ -64,6 +97,12 @@ func (view *Home) Render() vecty.ComponentOrHTML {
 			),
 		),
 		view.updatePassword,
+		view.sessions,
+		view.accessTokens,
+		view.twoFactor,
+		view.pipelines,
+		view.editPipeline,
+		view.pipelineRuns,
 	)
 }
 
@@ -87,12 +126,96 @@ func (view *Home) renderNavbar() *vecty.HTML {
 						addText(cache.C().Username()),
 					),
 					onClick(func() {
+						// Passwords of external users are kept by their auth provider.
+						if len(cache.C().Provider()) > 0 {
+							return
+						}
+
 						view.updatePassword.Active()
 						rerender()
 					}),
 				),
 			),
 
+			elem.Div(
+				addClass("navbar-item"),
+
+				elem.Anchor(
+					addClass("button"),
+					elem.Span(
+						addClass("icon"),
+						addIcon("project-diagram"),
+					),
+					elem.Span(
+						addText("Pipelines"),
+					),
+					onClick(func() {
+						view.pipelines.Load()
+						view.pipelines.Active()
+						rerender()
+					}),
+				),
+			),
+
+			elem.Div(
+				addClass("navbar-item"),
+
+				elem.Anchor(
+					addClass("button"),
+					elem.Span(
+						addClass("icon"),
+						addIcon("desktop"),
+					),
+					elem.Span(
+						addText("Sessions"),
+					),
+					onClick(func() {
+						view.sessions.SetUser(0, "")
+						view.sessions.Active()
+						rerender()
+					}),
+				),
+			),
+
+			elem.Div(
+				addClass("navbar-item"),
+
+				elem.Anchor(
+					addClass("button"),
+					elem.Span(
+						addClass("icon"),
+						addIcon("key"),
+					),
+					elem.Span(
+						addText("Tokens"),
+					),
+					onClick(func() {
+						view.accessTokens.Load()
+						view.accessTokens.Active()
+						rerender()
+					}),
+				),
+			),
+
+			vecty.If(len(cache.C().Provider()) == 0, elem.Div(
+				addClass("navbar-item"),
+
+				elem.Anchor(
+					addClass("button"),
+					elem.Span(
+						addClass("icon"),
+						addIcon("shield-alt"),
+					),
+					elem.Span(
+						addText("2FA"),
+					),
+					onClick(func() {
+						view.twoFactor.Active()
+						rerender()
+					}),
+				),
+			)),
+
 			elem.Div(
 				addClass("navbar-item"),
 
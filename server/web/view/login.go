@@ -11,6 +11,13 @@ type Login struct {
 
 	username string
 	password string
+
+	// twoFactor is true when the password has passed and the 2FA code is waited.
+	twoFactor bool
+	code      string
+
+	// providers are the names of the external auth providers.
+	providers []string
 }
 
 func NewLogin() *Login {
@@ -20,10 +27,25 @@ func NewLogin() *Login {
 func (view *Login) Reset() {
 	view.username = ""
 	view.password = ""
+	view.twoFactor = false
+	view.code = ""
 	view.Base.Reset()
 }
 
+func (view *Login) SetTwoFactor(twoFactor bool) {
+	view.twoFactor = twoFactor
+	view.code = ""
+}
+
+func (view *Login) SetProviders(providers []string) {
+	view.providers = providers
+}
+
 func (view *Login) Render() vecty.ComponentOrHTML {
+	if view.twoFactor {
+		return view.renderTwoFactor()
+	}
+
 	return elem.Body(
 		elem.Section(
 			addClass("section"),
@@ -103,6 +125,122 @@ func (view *Login) Render() vecty.ComponentOrHTML {
 								),
 							),
 						),
+
+						view.renderProviders(),
+					),
+				),
+			),
+
+			view.renderNote(4),
+		),
+	)
+}
+
+func (view *Login) renderProviders() *vecty.HTML {
+	if len(view.providers) == 0 {
+		return elem.Div()
+	}
+
+	nodes := make([]vecty.MarkupOrChild, 0, 2+len(view.providers))
+	nodes = append(nodes, elem.HorizontalRule())
+	for i := 0; i < len(view.providers); i++ {
+		provider := view.providers[i]
+		nodes = append(nodes, elem.Div(
+			addClass("field"),
+			elem.Anchor(
+				addClass("button", "is-link", "is-outlined", "is-fullwidth"),
+				elem.Span(
+					addClass("icon"),
+					addIcon("sign-in-alt"),
+				),
+				elem.Span(
+					addText("Login with "+provider),
+				),
+				onClick(func() {
+					view.SetNode("")
+
+					control.DispatchEvent(
+						control.NewEvent(control.EExternalLogin).
+							Set("provider", provider))
+				}),
+			),
+		))
+	}
+
+	return elem.Div(nodes...)
+}
+
+func (view *Login) renderTwoFactor() vecty.ComponentOrHTML {
+	return elem.Body(
+		elem.Section(
+			addClass("section"),
+
+			addCenter(4,
+				elem.Div(
+					addClass("card"),
+
+					elem.Header(
+						addClass("card-header"),
+						elem.Paragraph(
+							addClass("card-header-title"),
+							vecty.Text("Two-factor authentication"),
+						),
+					),
+
+					elem.Div(
+						addClass("card-content"),
+
+						elem.Div(
+							addClass("field"),
+
+							elem.Div(
+								addClass("control", "has-icons-left"),
+
+								addInput([]vecty.MarkupOrChild{
+									addClass("input", "is-primary"),
+									addProprety("type", "text"),
+									addProprety("autocomplete", "one-time-code"),
+									addProprety("placeholder", "Authenticator code or recovery code"),
+								}, func(s string) {
+									view.code = s
+								}),
+
+								elem.Span(
+									addClass("icon", "is-small", "is-left"),
+									addIcon("shield-alt"),
+								),
+							),
+						),
+
+						elem.Div(
+							addClass("field", "is-grouped"),
+							elem.Div(
+								addClass("control"),
+								elem.Anchor(
+									addClass("button", "is-success"),
+									addText("Verify"),
+									onClick(func() {
+										view.SetNode("")
+
+										control.DispatchEvent(
+											control.NewEvent(control.ELogin2FA).
+												Set("code", view.code))
+									}),
+								),
+							),
+							elem.Div(
+								addClass("control"),
+								elem.Anchor(
+									addClass("button", "is-text"),
+									addText("Back"),
+									onClick(func() {
+										view.SetNode("")
+										view.SetTwoFactor(false)
+										rerender()
+									}),
+								),
+							),
+						),
 					),
 				),
 			),
@@ -0,0 +1,725 @@
+package view
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/web/cache"
+	"github.com/tidyoux/router/server/web/control"
+	"github.com/gopherjs/vecty"
+	"github.com/gopherjs/vecty/elem"
+)
+
+type Pipelines struct {
+	vecty.Core
+	Modal
+
+	editPipeline *EditPipeline
+	pipelineRuns *PipelineRuns
+}
+
+func NewPipelines(editPipeline *EditPipeline, pipelineRuns *PipelineRuns) *Pipelines {
+	return &Pipelines{
+		editPipeline: editPipeline,
+		pipelineRuns: pipelineRuns,
+	}
+}
+
+func (view *Pipelines) Load() {
+	control.DispatchEvent(control.NewEvent(control.EListPipeline))
+}
+
+func (view *Pipelines) Render() vecty.ComponentOrHTML {
+	var (
+		weights    = []int{2, 4, 1, 1, 3}
+		titleColor = "is-info"
+		nodes      = make([]vecty.MarkupOrChild, 0, 2+len(cache.C().Pipelines()))
+	)
+
+	nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Pipeline"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Nodes"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("OnFailure"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("By"),
+		),
+
+		elem.Span(),
+	}, weights))
+
+	for i := 0; i < len(cache.C().Pipelines()); i++ {
+		pipeline := cache.C().Pipelines()[i]
+
+		ops := []vecty.MarkupOrChild{
+			addClass("buttons", "are-small"),
+
+			elem.Anchor(
+				addClass("button"),
+				addText("Runs"),
+				onClick(func() {
+					view.pipelineRuns.SetPipeline(pipeline)
+					view.pipelineRuns.Load()
+					view.pipelineRuns.Active()
+					rerender()
+				}),
+			),
+		}
+		if pipeline.CanRun {
+			ops = append(ops,
+				elem.Anchor(
+					addClass("button", "is-success"),
+					addText("Run"),
+					onClick(func() {
+						view.pipelineRuns.SetPipeline(pipeline)
+						view.pipelineRuns.Active()
+						control.DispatchEvent(
+							control.NewEvent(control.ERunPipeline).
+								Set("pipelineID", pipeline.ID))
+					}),
+				),
+
+				elem.Anchor(
+					addClass("button"),
+					addText("Edit"),
+					onClick(func() {
+						view.editPipeline.SetPipeline(pipeline)
+						view.editPipeline.Active()
+						rerender()
+					}),
+				),
+
+				elem.Anchor(
+					addClass("button", "has-text-danger"),
+					addText("Remove"),
+					onClick(func() {
+						control.DispatchEvent(
+							control.NewEvent(control.ERemovePipeline).
+								Set("pipelineID", pipeline.ID))
+					}),
+				),
+			)
+		}
+
+		nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+			elem.Div(
+				elem.Span(
+					addClass("tag", "has-text-link"),
+					addText(pipeline.Name),
+				),
+				elem.Paragraph(
+					addClass("is-size-7"),
+					addText(pipeline.Desc),
+				),
+			),
+
+			renderPipelineNodes(pipeline.Nodes),
+
+			elem.Span(
+				addClass("tag"),
+				addText(types.PipelineFailurePolicyNames[pipeline.FailurePolicy]),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(pipeline.Owner),
+			),
+
+			elem.Div(ops...),
+		}, weights))
+	}
+
+	if len(runnableWorkers()) > 0 {
+		nodes = append(nodes, elem.Anchor(
+			addClass("button", "is-success"),
+			addText("New"),
+			onClick(func() {
+				view.editPipeline.SetPipeline(nil)
+				view.editPipeline.Active()
+				rerender()
+			}),
+		))
+	}
+
+	return view.Modal.Render("Pipelines:", elem.Div(nodes...), nil)
+}
+
+// renderPipelineNodes renders the nodes in stages, each stage needs only the stages before it.
+func renderPipelineNodes(nodes []*types.PipelineNode) *vecty.HTML {
+	stages := make(map[string]int, len(nodes))
+	var lines [][]*types.PipelineNode
+	for _, node := range nodes {
+		stage := 0
+		for _, need := range node.Needs {
+			if stages[need]+1 > stage {
+				stage = stages[need] + 1
+			}
+		}
+		stages[node.Name] = stage
+
+		for len(lines) <= stage {
+			lines = append(lines, nil)
+		}
+		lines[stage] = append(lines[stage], node)
+	}
+
+	items := make([]vecty.MarkupOrChild, 0, len(lines))
+	for i, line := range lines {
+		tags := make([]vecty.MarkupOrChild, 0, 1+len(line))
+		tags = append(tags, addClass("tags"))
+		if i > 0 {
+			tags = append(tags, elem.Span(
+				addClass("tag", "is-white"),
+				addText("→"),
+			))
+		}
+		for _, node := range line {
+			tags = append(tags, elem.Span(
+				addClass("tag", "is-light"),
+				addText(node.Name+" @ "+workerName(node.WorkerID)),
+			))
+		}
+
+		items = append(items, elem.Div(tags...))
+	}
+
+	return elem.Div(items...)
+}
+
+func workerName(workerID uint64) string {
+	if worker, ok := cache.C().WorkerByID(workerID); ok {
+		return worker.Name
+	}
+
+	return fmt.Sprintf("#%d", workerID)
+}
+
+type EditPipeline struct {
+	vecty.Core
+	Modal
+
+	req *types.PipelineRequest
+	// needs are the comma separated needs of the nodes as typed.
+	needs []string
+}
+
+func NewEditPipeline() *EditPipeline {
+	return &EditPipeline{}
+}
+
+// SetPipeline edits the pipeline, a new one if pipeline is nil.
+func (view *EditPipeline) SetPipeline(pipeline *types.Pipeline) {
+	view.req = &types.PipelineRequest{}
+	view.needs = nil
+	if pipeline == nil {
+		view.addNode()
+		return
+	}
+
+	view.req.PipelineID = pipeline.ID
+	view.req.Name = pipeline.Name
+	view.req.Desc = pipeline.Desc
+	view.req.FailurePolicy = pipeline.FailurePolicy
+	for _, node := range pipeline.Nodes {
+		n := *node
+		view.req.Nodes = append(view.req.Nodes, &n)
+		view.needs = append(view.needs, strings.Join(node.Needs, ", "))
+	}
+}
+
+func (view *EditPipeline) Reset() {
+	view.req = nil
+	view.needs = nil
+	view.Modal.Reset()
+}
+
+func (view *EditPipeline) addNode() {
+	node := &types.PipelineNode{}
+	if len(view.req.Nodes) > 0 {
+		prev := view.req.Nodes[len(view.req.Nodes)-1]
+		node.WorkerID = prev.WorkerID
+		if len(prev.Name) > 0 {
+			node.Needs = []string{prev.Name}
+		}
+	} else if workers := runnableWorkers(); len(workers) > 0 {
+		node.WorkerID = workers[0].ID
+	}
+
+	view.req.Nodes = append(view.req.Nodes, node)
+	view.needs = append(view.needs, strings.Join(node.Needs, ", "))
+}
+
+func (view *EditPipeline) removeNode(i int) {
+	view.req.Nodes = append(view.req.Nodes[:i], view.req.Nodes[i+1:]...)
+	view.needs = append(view.needs[:i], view.needs[i+1:]...)
+}
+
+func (view *EditPipeline) save(event string) {
+	for i, node := range view.req.Nodes {
+		node.Needs = nil
+		for _, need := range strings.Split(view.needs[i], ",") {
+			if need = strings.TrimSpace(need); len(need) > 0 {
+				node.Needs = append(node.Needs, need)
+			}
+		}
+	}
+
+	control.DispatchEvent(
+		control.NewEvent(event).
+			Set("pipeline", view.req))
+}
+
+func (view *EditPipeline) Render() vecty.ComponentOrHTML {
+	if view.req == nil {
+		return elem.Div()
+	}
+
+	title, event, button := "Add pipeline:", control.EAddPipeline, "Add"
+	if view.req.PipelineID > 0 {
+		title, event, button = "Edit pipeline:", control.EUpdatePipeline, "Save"
+	}
+
+	policies := []string{
+		failurePolicyName(types.PipelineFailStop),
+		failurePolicyName(types.PipelineFailCancel),
+		failurePolicyName(types.PipelineFailContinue),
+	}
+
+	nodes := make([]vecty.MarkupOrChild, 0, 8+len(view.req.Nodes))
+	nodes = append(nodes,
+		elem.Div(
+			addClass("field", "has-addons"),
+
+			elem.Div(
+				addClass("control", "is-expanded"),
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "text"),
+					addProprety("placeholder", "Name"),
+					addProprety("value", view.req.Name),
+				}, func(s string) {
+					view.req.Name = s
+				}),
+			),
+
+			elem.Div(
+				addClass("control"),
+				addSelect(failurePolicyName(view.req.FailurePolicy), policies, func(value string) {
+					for policy := range types.PipelineFailurePolicyNames {
+						if failurePolicyName(policy) == value {
+							view.req.FailurePolicy = policy
+						}
+					}
+				}),
+			),
+		),
+
+		elem.Div(
+			addClass("field"),
+			elem.Div(
+				addClass("control"),
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "text"),
+					addProprety("placeholder", "Desc"),
+					addProprety("value", view.req.Desc),
+				}, func(s string) {
+					view.req.Desc = s
+				}),
+			),
+		),
+
+		elem.Paragraph(
+			addClass("help"),
+			addText("On failure: stop sends no more tasks, cancel also cancels the running ones, "+
+				"continue skips only the nodes which need the failed one."),
+		),
+
+		elem.HorizontalRule(),
+	)
+
+	for i := range view.req.Nodes {
+		nodes = append(nodes, view.renderNode(i))
+	}
+
+	nodes = append(nodes,
+		elem.Paragraph(
+			addClass("help"),
+			addText("A node runs after all the nodes it needs have succeeded, the nodes without needs run first."),
+		),
+
+		elem.Div(
+			addClass("field", "is-grouped"),
+
+			elem.Div(
+				addClass("control"),
+				elem.Anchor(
+					addClass("button"),
+					addText("Add node"),
+					onClick(func() {
+						if len(view.req.Nodes) < types.MaxPipelineNodes {
+							view.addNode()
+							rerender()
+						}
+					}),
+				),
+			),
+
+			elem.Div(
+				addClass("control"),
+				elem.Anchor(
+					addClass("button", "is-success"),
+					addText(button),
+					onClick(func() {
+						view.save(event)
+						view.Reset()
+						rerender()
+					}),
+				),
+			),
+		),
+	)
+
+	return view.Modal.Render(title, elem.Div(nodes...), view.Reset)
+}
+
+func (view *EditPipeline) renderNode(i int) *vecty.HTML {
+	node := view.req.Nodes[i]
+
+	var (
+		workers = runnableWorkers()
+		options = make([]string, 0, len(workers)+1)
+		current = ""
+	)
+	if _, ok := cache.C().WorkerByID(node.WorkerID); !ok && node.WorkerID > 0 {
+		current = workerOptionName(node.WorkerID)
+		options = append(options, current)
+	}
+	for _, w := range workers {
+		options = append(options, workerOptionName(w.ID))
+		if w.ID == node.WorkerID {
+			current = workerOptionName(w.ID)
+		}
+	}
+
+	return elem.Div(
+		addClass("field", "has-addons"),
+
+		elem.Div(
+			addClass("control"),
+			addInput([]vecty.MarkupOrChild{
+				addClass("input"),
+				addProprety("type", "text"),
+				addProprety("placeholder", "Node name"),
+				addProprety("value", node.Name),
+			}, func(s string) {
+				node.Name = s
+			}),
+		),
+
+		elem.Div(
+			addClass("control"),
+			addSelect(current, options, func(value string) {
+				for _, w := range workers {
+					if workerOptionName(w.ID) == value {
+						node.WorkerID = w.ID
+					}
+				}
+			}),
+		),
+
+		elem.Div(
+			addClass("control", "is-expanded"),
+			addInput([]vecty.MarkupOrChild{
+				addClass("input"),
+				addProprety("type", "text"),
+				addProprety("placeholder", "Params"),
+				addProprety("value", node.Params),
+			}, func(s string) {
+				node.Params = s
+			}),
+		),
+
+		elem.Div(
+			addClass("control"),
+			addInput([]vecty.MarkupOrChild{
+				addClass("input"),
+				addProprety("type", "text"),
+				addProprety("placeholder", "Needs, like build, test"),
+				addProprety("value", view.needs[i]),
+			}, func(s string) {
+				view.needs[i] = s
+			}),
+		),
+
+		elem.Div(
+			addClass("control"),
+			view.renderRetries(node),
+		),
+
+		elem.Div(
+			addClass("control"),
+			elem.Anchor(
+				addClass("button", "has-text-danger"),
+				addText("×"),
+				onClick(func() {
+					view.removeNode(i)
+					rerender()
+				}),
+			),
+		),
+	)
+}
+
+func (view *EditPipeline) renderRetries(node *types.PipelineNode) *vecty.HTML {
+	options := make([]string, 0, types.MaxTaskRetries+1)
+	for i := int8(0); i <= types.MaxTaskRetries; i++ {
+		options = append(options, retriesName(i))
+	}
+
+	return addSelect(retriesName(node.Retries), options, func(value string) {
+		for i := int8(0); i <= types.MaxTaskRetries; i++ {
+			if retriesName(i) == value {
+				node.Retries = i
+			}
+		}
+	})
+}
+
+func failurePolicyName(policy int8) string {
+	return "on failure: " + types.PipelineFailurePolicyNames[policy]
+}
+
+func workerOptionName(workerID uint64) string {
+	return fmt.Sprintf("%s #%d", workerName(workerID), workerID)
+}
+
+// runnableWorkers returns the workers the current user can send tasks to.
+func runnableWorkers() []*types.Worker {
+	var workers []*types.Worker
+	for _, w := range cache.C().Workers() {
+		if w.Level >= types.GrantRun {
+			workers = append(workers, w)
+		}
+	}
+
+	return workers
+}
+
+type PipelineRuns struct {
+	vecty.Core
+	Modal
+
+	pipeline *types.Pipeline
+}
+
+func NewPipelineRuns() *PipelineRuns {
+	view := &PipelineRuns{}
+	go view.updateList()
+	return view
+}
+
+func (view *PipelineRuns) SetPipeline(pipeline *types.Pipeline) {
+	view.pipeline = pipeline
+	cache.C().SetPipelineRuns(pipeline.ID, 0, nil)
+}
+
+func (view *PipelineRuns) Load() {
+	control.DispatchEvent(
+		control.NewEvent(control.EListPipelineRun).
+			Set("pipelineID", view.pipeline.ID))
+}
+
+func (view *PipelineRuns) Reset() {
+	view.pipeline = nil
+	cache.C().SetPipelineRuns(0, 0, nil)
+	view.Modal.Reset()
+}
+
+// updateList refreshes the runs while they are shown and some is running.
+func (view *PipelineRuns) updateList() {
+	for {
+		time.Sleep(2 * time.Second)
+		if !view.active || view.pipeline == nil {
+			continue
+		}
+
+		for _, run := range cache.C().PipelineRuns() {
+			if run.Status == types.PipelineRunning {
+				view.Load()
+				break
+			}
+		}
+	}
+}
+
+func (view *PipelineRuns) Render() vecty.ComponentOrHTML {
+	if view.pipeline == nil {
+		return elem.Div()
+	}
+
+	nodes := make([]vecty.MarkupOrChild, 0, 1+len(cache.C().PipelineRuns()))
+	if cache.C().PipelineRunPipelineID() == view.pipeline.ID {
+		for _, run := range cache.C().PipelineRuns() {
+			nodes = append(nodes, view.renderRun(run))
+		}
+
+		if cache.C().TotalPipelineRun() > int64(len(cache.C().PipelineRuns())) {
+			nodes = append(nodes, elem.Paragraph(
+				addClass("help"),
+				addText(fmt.Sprintf("The latest %d of %d runs.", len(cache.C().PipelineRuns()), cache.C().TotalPipelineRun())),
+			))
+		}
+	}
+
+	if len(nodes) == 0 {
+		nodes = append(nodes, elem.Paragraph(
+			addText("No run yet."),
+		))
+	}
+
+	return view.Modal.Render("Runs of "+view.pipeline.Name+":", elem.Div(nodes...), view.Reset)
+}
+
+func (view *PipelineRuns) renderRun(run *types.PipelineRun) *vecty.HTML {
+	statusColor, statusName := "is-loading", "running"
+	switch run.Status {
+	case types.PipelineSuccess:
+		statusColor, statusName = "is-success", "succeeded"
+	case types.PipelineFailed:
+		statusColor, statusName = "is-danger", "failed"
+	case types.PipelineCanceled:
+		statusColor, statusName = "is-warning", "canceled"
+	}
+
+	finishedAt := run.FinishedAt
+	if finishedAt == 0 {
+		finishedAt = time.Now().Unix()
+	}
+
+	var opNode *vecty.HTML
+	switch {
+	case run.Status != types.PipelineRunning:
+		opNode = elem.Span()
+	case run.CancelRequested:
+		opNode = elem.Span(
+			addClass("tag", "is-warning"),
+			addText("canceling"),
+		)
+	case view.pipeline.CanRun:
+		opNode = elem.Anchor(
+			addClass("button", "is-small", "has-text-danger"),
+			addText("Cancel"),
+			onClick(func() {
+				control.DispatchEvent(
+					control.NewEvent(control.ECancelPipelineRun).
+						Set("pipelineID", run.PipelineID).
+						Set("runID", run.ID))
+			}),
+		)
+	default:
+		opNode = elem.Span()
+	}
+
+	items := []vecty.MarkupOrChild{
+		addClass("box"),
+
+		addColumns(0, []vecty.MarkupOrChild{
+			elem.Span(
+				addClass("button", "is-small", "is-static", statusColor),
+				addText(fmt.Sprintf("#%d %s", run.ID, statusName)),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(run.Runner),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(time.Unix(run.CreatedAt, 0).Format("2006-01-02 15:04:05")),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText((time.Duration(finishedAt-run.CreatedAt) * time.Second).String()),
+			),
+
+			opNode,
+		}, []int{2, 2, 3, 2, 1}),
+	}
+
+	for _, node := range run.Nodes {
+		color := "is-light"
+		switch node.Status {
+		case types.PipelineNodeRunning:
+			color = "is-info"
+		case types.PipelineNodeSuccess:
+			color = "is-success"
+		case types.PipelineNodeFailed:
+			color = "is-danger"
+		case types.PipelineNodeCanceled:
+			color = "is-warning"
+		}
+
+		task := "-"
+		if node.TaskID > 0 {
+			task = fmt.Sprintf("task #%d", node.TaskID)
+		}
+
+		items = append(items, addColumns(0, []vecty.MarkupOrChild{
+			elem.Span(
+				addClass("tag", color),
+				addText(node.Name),
+			),
+
+			elem.Span(
+				addClass("tag", "is-white"),
+				addText(workerName(node.WorkerID)),
+			),
+
+			elem.Span(
+				addClass("tag", "is-white"),
+				addText(task),
+			),
+
+			elem.Span(
+				addClass("is-size-7"),
+				addText(pipelineNodeStatusName(node.Status)+" "+node.Detail),
+			),
+		}, []int{2, 2, 2, 4}))
+	}
+
+	return elem.Div(items...)
+}
+
+func pipelineNodeStatusName(status int8) string {
+	switch status {
+	case types.PipelineNodeRunning:
+		return "running"
+	case types.PipelineNodeSuccess:
+		return "succeeded"
+	case types.PipelineNodeFailed:
+		return "failed"
+	case types.PipelineNodeCanceled:
+		return "canceled"
+	case types.PipelineNodeSkipped:
+		return "skipped"
+	default:
+		return "pending"
+	}
+}
@@ -0,0 +1,126 @@
+package view
+
+import (
+	"time"
+
+	"github.com/tidyoux/router/server/web/cache"
+	"github.com/tidyoux/router/server/web/control"
+	"github.com/gopherjs/vecty"
+	"github.com/gopherjs/vecty/elem"
+)
+
+type Sessions struct {
+	vecty.Core
+	Modal
+
+	// userID is 0 for the current user's own sessions.
+	userID   uint64
+	userName string
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{}
+}
+
+func (view *Sessions) SetUser(userID uint64, userName string) {
+	view.userID = userID
+	view.userName = userName
+	cache.C().SetSessions(userID, nil)
+	control.DispatchEvent(
+		control.NewEvent(control.EListSession).
+			Set("userID", userID))
+}
+
+func (view *Sessions) Reset() {
+	view.userID = 0
+	view.userName = ""
+	view.Modal.Reset()
+}
+
+func (view *Sessions) Render() vecty.ComponentOrHTML {
+	title := "Active sessions:"
+	if view.userID > 0 {
+		title = "Active sessions of " + view.userName + ":"
+	}
+
+	var (
+		weights    = []int{2, 5, 2, 2, 1}
+		titleColor = "is-info"
+		nodes      = make([]vecty.MarkupOrChild, 0, 1+len(cache.C().Sessions()))
+	)
+
+	nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("IP"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("UserAgent"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("CreatedAt"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("LastUsedAt"),
+		),
+
+		elem.Span(),
+	}, weights))
+
+	if cache.C().SessionUserID() == view.userID {
+		for i := 0; i < len(cache.C().Sessions()); i++ {
+			session := cache.C().Sessions()[i]
+
+			var opNode *vecty.HTML
+			if session.Current {
+				opNode = elem.Span(
+					addClass("tag", "is-success"),
+					addText("current"),
+				)
+			} else {
+				opNode = elem.Anchor(
+					addClass("button", "is-small", "has-text-danger"),
+					addText("Revoke"),
+					onClick(func() {
+						control.DispatchEvent(
+							control.NewEvent(control.ERevokeSession).
+								Set("userID", view.userID).
+								Set("sessionID", session.ID))
+					}),
+				)
+			}
+
+			nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+				elem.Span(
+					addClass("tag"),
+					addText(session.IP),
+				),
+
+				elem.Span(
+					addClass("is-size-7"),
+					addText(session.UserAgent),
+				),
+
+				elem.Span(
+					addClass("tag"),
+					addText(time.Unix(session.CreatedAt, 0).Format("2006-01-02 15:04:05")),
+				),
+
+				elem.Span(
+					addClass("tag"),
+					addText(time.Unix(session.LastUsedAt, 0).Format("2006-01-02 15:04:05")),
+				),
+
+				opNode,
+			}, weights))
+		}
+	}
+
+	return view.Modal.Render(title, elem.Div(nodes...), view.Reset)
+}
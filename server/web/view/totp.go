@@ -0,0 +1,217 @@
+package view
+
+import (
+	"fmt"
+
+	"github.com/tidyoux/router/server/web/cache"
+	"github.com/tidyoux/router/server/web/control"
+	"github.com/gopherjs/vecty"
+	"github.com/gopherjs/vecty/elem"
+)
+
+type TwoFactor struct {
+	vecty.Core
+	Modal
+
+	code     string
+	password string
+}
+
+func NewTwoFactor() *TwoFactor {
+	return &TwoFactor{}
+}
+
+func (view *TwoFactor) Reset() {
+	view.code = ""
+	view.password = ""
+	cache.C().SetNewTOTP(nil)
+	cache.C().SetNewRecoveryCodes(nil)
+	view.Modal.Reset()
+}
+
+func (view *TwoFactor) Render() vecty.ComponentOrHTML {
+	var body *vecty.HTML
+	switch {
+	case cache.C().TOTPEnabled():
+		body = view.renderEnabled()
+	case cache.C().NewTOTP() != nil:
+		body = view.renderEnable()
+	default:
+		body = view.renderSetup()
+	}
+
+	return view.Modal.Render("Two-factor authentication:", elem.Div(
+		view.renderRecoveryCodes(),
+		body,
+	), view.Reset)
+}
+
+func (view *TwoFactor) renderRecoveryCodes() *vecty.HTML {
+	codes := cache.C().NewRecoveryCodes()
+	if len(codes) == 0 {
+		return elem.Div()
+	}
+
+	nodes := make([]vecty.MarkupOrChild, 0, len(codes)+2)
+	nodes = append(nodes,
+		addClass("notification", "is-success"),
+		elem.Paragraph(
+			addText("Save the recovery codes now, each of them works once in place of a code, they won't be shown again:"),
+		),
+	)
+	for _, code := range codes {
+		nodes = append(nodes, elem.Paragraph(
+			addClass("is-family-monospace"),
+			addText(code),
+		))
+	}
+
+	return elem.Div(nodes...)
+}
+
+func (view *TwoFactor) renderSetup() *vecty.HTML {
+	var required *vecty.HTML
+	if cache.C().TOTPRequired() {
+		required = elem.Paragraph(
+			addClass("notification", "is-warning"),
+			addText("2FA is required for your role, please set it up to continue."),
+		)
+	} else {
+		required = elem.Paragraph()
+	}
+
+	return elem.Div(
+		required,
+
+		elem.Paragraph(
+			addText("2FA is not enabled. After setting up, login needs a code of your authenticator app besides the password."),
+		),
+
+		elem.HorizontalRule(),
+
+		elem.Anchor(
+			addClass("button", "is-success"),
+			addText("Set up"),
+			onClick(func() {
+				control.DispatchEvent(control.NewEvent(control.ESetupTOTP))
+			}),
+		),
+	)
+}
+
+func (view *TwoFactor) renderEnable() *vecty.HTML {
+	totp := cache.C().NewTOTP()
+	return elem.Div(
+		elem.Paragraph(
+			addText("Add the account to your authenticator app by the link or the secret:"),
+		),
+
+		elem.Paragraph(
+			elem.Anchor(
+				addProprety("href", totp.URI),
+				addClass("is-family-monospace", "is-size-7"),
+				addText(totp.URI),
+			),
+		),
+
+		elem.Paragraph(
+			addClass("is-family-monospace"),
+			addText(totp.Secret),
+		),
+
+		elem.HorizontalRule(),
+
+		view.renderCodeForm("Enable", "is-success", control.EEnableTOTP),
+	)
+}
+
+func (view *TwoFactor) renderEnabled() *vecty.HTML {
+	var disable *vecty.HTML
+	if cache.C().TOTPRequired() {
+		disable = elem.Paragraph(
+			addClass("help"),
+			addText("2FA is required for your role, it can't be disabled."),
+		)
+	} else {
+		disable = elem.Div(
+			addClass("field", "has-addons"),
+
+			elem.Div(
+				addClass("control", "is-expanded"),
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "password"),
+					addProprety("placeholder", "Password"),
+					addProprety("value", view.password),
+				}, func(s string) {
+					view.password = s
+				}),
+			),
+
+			elem.Div(
+				addClass("control"),
+				elem.Button(
+					addClass("button", "is-danger"),
+					addText("Disable"),
+					onClick(func() {
+						control.DispatchEvent(
+							control.NewEvent(control.EDisableTOTP).
+								Set("password", view.password))
+						view.password = ""
+					}),
+				),
+			),
+		)
+	}
+
+	return elem.Div(
+		elem.Paragraph(
+			addText(fmt.Sprintf("2FA is enabled, %d recovery codes left.", cache.C().RecoveryCodesLeft())),
+		),
+
+		elem.HorizontalRule(),
+
+		elem.Label(
+			addClass("label"),
+			addText("Regenerate recovery codes, the old ones stop working:"),
+		),
+		view.renderCodeForm("Regenerate", "is-warning", control.ERegenerateRecoveryCodes),
+
+		elem.HorizontalRule(),
+
+		disable,
+	)
+}
+
+func (view *TwoFactor) renderCodeForm(name, color, event string) *vecty.HTML {
+	return elem.Div(
+		addClass("field", "has-addons"),
+
+		elem.Div(
+			addClass("control", "is-expanded"),
+			addInput([]vecty.MarkupOrChild{
+				addClass("input"),
+				addProprety("type", "text"),
+				addProprety("autocomplete", "one-time-code"),
+				addProprety("placeholder", "Code"),
+				addProprety("value", view.code),
+			}, func(s string) {
+				view.code = s
+			}),
+		),
+
+		elem.Div(
+			addClass("control"),
+			elem.Button(
+				addClass("button", color),
+				addText(name),
+				onClick(func() {
+					control.DispatchEvent(
+						control.NewEvent(event).
+							Set("code", view.code))
+					view.code = ""
+				}),
+			),
+		),
+	)
+}
@@ -3,6 +3,7 @@ package view
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/tidyoux/router/common/types"
 	"github.com/tidyoux/router/server/web/cache"
@@ -19,6 +20,10 @@ type WorkerDetail struct {
 
 	workerDesc string
 	editDesc   bool
+
+	maxRunTime     string
+	maxRunTimeErr  string
+	editMaxRunTime bool
 }
 
 func NewWorkerDetail() *WorkerDetail {
@@ -35,6 +40,9 @@ func (view *WorkerDetail) resetEdit() {
 	view.editName = false
 	view.workerDesc = ""
 	view.editDesc = false
+	view.maxRunTime = ""
+	view.maxRunTimeErr = ""
+	view.editMaxRunTime = false
 }
 
 func (view *WorkerDetail) SetWorker(worker *types.Worker) {
@@ -106,7 +114,7 @@ func (view *WorkerDetail) renderHeader() *vecty.HTML {
 		)
 	} else {
 		var editBtn *vecty.HTML
-		if view.worker.Status == types.WorkerEnabled {
+		if view.worker.Status == types.WorkerEnabled && view.worker.Level >= types.GrantManage {
 			editBtn = elem.Anchor(
 				elem.Span(
 					addClass("icon"),
@@ -208,7 +216,7 @@ func (view *WorkerDetail) renderBody() *vecty.HTML {
 		)
 	} else {
 		var editBtn *vecty.HTML
-		if view.worker.Status == types.WorkerEnabled {
+		if view.worker.Status == types.WorkerEnabled && view.worker.Level >= types.GrantManage {
 			editBtn = elem.Anchor(
 				addClass("level-item"),
 				elem.Span(
@@ -263,19 +271,164 @@ func (view *WorkerDetail) renderBody() *vecty.HTML {
 		elem.Div(
 			addClass("content"),
 
-			elem.Paragraph(
-				elem.Span(
-					addClass("tag", "has-text-danger"),
-					addText(fmt.Sprintf("key: %s", view.worker.Key)),
-				),
-			),
-
 			elem.Div(
 				addClass("box"),
 
 				descNode,
 			),
+
+			view.renderMaxRunTime(),
+			view.renderLeaseExpiredPolicy(),
+		),
+	)
+}
+
+func (view *WorkerDetail) renderLeaseExpiredPolicy() *vecty.HTML {
+	name := types.LeaseExpiredPolicyNames[view.worker.LeaseExpiredPolicy]
+
+	var policyNode *vecty.HTML
+	if view.worker.Status == types.WorkerEnabled && view.worker.Level >= types.GrantManage {
+		options := []string{
+			types.LeaseExpiredPolicyNames[types.LeaseExpiredRequeue],
+			types.LeaseExpiredPolicyNames[types.LeaseExpiredFail],
+		}
+
+		policyNode = addSelect(name, options, func(value string) {
+			for policy, name := range types.LeaseExpiredPolicyNames {
+				if name == value && policy != view.worker.LeaseExpiredPolicy {
+					control.DispatchEvent(
+						control.NewEvent(control.EUpdateWorker).
+							Set("workerID", view.worker.ID).
+							Set("leaseExpiredPolicy", policy))
+				}
+			}
+		})
+	} else {
+		policyNode = elem.Span(
+			addText(" " + name),
+		)
+	}
+
+	return elem.Div(
+		addClass("field", "is-grouped"),
+		elem.Div(
+			addClass("control"),
+			elem.Span(
+				addClass("tag"),
+				addText("When the lease of a task expires:"),
+			),
+		),
+		elem.Div(
+			addClass("control"),
+			policyNode,
+		),
+	)
+}
+
+func formatMaxRunTime(seconds int64) string {
+	if seconds == 0 {
+		return ""
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func (view *WorkerDetail) renderMaxRunTime() *vecty.HTML {
+	if view.editMaxRunTime {
+		help := elem.Paragraph(
+			addClass("help"),
+			addText("Tasks running longer fail for timeout, e.g. 2h30m, empty for the server's default."),
+		)
+		if len(view.maxRunTimeErr) > 0 {
+			help = elem.Paragraph(
+				addClass("help", "is-danger"),
+				addText(view.maxRunTimeErr),
+			)
+		}
+
+		return elem.Div(elem.Div(
+			addClass("field", "is-grouped"),
+			elem.Div(
+				addClass("control"),
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "text"),
+					addProprety("placeholder", "Max run time"),
+					addProprety("value", view.maxRunTime),
+				}, func(s string) {
+					view.maxRunTime = s
+				}),
+			),
+
+			elem.Div(
+				addClass("control"),
+				elem.Anchor(
+					addClass("button", "is-info"),
+					addText("Save"),
+					onClick(func() {
+						var seconds int64
+						if s := strings.TrimSpace(view.maxRunTime); len(s) > 0 {
+							d, err := time.ParseDuration(s)
+							if err != nil || d < 0 {
+								view.maxRunTimeErr = fmt.Sprintf("Invalid max run time %s", s)
+								rerender()
+								return
+							}
+							seconds = int64(d / time.Second)
+						}
+
+						view.resetEdit()
+						if seconds != view.worker.MaxRunTime {
+							control.DispatchEvent(
+								control.NewEvent(control.EUpdateWorker).
+									Set("workerID", view.worker.ID).
+									Set("maxRunTime", seconds))
+						} else {
+							rerender()
+						}
+					}),
+				),
+			),
+
+			view.renderCancelButton(),
+		), help)
+	}
+
+	maxRunTime := formatMaxRunTime(view.worker.MaxRunTime)
+	if len(maxRunTime) == 0 {
+		maxRunTime = "server's default"
+	}
+
+	var editBtn *vecty.HTML
+	if view.worker.Status == types.WorkerEnabled && view.worker.Level >= types.GrantManage {
+		editBtn = elem.Anchor(
+			elem.Span(
+				addClass("icon"),
+				addIcon("edit"),
+			),
+			onClick(func() {
+				view.maxRunTime = formatMaxRunTime(view.worker.MaxRunTime)
+				view.editMaxRunTime = true
+				rerender()
+			}),
+		)
+	} else {
+		editBtn = elem.Anchor(
+			elem.Span(
+				addClass("icon", "has-text-grey"),
+				addIcon("edit"),
+			),
+		)
+	}
+
+	return elem.Div(
+		elem.Span(
+			addClass("tag"),
+			addText("Max run time:"),
+		),
+		elem.Span(
+			addText(" "+maxRunTime+" "),
 		),
+		editBtn,
 	)
 }
 
@@ -0,0 +1,411 @@
+package view
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/web/cache"
+	"github.com/tidyoux/router/server/web/control"
+	"github.com/gopherjs/vecty"
+	"github.com/gopherjs/vecty/elem"
+)
+
+type ScheduleList struct {
+	vecty.Core
+
+	workerID     uint64
+	editSchedule *EditSchedule
+}
+
+func NewScheduleList(editSchedule *EditSchedule) *ScheduleList {
+	return &ScheduleList{
+		editSchedule: editSchedule,
+	}
+}
+
+func (view *ScheduleList) Reset() {
+	view.workerID = 0
+	cache.C().SetSchedules(0, nil)
+}
+
+func (view *ScheduleList) SetWorkerID(workerID uint64) {
+	view.workerID = workerID
+	cache.C().SetSchedules(workerID, nil)
+}
+
+func (view *ScheduleList) Load() {
+	control.DispatchEvent(
+		control.NewEvent(control.EListSchedule).
+			Set("workerID", view.workerID))
+}
+
+func (view *ScheduleList) Render() vecty.ComponentOrHTML {
+	var (
+		weights    = []int{2, 3, 1, 2, 2, 2}
+		titleColor = "is-info"
+		nodes      = make([]vecty.MarkupOrChild, 0, 1+len(cache.C().Schedules()))
+		canRun     bool
+	)
+
+	if worker, ok := cache.C().WorkerByID(view.workerID); ok {
+		canRun = worker.Level >= types.GrantRun
+	}
+
+	nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Schedule"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("Params"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("By"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("NextRunAt"),
+		),
+
+		elem.Span(
+			addClass("tag", titleColor),
+			addText("LastRun"),
+		),
+
+		elem.Span(),
+	}, weights))
+
+	if cache.C().ScheduleWorkerID() != view.workerID {
+		return elem.Div(nodes...)
+	}
+
+	formatTime := func(t int64, zero string) string {
+		if t == 0 {
+			return zero
+		}
+		return time.Unix(t, 0).Format("2006-01-02 15:04")
+	}
+
+	for i := 0; i < len(cache.C().Schedules()); i++ {
+		schedule := cache.C().Schedules()[i]
+
+		timeZone := schedule.TimeZone
+		if len(timeZone) == 0 {
+			timeZone = "UTC"
+		}
+
+		name := schedule.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", schedule.ID)
+		}
+
+		statusColor := "is-light"
+		if schedule.Enabled {
+			statusColor = "is-success"
+		}
+
+		lastRun := elem.Span(
+			addClass("tag"),
+			addText(formatTime(schedule.LastRunAt, "-")),
+		)
+		switch {
+		case len(schedule.LastError) > 0:
+			lastRun = elem.Span(
+				addClass("tag", "is-warning"),
+				addText(formatTime(schedule.LastRunAt, "-")+" skipped"),
+			)
+		case schedule.LastTaskID > 0:
+			lastRun = elem.Span(
+				addClass("tag"),
+				addText(fmt.Sprintf("%s task #%d", formatTime(schedule.LastRunAt, "-"), schedule.LastTaskID)),
+			)
+		}
+
+		opNode := elem.Span()
+		if canRun {
+			toggle := "Enable"
+			if schedule.Enabled {
+				toggle = "Disable"
+			}
+
+			opNode = elem.Div(
+				addClass("buttons", "are-small"),
+
+				elem.Anchor(
+					addClass("button"),
+					addText(toggle),
+					onClick(func() {
+						req := scheduleRequest(schedule)
+						req.Enabled = !schedule.Enabled
+						control.DispatchEvent(
+							control.NewEvent(control.EUpdateSchedule).
+								Set("workerID", view.workerID).
+								Set("schedule", req))
+					}),
+				),
+
+				elem.Anchor(
+					addClass("button"),
+					addText("Edit"),
+					onClick(func() {
+						view.editSchedule.SetSchedule(view.workerID, schedule)
+						view.editSchedule.Active()
+						rerender()
+					}),
+				),
+
+				elem.Anchor(
+					addClass("button", "has-text-danger"),
+					addText("Remove"),
+					onClick(func() {
+						control.DispatchEvent(
+							control.NewEvent(control.ERemoveSchedule).
+								Set("workerID", view.workerID).
+								Set("scheduleID", schedule.ID))
+					}),
+				),
+			)
+		}
+
+		nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
+			elem.Div(
+				elem.Span(
+					addClass("tag", statusColor),
+					addText(name),
+				),
+				elem.Span(
+					addClass("tag", "is-white", "is-family-monospace"),
+					addText(fmt.Sprintf("%s %s", schedule.Cron, timeZone)),
+				),
+			),
+
+			elem.Preformatted(
+				addText(schedule.Params),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(schedule.Owner),
+			),
+
+			elem.Span(
+				addClass("tag"),
+				addText(formatTime(schedule.NextRunAt, "disabled")),
+			),
+
+			lastRun,
+
+			opNode,
+		}, weights))
+
+		if len(schedule.LastError) > 0 {
+			nodes = append(nodes, addColumns(1, []vecty.MarkupOrChild{
+				elem.Paragraph(
+					addClass("help", "is-warning"),
+					addText("last run: "+schedule.LastError),
+				),
+			}, []int{10}))
+		}
+	}
+
+	return elem.Div(nodes...)
+}
+
+func scheduleRequest(schedule *types.Schedule) *types.ScheduleRequest {
+	return &types.ScheduleRequest{
+		ScheduleID: schedule.ID,
+		Name:       schedule.Name,
+		Params:     schedule.Params,
+		Cron:       schedule.Cron,
+		TimeZone:   schedule.TimeZone,
+		Enabled:    schedule.Enabled,
+		Retries:    schedule.Retries,
+		Priority:   schedule.Priority,
+	}
+}
+
+type EditSchedule struct {
+	vecty.Core
+	Modal
+
+	workerID uint64
+	req      *types.ScheduleRequest
+}
+
+func NewEditSchedule() *EditSchedule {
+	return &EditSchedule{}
+}
+
+// SetSchedule edits schedule of the worker, a new one if schedule is nil.
+func (view *EditSchedule) SetSchedule(workerID uint64, schedule *types.Schedule) {
+	view.workerID = workerID
+	if schedule == nil {
+		view.req = &types.ScheduleRequest{
+			WorkerID: workerID,
+			Enabled:  true,
+		}
+	} else {
+		view.req = scheduleRequest(schedule)
+	}
+}
+
+func (view *EditSchedule) Reset() {
+	view.workerID = 0
+	view.req = nil
+	view.Modal.Reset()
+}
+
+func (view *EditSchedule) Render() vecty.ComponentOrHTML {
+	if view.req == nil {
+		return elem.Div()
+	}
+
+	title, event, button := "Add schedule:", control.EAddSchedule, "Add"
+	if view.req.ScheduleID > 0 {
+		title, event, button = "Edit schedule:", control.EUpdateSchedule, "Save"
+	}
+
+	return view.Modal.Render(title, elem.Div(
+		view.renderInput("Name", view.req.Name, func(s string) {
+			view.req.Name = s
+		}),
+
+		view.renderInput("Params", view.req.Params, func(s string) {
+			view.req.Params = s
+		}),
+
+		elem.Div(
+			addClass("field", "has-addons"),
+
+			elem.Div(
+				addClass("control", "is-expanded"),
+				addInput([]vecty.MarkupOrChild{
+					addClass("input", "is-family-monospace"),
+					addProprety("type", "text"),
+					addProprety("placeholder", "Cron, like 30 2 * * * or @hourly"),
+					addProprety("value", view.req.Cron),
+				}, func(s string) {
+					view.req.Cron = s
+				}),
+			),
+
+			elem.Div(
+				addClass("control", "is-expanded"),
+				addInput([]vecty.MarkupOrChild{
+					addClass("input"),
+					addProprety("type", "text"),
+					addProprety("placeholder", "Time zone, like Asia/Shanghai, UTC if empty"),
+					addProprety("value", view.req.TimeZone),
+				}, func(s string) {
+					view.req.TimeZone = s
+				}),
+			),
+		),
+
+		elem.Paragraph(
+			addClass("help"),
+			addText("Fields of cron: minute, hour, day of month, month, day of week."),
+		),
+
+		elem.Div(
+			addClass("field", "is-grouped"),
+
+			view.renderRetries(),
+			view.renderPriority(),
+
+			elem.Div(
+				addClass("control"),
+				elem.Label(
+					addClass("checkbox"),
+					elem.Input(
+						addProprety("type", "checkbox"),
+						addProprety("checked", view.req.Enabled),
+						onCheckChange(func(checked bool) {
+							view.req.Enabled = checked
+						}),
+					),
+					addText(" enabled"),
+				),
+			),
+
+			elem.Div(
+				addClass("control"),
+				elem.Anchor(
+					addClass("button", "is-success"),
+					addText(button),
+					onClick(func() {
+						control.DispatchEvent(
+							control.NewEvent(event).
+								Set("workerID", view.workerID).
+								Set("schedule", view.req))
+						view.Reset()
+						rerender()
+					}),
+				),
+			),
+		),
+	), view.Reset)
+}
+
+func (view *EditSchedule) renderInput(placeholder, value string, h func(string)) *vecty.HTML {
+	return elem.Div(
+		addClass("field"),
+
+		elem.Div(
+			addClass("control"),
+			addInput([]vecty.MarkupOrChild{
+				addClass("input"),
+				addProprety("type", "text"),
+				addProprety("placeholder", placeholder),
+				addProprety("value", value),
+			}, h),
+		),
+	)
+}
+
+func (view *EditSchedule) renderRetries() *vecty.HTML {
+	options := make([]string, 0, types.MaxTaskRetries+1)
+	for i := int8(0); i <= types.MaxTaskRetries; i++ {
+		options = append(options, retriesName(i))
+	}
+
+	return elem.Div(
+		addClass("control"),
+		addSelect(retriesName(view.req.Retries), options, func(value string) {
+			for i := int8(0); i <= types.MaxTaskRetries; i++ {
+				if retriesName(i) == value {
+					view.req.Retries = i
+				}
+			}
+		}),
+	)
+}
+
+func (view *EditSchedule) renderPriority() *vecty.HTML {
+	maxPriority := cache.C().MaxTaskPriority()
+	if maxPriority == 0 {
+		return elem.Div()
+	}
+
+	options := make([]string, 0, maxPriority+1)
+	for i := int8(0); i <= maxPriority; i++ {
+		options = append(options, priorityName(i))
+	}
+
+	return elem.Div(
+		addClass("control"),
+		addSelect(priorityName(view.req.Priority), options, func(value string) {
+			for i := int8(0); i <= maxPriority; i++ {
+				if priorityName(i) == value {
+					view.req.Priority = i
+				}
+			}
+		}),
+	)
+}
@@ -13,17 +13,34 @@ import (
 	"github.com/tidyoux/lisc"
 )
 
+// Worker tabs.
+const (
+	WorkerTabTasks     = "Tasks"
+	WorkerTabSchedules = "Schedules"
+)
+
+var (
+	WorkerTabs = []string{WorkerTabTasks, WorkerTabSchedules}
+)
+
 type WorkerTasks struct {
-	taskList   *TaskList
-	createTask *CreateTask
+	taskList     *TaskList
+	createTask   *CreateTask
+	scheduleList *ScheduleList
+	editSchedule *EditSchedule
 
 	worker *types.Worker
+	tab    string
 }
 
 func NewWorkerTasks() *WorkerTasks {
+	editSchedule := NewEditSchedule()
 	return &WorkerTasks{
-		taskList:   NewTaskList(),
-		createTask: NewCreateTask(),
+		taskList:     NewTaskList(),
+		createTask:   NewCreateTask(),
+		scheduleList: NewScheduleList(editSchedule),
+		editSchedule: editSchedule,
+		tab:          WorkerTabTasks,
 	}
 }
 
@@ -31,11 +48,17 @@ func (view *WorkerTasks) Reset() {
 	view.worker = nil
 	view.taskList.Reset()
 	view.createTask.Reset()
+	view.scheduleList.Reset()
+	view.editSchedule.Reset()
 }
 
 func (view *WorkerTasks) SetWorker(worker *types.Worker) {
 	view.worker = worker
 	view.taskList.SetWorkerID(worker.ID)
+	view.scheduleList.SetWorkerID(worker.ID)
+	if view.tab == WorkerTabSchedules {
+		view.scheduleList.Load()
+	}
 }
 
 func (view *WorkerTasks) Render() vecty.ComponentOrHTML {
@@ -52,17 +75,57 @@ func (view *WorkerTasks) Render() vecty.ComponentOrHTML {
 		return elem.Div()
 	}
 
+	if view.tab == WorkerTabSchedules {
+		return elem.Div(
+			addClass("box"),
+			view.renderTabs(),
+			view.renderHeader(),
+			view.scheduleList,
+			view.editSchedule,
+		)
+	}
+
 	return elem.Div(
 		addClass("box"),
+		view.renderTabs(),
 		view.renderHeader(),
 		view.taskList,
 		view.createTask,
 	)
 }
 
+func (view *WorkerTasks) renderTabs() *vecty.HTML {
+	items := make([]vecty.MarkupOrChild, 0, len(WorkerTabs))
+	for _, tab := range WorkerTabs {
+		tab := tab
+
+		var markups []vecty.MarkupOrChild
+		if tab == view.tab {
+			markups = append(markups, addClass("is-active"))
+		}
+		markups = append(markups, elem.Anchor(
+			addText(tab),
+			onClick(func() {
+				view.tab = tab
+				if tab == WorkerTabSchedules {
+					view.scheduleList.Load()
+				}
+				rerender()
+			}),
+		))
+
+		items = append(items, elem.ListItem(markups...))
+	}
+
+	return elem.Div(
+		addClass("tabs"),
+		elem.UnorderedList(items...),
+	)
+}
+
 func (view *WorkerTasks) renderHeader() *vecty.HTML {
 	var newBtn *vecty.HTML
-	if view.worker.Status == types.WorkerEnabled {
+	if view.worker.Status == types.WorkerEnabled && view.worker.Level >= types.GrantRun {
 		newBtn = elem.Anchor(
 			addClass("level-item", "button", "is-success"),
 
@@ -71,8 +134,13 @@ func (view *WorkerTasks) renderHeader() *vecty.HTML {
 			),
 
 			onClick(func() {
-				view.createTask.SetWorker(view.worker.ID)
-				view.createTask.Active()
+				if view.tab == WorkerTabSchedules {
+					view.editSchedule.SetSchedule(view.worker.ID, nil)
+					view.editSchedule.Active()
+				} else {
+					view.createTask.SetWorker(view.worker.ID)
+					view.createTask.Active()
+				}
 				rerender()
 			}),
 		)
@@ -95,7 +163,7 @@ func (view *WorkerTasks) renderHeader() *vecty.HTML {
 			elem.Span(
 				addClass("level-item"),
 				elem.Strong(
-					addText("Tasks:"),
+					addText(view.tab+":"),
 				),
 			),
 		),
@@ -134,11 +202,16 @@ func (view *TaskList) Render() vecty.ComponentOrHTML {
 	defer func() { view.rendered = true }()
 
 	var (
-		weights    = []int{1, 4, 1, 1, 2, 2}
+		weights    = []int{1, 4, 1, 1, 2, 2, 1}
 		titleColor = "is-info"
 		nodes      = make([]vecty.MarkupOrChild, 0, 1+len(cache.C().Tasks()))
+		canRun     bool
 	)
 
+	if worker, ok := cache.C().WorkerByID(view.workerID); ok {
+		canRun = worker.Status == types.WorkerEnabled && worker.Level >= types.GrantRun
+	}
+
 	nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
 		elem.Span(
 			addClass("tag", titleColor),
@@ -169,6 +242,8 @@ func (view *TaskList) Render() vecty.ComponentOrHTML {
 			addClass("tag", titleColor),
 			addText("UpdatedAt"),
 		),
+
+		elem.Span(),
 	}, weights))
 
 	for i := 0; i < len(cache.C().Tasks()); i++ {
@@ -196,6 +271,100 @@ func (view *TaskList) Render() vecty.ComponentOrHTML {
 		case types.TaskFailed:
 			statusColor = "is-danger"
 			statusIcon = "exclamation-circle"
+		case types.TaskCanceled:
+			statusColor = "is-warning"
+			statusIcon = "ban"
+		}
+
+		var opNode *vecty.HTML
+		switch {
+		case task.CancelRequested && task.Status == types.TaskAccepted:
+			opNode = elem.Span(
+				addClass("tag", "is-warning"),
+				addText("canceling"),
+			)
+		case canRun && task.Status <= types.TaskAccepted:
+			opNode = elem.Anchor(
+				addClass("button", "is-small", "has-text-danger"),
+				addText("Cancel"),
+				onClick(func() {
+					control.DispatchEvent(
+						control.NewEvent(control.ECancelTask).
+							Set("workerID", view.workerID).
+							Set("taskID", task.ID))
+				}),
+			)
+		default:
+			opNode = elem.Span()
+		}
+
+		attemptNode := elem.Span()
+		if task.MaxAttempts > 1 {
+			attemptNode = elem.Span(
+				addClass("tag", "is-white"),
+				addText(fmt.Sprintf("attempt %d/%d", task.Attempt, task.MaxAttempts)),
+			)
+		}
+
+		queueNode := elem.Span()
+		if task.Status == types.TaskRecord && task.RunAt > now {
+			queueNode = elem.Span(
+				addClass("tag", "is-info", "is-light"),
+				addText(fmt.Sprintf("runs in %s at %s", time.Duration(task.RunAt-now)*time.Second,
+					time.Unix(task.RunAt, 0).Format("01-02 15:04"))),
+			)
+		} else if task.Status == types.TaskRecord && task.QueuePosition > 0 {
+			queue := fmt.Sprintf("queued #%d", task.QueuePosition)
+			if task.Priority > 0 {
+				queue += fmt.Sprintf(", priority %d", task.Priority)
+			}
+
+			queueNode = elem.Span(
+				addClass("tag", "is-white"),
+				addText(queue),
+			)
+		}
+
+		scheduleNode := elem.Span()
+		if task.ScheduleID > 0 {
+			scheduleNode = elem.Span(
+				addClass("tag", "is-white"),
+				addText(fmt.Sprintf("schedule #%d", task.ScheduleID)),
+			)
+		}
+
+		pipelineNode := elem.Span()
+		if task.PipelineRunID > 0 {
+			pipelineNode = elem.Span(
+				addClass("tag", "is-white"),
+				addText(fmt.Sprintf("pipeline run #%d", task.PipelineRunID)),
+			)
+		}
+
+		acceptedNode := elem.Span()
+		if task.Status == types.TaskAccepted && task.AcceptedAt > 0 {
+			accepted := "accepted " + time.Unix(task.AcceptedAt, 0).Format("01-02 15:04:05")
+			if task.Deadline > 0 {
+				accepted += ", deadline " + time.Unix(task.Deadline, 0).Format("01-02 15:04:05")
+			}
+
+			acceptedNode = elem.Span(
+				addClass("tag", "is-white"),
+				addText(accepted),
+			)
+		}
+
+		leaseNode := elem.Span()
+		if task.Status == types.TaskAccepted && task.LeaseOwner > 0 {
+			lease := fmt.Sprintf("agent #%d", task.LeaseOwner)
+			if task.LeaseExpiredAt > 0 {
+				lease += time.Unix(task.LeaseExpiredAt, 0).Format(" until 15:04:05")
+			}
+
+			leaseNode = elem.Span(
+				addClass("tag", "is-white"),
+				addText(lease),
+			)
 		}
 
 		nodes = append(nodes, addColumns(0, []vecty.MarkupOrChild{
@@ -210,6 +379,12 @@ func (view *TaskList) Render() vecty.ComponentOrHTML {
 						addText(fmt.Sprintf("#%d", task.ID)),
 					),
 				),
+				attemptNode,
+				queueNode,
+				scheduleNode,
+				pipelineNode,
+				acceptedNode,
+				leaseNode,
 			),
 
 			elem.Preformatted(
@@ -235,18 +410,43 @@ func (view *TaskList) Render() vecty.ComponentOrHTML {
 				addClass("tag"),
 				addText(time.Unix(task.UpdatedAt, 0).Format("2006-01-02 15:04:05")),
 			),
+
+			opNode,
 		}, weights))
 
+		details := make([]vecty.MarkupOrChild, 0, 1+len(task.Attempts))
+		for _, a := range task.Attempts {
+			details = append(details, elem.Preformatted(
+				addClass("has-text-grey"),
+				addText(fmt.Sprintf("attempt %d/%d %s at step index %d:\n%s",
+					a.Attempt, task.MaxAttempts, taskStatusName(a.Status), a.Progress, a.Detail)),
+			))
+		}
+		details = append(details, elem.Preformatted(
+			addText(task.Detail),
+		))
+
 		nodes = append(nodes, addColumns(1, []vecty.MarkupOrChild{
-			elem.Preformatted(
-				addText(task.Detail),
-			),
+			elem.Div(details...),
 		}, []int{10}))
 	}
 
 	return elem.Div(nodes...)
 }
 
+func taskStatusName(status int8) string {
+	switch status {
+	case types.TaskSuccess:
+		return "succeeded"
+	case types.TaskFailed:
+		return "failed"
+	case types.TaskCanceled:
+		return "canceled"
+	default:
+		return "unfinished"
+	}
+}
+
 func (view *TaskList) updateList() {
 	for {
 		time.Sleep(time.Second)
@@ -276,6 +476,11 @@ type CreateTask struct {
 
 	params    []string
 	extParams string
+	retries   int8
+	priority  int8
+	// runAt is the value of the datetime-local input in local time, empty to run at once.
+	runAt    string
+	runAtErr string
 }
 
 func NewCreateTask() *CreateTask {
@@ -350,6 +555,10 @@ func (view *CreateTask) Reset() {
 	view.paramFormat = nil
 	view.params = nil
 	view.extParams = ""
+	view.retries = 0
+	view.priority = 0
+	view.runAt = ""
+	view.runAtErr = ""
 	view.Modal.Reset()
 }
 
@@ -360,9 +569,14 @@ func (view *CreateTask) Render() vecty.ComponentOrHTML {
 
 	if len(view.paramFormat) == 0 {
 		return view.Modal.Render("Create task:", elem.Div(
-			addClass("field", "has-addons"),
-			view.renderInput(),
-			view.renderSendButton(),
+			elem.Div(
+				addClass("field", "has-addons"),
+				view.renderInput(),
+				view.renderRetries(),
+				view.renderPriority(),
+				view.renderSendButton(),
+			),
+			view.renderRunAt(),
 		), view.Reset)
 	}
 
@@ -370,9 +584,14 @@ func (view *CreateTask) Render() vecty.ComponentOrHTML {
 		addClass("field", "has-addons"),
 	}
 	markups = append(markups, view.renderInputs()...)
+	markups = append(markups, view.renderRetries())
+	markups = append(markups, view.renderPriority())
 	markups = append(markups, view.renderSendButton())
 
-	return view.Modal.Render("Create task:", elem.Div(markups...), view.Reset)
+	return view.Modal.Render("Create task:", elem.Div(
+		elem.Div(markups...),
+		view.renderRunAt(),
+	), view.Reset)
 }
 
 func (view *CreateTask) renderInputs() []vecty.MarkupOrChild {
@@ -432,6 +651,115 @@ func (view *CreateTask) renderInput() vecty.MarkupOrChild {
 	)
 }
 
+func retriesName(retries int8) string {
+	switch retries {
+	case 0:
+		return "no retry"
+	case 1:
+		return "1 retry"
+	default:
+		return fmt.Sprintf("%d retries", retries)
+	}
+}
+
+func (view *CreateTask) renderRetries() vecty.MarkupOrChild {
+	options := make([]string, 0, types.MaxTaskRetries+1)
+	for i := int8(0); i <= types.MaxTaskRetries; i++ {
+		options = append(options, retriesName(i))
+	}
+
+	return elem.Div(
+		addClass("control"),
+		addSelect(retriesName(view.retries), options, func(value string) {
+			for i := int8(0); i <= types.MaxTaskRetries; i++ {
+				if retriesName(i) == value {
+					view.retries = i
+				}
+			}
+		}),
+	)
+}
+
+func priorityName(priority int8) string {
+	return fmt.Sprintf("priority %d", priority)
+}
+
+func (view *CreateTask) renderPriority() vecty.MarkupOrChild {
+	maxPriority := cache.C().MaxTaskPriority()
+	if maxPriority == 0 {
+		return elem.Div()
+	}
+
+	options := make([]string, 0, maxPriority+1)
+	for i := int8(0); i <= maxPriority; i++ {
+		options = append(options, priorityName(i))
+	}
+
+	return elem.Div(
+		addClass("control"),
+		addSelect(priorityName(view.priority), options, func(value string) {
+			for i := int8(0); i <= maxPriority; i++ {
+				if priorityName(i) == value {
+					view.priority = i
+				}
+			}
+		}),
+	)
+}
+
+const runAtLayout = "2006-01-02T15:04"
+
+func (view *CreateTask) renderRunAt() vecty.MarkupOrChild {
+	var (
+		help    = "Run at once, or pick a time to delay the task to, like a maintenance window."
+		helpCls = []string{"help"}
+	)
+	if len(view.runAtErr) > 0 {
+		help = view.runAtErr
+		helpCls = append(helpCls, "is-danger")
+	}
+
+	return elem.Div(
+		addClass("field"),
+
+		elem.Label(
+			addClass("label", "is-small"),
+			addText("Run at:"),
+		),
+
+		elem.Div(
+			addClass("control"),
+			addInput([]vecty.MarkupOrChild{
+				addClass("input"),
+				addProprety("type", "datetime-local"),
+				addProprety("min", time.Now().Format(runAtLayout)),
+				addProprety("value", view.runAt),
+			}, func(s string) {
+				view.runAt = s
+			}),
+		),
+
+		elem.Paragraph(
+			addClass(helpCls...),
+			addText(help),
+		),
+	)
+}
+
+// parseRunAt returns the unix time of the run at input, 0 to run at once.
+func (view *CreateTask) parseRunAt() (int64, error) {
+	if len(view.runAt) == 0 {
+		return 0, nil
+	}
+
+	t, err := time.ParseInLocation(runAtLayout, view.runAt, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("invalid run at time %s", view.runAt)
+	}
+
+	return t.Unix(), nil
+}
+
 func (view *CreateTask) renderSendButton() vecty.MarkupOrChild {
 	return elem.Div(
 		addClass("control"),
@@ -443,6 +771,13 @@ func (view *CreateTask) renderSendButton() vecty.MarkupOrChild {
 				addIcon("paper-plane"),
 			),
 			onClick(func() {
+				runAt, err := view.parseRunAt()
+				if err != nil {
+					view.runAtErr = err.Error()
+					vecty.Rerender(view)
+					return
+				}
+
 				var params string
 				if len(view.params) == 0 {
 					params = view.extParams
@@ -455,7 +790,10 @@ func (view *CreateTask) renderSendButton() vecty.MarkupOrChild {
 				control.DispatchEvent(
 					control.NewEvent(control.ESendTask).
 						Set("workerID", view.workerID).
-						Set("params", params))
+						Set("params", params).
+						Set("retries", view.retries).
+						Set("priority", view.priority).
+						Set("runAt", runAt))
 				view.Reset()
 			}),
 		),
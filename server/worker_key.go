@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidyoux/router/common/crypto"
+	"github.com/tidyoux/router/common/types"
+	"github.com/tidyoux/router/server/model"
+	"github.com/jinzhu/gorm"
+)
+
+// workerKeyHashPrefix tells hashed worker keys from the legacy plain ones.
+const workerKeyHashPrefix = "sha256:"
+
+var (
+	errWorkerNotExist = fmt.Errorf("worker not exist")
+)
+
+// GenWorkerKey returns a new random worker key, only its hash should be stored.
+func GenWorkerKey() string {
+	return hex.EncodeToString(crypto.RandBytes(32))
+}
+
+func HashWorkerKey(key string) string {
+	return workerKeyHashPrefix + crypto.Sum([]byte(key)).String()
+}
+
+func workerKeyMatch(hash, key string) bool {
+	if len(hash) == 0 {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashWorkerKey(key))) == 1
+}
+
+// verifyWorkerKey checks key against the worker's key, and its old key in the grace period.
+func verifyWorkerKey(worker *model.Worker, key string, now time.Time) bool {
+	if workerKeyMatch(worker.Key, key) {
+		return true
+	}
+
+	if worker.OldKeyExpiredAt != nil && now.Before(*worker.OldKeyExpiredAt) {
+		return workerKeyMatch(worker.OldKey, key)
+	}
+
+	return false
+}
+
+// RotateWorkerKey replaces the worker's key with a new one and logs out its agents.
+// The old key keeps working for grace if it is positive.
+func (s *Server) RotateWorkerKey(workerID uint64, grace time.Duration) (string, error) {
+	s.rwMutex.Lock()
+	defer s.rwMutex.Unlock()
+
+	worker, err := model.FindWorkerByID(workerID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", errWorkerNotExist
+		}
+
+		return "", fmt.Errorf("db find worker failed, %v", err)
+	}
+
+	return s.rotateWorkerKey(worker, grace)
+}
+
+func (s *Server) rotateWorkerKey(worker *model.Worker, grace time.Duration) (string, error) {
+	var (
+		oldKey          string
+		oldKeyExpiredAt *time.Time
+	)
+	if grace > 0 {
+		t := time.Now().Add(grace)
+		oldKey = worker.Key
+		oldKeyExpiredAt = &t
+	}
+
+	key := GenWorkerKey()
+	err := worker.UpdateKey(HashWorkerKey(key), oldKey, oldKeyExpiredAt)
+	if err != nil {
+		return "", fmt.Errorf("db update worker key failed, %v", err)
+	}
+
+	err = s.sessions.DeleteByOwner(types.SessionAgent, uint64(worker.ID))
+	if err != nil {
+		return "", fmt.Errorf("db delete agent sessions failed, %v", err)
+	}
+
+	return key, nil
+}
+
+// migrateWorkerKeys hashes the legacy plain worker keys.
+func migrateWorkerKeys() error {
+	workers, err := model.FindAllWorkers()
+	if err != nil {
+		return fmt.Errorf("db find workers failed, %v", err)
+	}
+
+	for _, w := range workers {
+		if strings.HasPrefix(w.Key, workerKeyHashPrefix) {
+			continue
+		}
+
+		err = w.UpdateKey(HashWorkerKey(w.Key), "", nil)
+		if err != nil {
+			return fmt.Errorf("db update worker %d key failed, %v", w.ID, err)
+		}
+	}
+
+	return nil
+}